@@ -0,0 +1,326 @@
+// Package access implements static and runtime-editable allow and deny lists
+// that are consulted before a request reaches the rate limiter.
+package access
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Action is the list a Rule belongs to.
+type Action string
+
+const (
+	// ActionAllow rules let matching requests bypass the rate limiter.
+	ActionAllow Action = "allow"
+
+	// ActionDeny rules reject matching requests outright.
+	ActionDeny Action = "deny"
+)
+
+// Decision is the result of checking a request against a List.
+type Decision int
+
+const (
+	// NoMatch means the request matched neither list and should be rate
+	// limited as usual.
+	NoMatch Decision = iota
+
+	// Allow means the request matched the allowlist.
+	Allow
+
+	// Deny means the request matched the denylist.
+	Deny
+)
+
+// DefaultDenyBody is the response body sent to denylisted clients unless
+// configured otherwise.
+const DefaultDenyBody = "Forbidden\n"
+
+// Rule is a single allow or deny entry. Exactly one of CIDR, Key or Header
+// must be set. Header rules match when the named request header has exactly
+// the given Value.
+type Rule struct {
+	Action Action `json:"action"`
+	CIDR   string `json:"cidr,omitempty"`
+	Key    string `json:"key,omitempty"`
+	Header string `json:"header,omitempty"`
+	Value  string `json:"value,omitempty"`
+}
+
+// ParseRule parses the textual form of a rule used in static configuration.
+// The accepted forms are "key:<bucket key>", "header:<Name>=<value>", and an
+// IP address or CIDR prefix with an optional "cidr:" prefix.
+func ParseRule(action Action, s string) (Rule, error) {
+	s = strings.TrimSpace(s)
+	rule := Rule{Action: action}
+	switch {
+	case strings.HasPrefix(s, "key:"):
+		rule.Key = strings.TrimPrefix(s, "key:")
+	case strings.HasPrefix(s, "header:"):
+		name, value, ok := strings.Cut(strings.TrimPrefix(s, "header:"), "=")
+		if !ok {
+			return Rule{}, fmt.Errorf("header rule %q must have the form header:Name=value", s)
+		}
+		rule.Header = name
+		rule.Value = value
+	default:
+		rule.CIDR = strings.TrimPrefix(s, "cidr:")
+	}
+	if err := rule.validate(); err != nil {
+		return Rule{}, err
+	}
+	return rule, nil
+}
+
+func (r Rule) validate() error {
+	if r.Action != ActionAllow && r.Action != ActionDeny {
+		return fmt.Errorf("unknown action %q", r.Action)
+	}
+	set := 0
+	for _, field := range []string{r.CIDR, r.Key, r.Header} {
+		if field != "" {
+			set++
+		}
+	}
+	if set != 1 {
+		return errors.New("exactly one of cidr, key or header must be set")
+	}
+	if r.CIDR != "" {
+		if _, err := parsePrefix(r.CIDR); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parsePrefix parses a CIDR prefix, accepting a bare address as a single host
+// prefix.
+func parsePrefix(s string) (netip.Prefix, error) {
+	if !strings.Contains(s, "/") {
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid address %q: %w", s, err)
+		}
+		addr = addr.Unmap().WithZone("")
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+	prefix, err := netip.ParsePrefix(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid CIDR %q: %w", s, err)
+	}
+	return prefix.Masked(), nil
+}
+
+// List holds the allow and deny rules. It is safe for concurrent use.
+type List struct {
+	mu    sync.RWMutex
+	allow ruleSet
+	deny  ruleSet
+}
+
+// NewList creates a List containing the given rules.
+func NewList(rules ...Rule) (*List, error) {
+	l := &List{}
+	for _, rule := range rules {
+		if err := l.Add(rule); err != nil {
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// Check matches a request against the list. The ip may be the zero Addr if the
+// client address is unknown, in which case CIDR rules never match. Deny rules
+// take precedence over allow rules.
+func (l *List) Check(ip netip.Addr, key string, header http.Header) Decision {
+	ip = ip.Unmap().WithZone("")
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if l.deny.matches(ip, key, header) {
+		return Deny
+	}
+	if l.allow.matches(ip, key, header) {
+		return Allow
+	}
+	return NoMatch
+}
+
+// Add inserts a rule into the list. Adding a rule that already exists is a
+// no-op.
+func (l *List) Add(rule Rule) error {
+	if err := rule.validate(); err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.set(rule.Action).add(rule)
+	return nil
+}
+
+// Remove deletes a rule from the list, reporting whether it was present.
+func (l *List) Remove(rule Rule) (bool, error) {
+	if err := rule.validate(); err != nil {
+		return false, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.set(rule.Action).remove(rule), nil
+}
+
+// Rules returns a snapshot of all rules in the list, deny rules first.
+func (l *List) Rules() []Rule {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return append(l.deny.rules(ActionDeny), l.allow.rules(ActionAllow)...)
+}
+
+func (l *List) set(action Action) *ruleSet {
+	if action == ActionDeny {
+		return &l.deny
+	}
+	return &l.allow
+}
+
+// ruleSet indexes rules for constant time lookups. Prefixes are grouped by
+// length so that matching an address costs one map lookup per distinct prefix
+// length rather than one comparison per rule.
+type ruleSet struct {
+	prefixes map[int]map[netip.Prefix]struct{}
+	keys     map[string]struct{}
+	headers  map[string]map[string]struct{}
+}
+
+func (s *ruleSet) add(rule Rule) {
+	switch {
+	case rule.CIDR != "":
+		prefix, _ := parsePrefix(rule.CIDR)
+		if s.prefixes == nil {
+			s.prefixes = map[int]map[netip.Prefix]struct{}{}
+		}
+		bits := s.prefixes[prefix.Bits()]
+		if bits == nil {
+			bits = map[netip.Prefix]struct{}{}
+			s.prefixes[prefix.Bits()] = bits
+		}
+		bits[prefix] = struct{}{}
+	case rule.Key != "":
+		if s.keys == nil {
+			s.keys = map[string]struct{}{}
+		}
+		s.keys[rule.Key] = struct{}{}
+	default:
+		name := http.CanonicalHeaderKey(rule.Header)
+		if s.headers == nil {
+			s.headers = map[string]map[string]struct{}{}
+		}
+		values := s.headers[name]
+		if values == nil {
+			values = map[string]struct{}{}
+			s.headers[name] = values
+		}
+		values[rule.Value] = struct{}{}
+	}
+}
+
+func (s *ruleSet) remove(rule Rule) bool {
+	switch {
+	case rule.CIDR != "":
+		prefix, _ := parsePrefix(rule.CIDR)
+		bits := s.prefixes[prefix.Bits()]
+		if _, ok := bits[prefix]; !ok {
+			return false
+		}
+		delete(bits, prefix)
+		if len(bits) == 0 {
+			delete(s.prefixes, prefix.Bits())
+		}
+	case rule.Key != "":
+		if _, ok := s.keys[rule.Key]; !ok {
+			return false
+		}
+		delete(s.keys, rule.Key)
+	default:
+		name := http.CanonicalHeaderKey(rule.Header)
+		values := s.headers[name]
+		if _, ok := values[rule.Value]; !ok {
+			return false
+		}
+		delete(values, rule.Value)
+		if len(values) == 0 {
+			delete(s.headers, name)
+		}
+	}
+	return true
+}
+
+func (s *ruleSet) matches(ip netip.Addr, key string, header http.Header) bool {
+	if _, ok := s.keys[key]; ok {
+		return true
+	}
+	if ip.IsValid() {
+		for bits, prefixes := range s.prefixes {
+			if bits > ip.BitLen() {
+				continue
+			}
+			prefix, err := ip.Prefix(bits)
+			if err != nil {
+				continue
+			}
+			if _, ok := prefixes[prefix]; ok {
+				return true
+			}
+		}
+	}
+	for name, values := range s.headers {
+		for _, value := range header.Values(name) {
+			if _, ok := values[value]; ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (s *ruleSet) rules(action Action) []Rule {
+	var rules []Rule
+	for _, prefixes := range s.prefixes {
+		for prefix := range prefixes {
+			rules = append(rules, Rule{Action: action, CIDR: prefix.String()})
+		}
+	}
+	for key := range s.keys {
+		rules = append(rules, Rule{Action: action, Key: key})
+	}
+	for name, values := range s.headers {
+		for value := range values {
+			rules = append(rules, Rule{Action: action, Header: name, Value: value})
+		}
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		a, b := rules[i], rules[j]
+		if a.CIDR != b.CIDR {
+			return a.CIDR < b.CIDR
+		}
+		if a.Key != b.Key {
+			return a.Key < b.Key
+		}
+		if a.Header != b.Header {
+			return a.Header < b.Header
+		}
+		return a.Value < b.Value
+	})
+	return rules
+}
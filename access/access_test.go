@@ -0,0 +1,151 @@
+package access
+
+import (
+	"net/http"
+	"net/netip"
+	"testing"
+)
+
+func TestParseRule(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected Rule
+		wantErr  bool
+	}{
+		{
+			name:     "bare address",
+			input:    "10.1.2.3",
+			expected: Rule{Action: ActionAllow, CIDR: "10.1.2.3"},
+		},
+		{
+			name:     "cidr",
+			input:    "cidr:10.0.0.0/8",
+			expected: Rule{Action: ActionAllow, CIDR: "10.0.0.0/8"},
+		},
+		{
+			name:     "key",
+			input:    "key:internal",
+			expected: Rule{Action: ActionAllow, Key: "internal"},
+		},
+		{
+			name:     "header",
+			input:    "header:X-Health-Check=yes",
+			expected: Rule{Action: ActionAllow, Header: "X-Health-Check", Value: "yes"},
+		},
+		{
+			name:    "header without value",
+			input:   "header:X-Health-Check",
+			wantErr: true,
+		},
+		{
+			name:    "invalid cidr",
+			input:   "10.0.0.0/99",
+			wantErr: true,
+		},
+		{
+			name:    "empty key",
+			input:   "key:",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := ParseRule(ActionAllow, tt.input)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("ParseRule(%q) expected error", tt.input)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseRule(%q) unexpected error: %v", tt.input, err)
+			}
+			if result != tt.expected {
+				t.Errorf("ParseRule(%q) = %+v, expected %+v", tt.input, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestList_Check(t *testing.T) {
+	l, err := NewList(
+		Rule{Action: ActionAllow, CIDR: "10.0.0.0/8"},
+		Rule{Action: ActionAllow, Key: "internal"},
+		Rule{Action: ActionAllow, Header: "x-health-check", Value: "yes"},
+		Rule{Action: ActionDeny, CIDR: "10.6.6.0/24"},
+		Rule{Action: ActionDeny, CIDR: "2001:db8::/32"},
+		Rule{Action: ActionDeny, CIDR: "192.168.1.1"},
+	)
+	if err != nil {
+		t.Fatalf("NewList() unexpected error: %v", err)
+	}
+
+	healthHeader := http.Header{}
+	healthHeader.Set("X-Health-Check", "yes")
+
+	tests := []struct {
+		name     string
+		ip       string
+		key      string
+		header   http.Header
+		expected Decision
+	}{
+		{name: "allowed prefix", ip: "10.1.2.3", expected: Allow},
+		{name: "deny overrides allow", ip: "10.6.6.7", expected: Deny},
+		{name: "ipv6 prefix", ip: "2001:db8::1", expected: Deny},
+		{name: "single host", ip: "192.168.1.1", expected: Deny},
+		{name: "mapped ipv4", ip: "::ffff:192.168.1.1", expected: Deny},
+		{name: "unlisted", ip: "192.168.1.2", expected: NoMatch},
+		{name: "key", ip: "192.168.1.2", key: "internal", expected: Allow},
+		{name: "header", ip: "192.168.1.2", header: healthHeader, expected: Allow},
+		{name: "invalid ip", ip: "", expected: NoMatch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ip, _ := netip.ParseAddr(tt.ip)
+			result := l.Check(ip, tt.key, tt.header)
+			if result != tt.expected {
+				t.Errorf("Check(%q) = %d, expected %d", tt.ip, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestList_AddRemove(t *testing.T) {
+	l := &List{}
+	rule := Rule{Action: ActionDeny, CIDR: "10.0.0.0/8"}
+	ip := netip.MustParseAddr("10.1.2.3")
+
+	if err := l.Add(rule); err != nil {
+		t.Fatalf("Add() unexpected error: %v", err)
+	}
+	if l.Check(ip, "", nil) != Deny {
+		t.Error("Expected Deny after Add")
+	}
+	if rules := l.Rules(); len(rules) != 1 || rules[0] != rule {
+		t.Errorf("Rules() = %+v, expected [%+v]", rules, rule)
+	}
+
+	removed, err := l.Remove(rule)
+	if err != nil {
+		t.Fatalf("Remove() unexpected error: %v", err)
+	}
+	if !removed {
+		t.Error("Expected Remove() to report the rule was removed")
+	}
+	if l.Check(ip, "", nil) != NoMatch {
+		t.Error("Expected NoMatch after Remove")
+	}
+
+	removed, _ = l.Remove(rule)
+	if removed {
+		t.Error("Expected second Remove() to report nothing removed")
+	}
+
+	if err := l.Add(Rule{Action: "maybe", Key: "k"}); err == nil {
+		t.Error("Expected error for unknown action")
+	}
+}
@@ -0,0 +1,222 @@
+// Package adaptive limits the number of requests in flight to a downstream
+// service, adjusting the limit automatically from the latency and errors it
+// observes. The limit grows while the downstream keeps up and shrinks when it
+// slows down or fails, so it tracks the downstream's real capacity instead of
+// a static guess.
+package adaptive
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"strongdm/metrics"
+)
+
+// Algorithms accepted by New.
+const (
+	// AlgorithmAIMD increases the limit by one while the limit is in use and
+	// samples are fast, and multiplies it by Backoff on an error or a sample
+	// slower than Timeout.
+	AlgorithmAIMD = "aimd"
+
+	// AlgorithmVegas estimates how many requests are queued downstream from
+	// the ratio of the fastest observed latency to the current latency, and
+	// keeps that queue between vegasAlpha and vegasBeta requests.
+	AlgorithmVegas = "vegas"
+)
+
+const (
+	// vegasAlpha and vegasBeta bound the estimated downstream queue that
+	// AlgorithmVegas aims for.
+	vegasAlpha = 3
+	vegasBeta  = 6
+
+	// probeEvery is the number of samples after which the fastest observed
+	// latency is forgotten, so AlgorithmVegas adapts if the downstream's
+	// baseline latency changes.
+	probeEvery = 1000
+
+	// smoothing is the weight of each new sample in the average latency.
+	smoothing = 0.2
+)
+
+// Config describes an adaptive limit.
+type Config struct {
+	Algorithm    string
+	InitialLimit int
+	MinLimit     int
+	MaxLimit     int
+
+	// Timeout is the latency above which AlgorithmAIMD treats a sample as
+	// a failure.
+	Timeout time.Duration
+
+	// Backoff is the factor the limit is multiplied by on failure, between
+	// 0 and 1.
+	Backoff float64
+}
+
+// Validate checks that the configuration is well formed.
+func (c Config) Validate() error {
+	switch c.Algorithm {
+	case AlgorithmAIMD, AlgorithmVegas:
+	default:
+		return fmt.Errorf("unknown algorithm %q, must be %q or %q", c.Algorithm, AlgorithmAIMD, AlgorithmVegas)
+	}
+	if c.MinLimit < 1 {
+		return fmt.Errorf("minimum limit must be at least 1")
+	}
+	if c.MaxLimit < c.MinLimit {
+		return fmt.Errorf("maximum limit must not be less than the minimum")
+	}
+	if c.InitialLimit < c.MinLimit || c.InitialLimit > c.MaxLimit {
+		return fmt.Errorf("initial limit must be between the minimum and maximum")
+	}
+	if c.Algorithm == AlgorithmAIMD && c.Timeout <= 0 {
+		return fmt.Errorf("timeout must be positive")
+	}
+	if c.Backoff <= 0 || c.Backoff >= 1 {
+		return fmt.Errorf("backoff must be between 0 and 1")
+	}
+	return nil
+}
+
+// Limiter is an adaptive concurrency limit. It is safe for concurrent use.
+type Limiter struct {
+	cfg Config
+
+	// now is replaceable in tests.
+	now func() time.Time
+
+	mu       sync.Mutex
+	limit    float64
+	inFlight int
+
+	// minLatency and latency are the fastest and the smoothed average
+	// latency observed, used by AlgorithmVegas.
+	minLatency time.Duration
+	latency    time.Duration
+	samples    int
+}
+
+// New creates a limiter starting at cfg.InitialLimit.
+func New(cfg Config) (*Limiter, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	l := &Limiter{
+		cfg:   cfg,
+		now:   time.Now,
+		limit: float64(cfg.InitialLimit),
+	}
+	metrics.ConcurrencyLimit.Set(int64(cfg.InitialLimit))
+	return l, nil
+}
+
+// Limit returns the current concurrency limit.
+func (l *Limiter) Limit() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int64(l.limit)
+}
+
+// Token is a slot held by a request in flight.
+type Token struct {
+	l     *Limiter
+	start time.Time
+}
+
+// Acquire takes a slot for a request, returning false if the limit has been
+// reached. The caller must call Done or Cancel on the token when the request
+// ends.
+func (l *Limiter) Acquire() (*Token, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.inFlight >= int(l.limit) {
+		return nil, false
+	}
+	l.inFlight++
+	metrics.InFlight.Add(1)
+	return &Token{l: l, start: l.now()}, true
+}
+
+// Done releases the slot and adjusts the limit using the request's latency
+// and whether it failed.
+func (t *Token) Done(failed bool) {
+	t.l.release(t.l.now().Sub(t.start), failed, true)
+}
+
+// Cancel releases the slot without using the request as a sample, such as
+// when it was rejected before reaching the downstream.
+func (t *Token) Cancel() {
+	t.l.release(0, false, false)
+}
+
+func (l *Limiter) release(latency time.Duration, failed, sample bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	inFlight := l.inFlight
+	l.inFlight--
+	metrics.InFlight.Add(-1)
+	if !sample {
+		return
+	}
+
+	switch l.cfg.Algorithm {
+	case AlgorithmAIMD:
+		l.aimd(latency, failed, inFlight)
+	case AlgorithmVegas:
+		l.vegas(latency, failed)
+	}
+	l.limit = math.Max(float64(l.cfg.MinLimit), math.Min(float64(l.cfg.MaxLimit), l.limit))
+	metrics.ConcurrencyLimit.Set(int64(l.limit))
+}
+
+// aimd applies additive increase, multiplicative decrease. inFlight is the
+// number of requests in flight when the sample ended, including itself.
+func (l *Limiter) aimd(latency time.Duration, failed bool, inFlight int) {
+	switch {
+	case failed || latency > l.cfg.Timeout:
+		l.limit *= l.cfg.Backoff
+	case float64(inFlight)*2 >= l.limit:
+		// Only grow when the limit is actually being used, or it would
+		// drift upwards during quiet periods.
+		l.limit++
+	}
+}
+
+// vegas adjusts the limit to keep the estimated downstream queue small.
+func (l *Limiter) vegas(latency time.Duration, failed bool) {
+	if failed {
+		l.limit *= l.cfg.Backoff
+		return
+	}
+
+	l.samples++
+	if l.samples%probeEvery == 0 {
+		l.minLatency = 0
+	}
+	if l.minLatency == 0 || latency < l.minLatency {
+		l.minLatency = latency
+	}
+	if l.latency == 0 {
+		l.latency = latency
+	} else {
+		l.latency = time.Duration(float64(l.latency)*(1-smoothing) + float64(latency)*smoothing)
+	}
+	if l.latency <= 0 {
+		return
+	}
+
+	queue := l.limit * (1 - float64(l.minLatency)/float64(l.latency))
+	switch {
+	case queue < vegasAlpha:
+		l.limit++
+	case queue > vegasBeta:
+		l.limit--
+	}
+}
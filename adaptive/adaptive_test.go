@@ -0,0 +1,125 @@
+package adaptive
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestLimiter(t *testing.T, cfg Config) (*Limiter, *time.Time) {
+	t.Helper()
+	l, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New() unexpected error: %v", err)
+	}
+	now := time.Now()
+	l.now = func() time.Time { return now }
+	return l, &now
+}
+
+// serve runs n concurrent requests that each take latency.
+func serve(l *Limiter, now *time.Time, n int, latency time.Duration, failed bool) {
+	var tokens []*Token
+	for i := 0; i < n; i++ {
+		if tok, ok := l.Acquire(); ok {
+			tokens = append(tokens, tok)
+		}
+	}
+	*now = now.Add(latency)
+	for _, tok := range tokens {
+		tok.Done(failed)
+	}
+}
+
+func TestAcquire(t *testing.T) {
+	l, _ := newTestLimiter(t, Config{Algorithm: AlgorithmAIMD, InitialLimit: 2, MinLimit: 1, MaxLimit: 10, Timeout: time.Second, Backoff: 0.5})
+
+	first, ok1 := l.Acquire()
+	_, ok2 := l.Acquire()
+	_, ok3 := l.Acquire()
+	if !ok1 || !ok2 || ok3 {
+		t.Errorf("Expected 2 slots, got %v %v %v", ok1, ok2, ok3)
+	}
+
+	first.Cancel()
+	if _, ok := l.Acquire(); !ok {
+		t.Error("Expected a cancelled slot to be released")
+	}
+	if l.Limit() != 2 {
+		t.Errorf("Expected a cancelled slot not to change the limit, got %d", l.Limit())
+	}
+}
+
+func TestAIMD(t *testing.T) {
+	l, now := newTestLimiter(t, Config{Algorithm: AlgorithmAIMD, InitialLimit: 10, MinLimit: 2, MaxLimit: 20, Timeout: 100 * time.Millisecond, Backoff: 0.5})
+
+	// Each sample that ends while at least half the limit is in use adds
+	// one, which is the first 4 of the 10.
+	serve(l, now, 10, 10*time.Millisecond, false)
+	if got := l.Limit(); got != 14 {
+		t.Errorf("Expected the limit to grow under load, got %d", got)
+	}
+
+	serve(l, now, 1, 200*time.Millisecond, false)
+	if got := l.Limit(); got != 7 {
+		t.Errorf("Expected a slow sample to halve the limit, got %d", got)
+	}
+
+	serve(l, now, 1, 10*time.Millisecond, false)
+	if got := l.Limit(); got != 7 {
+		t.Errorf("Expected the limit not to grow while mostly unused, got %d", got)
+	}
+
+	for i := 0; i < 5; i++ {
+		serve(l, now, 1, 10*time.Millisecond, true)
+	}
+	if got := l.Limit(); got != 2 {
+		t.Errorf("Expected failures to reduce the limit to the minimum, got %d", got)
+	}
+}
+
+func TestVegas(t *testing.T) {
+	l, now := newTestLimiter(t, Config{Algorithm: AlgorithmVegas, InitialLimit: 10, MinLimit: 1, MaxLimit: 100, Backoff: 0.5})
+
+	// Steady latency means no queue downstream, so the limit grows.
+	for i := 0; i < 5; i++ {
+		serve(l, now, 10, 10*time.Millisecond, false)
+	}
+	grown := l.Limit()
+	if grown <= 10 {
+		t.Errorf("Expected the limit to grow at steady latency, got %d", grown)
+	}
+
+	// Latency well above the minimum means requests are queueing.
+	for i := 0; i < 20; i++ {
+		serve(l, now, 1, 50*time.Millisecond, false)
+	}
+	if got := l.Limit(); got >= grown {
+		t.Errorf("Expected the limit to shrink as latency rises, got %d from %d", got, grown)
+	}
+}
+
+func TestConfig_Validate(t *testing.T) {
+	valid := Config{Algorithm: AlgorithmAIMD, InitialLimit: 10, MinLimit: 1, MaxLimit: 100, Timeout: time.Second, Backoff: 0.9}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("Validate() unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(c *Config)
+	}{
+		{"algorithm", func(c *Config) { c.Algorithm = "pid" }},
+		{"min limit", func(c *Config) { c.MinLimit = 0 }},
+		{"max limit", func(c *Config) { c.MaxLimit = 0 }},
+		{"initial limit", func(c *Config) { c.InitialLimit = 1000 }},
+		{"timeout", func(c *Config) { c.Timeout = 0 }},
+		{"backoff", func(c *Config) { c.Backoff = 1 }},
+	}
+	for _, tt := range tests {
+		cfg := valid
+		tt.modify(&cfg)
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("%s: expected error", tt.name)
+		}
+	}
+}
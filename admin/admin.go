@@ -0,0 +1,118 @@
+// Package admin serves the operator-facing HTTP API. It is intended to be
+// bound to a separate, non-public address from the rate limited endpoint.
+package admin
+
+import (
+	"encoding/json"
+	"expvar"
+	"log/slog"
+	"net/http"
+
+	"strongdm/access"
+	"strongdm/ban"
+	"strongdm/breaker"
+	"strongdm/health"
+)
+
+// Option registers an optional component with the admin API.
+type Option func(*http.ServeMux)
+
+// WithHealth serves the liveness, readiness and build metadata endpoints
+// described in health.Checker.Register.
+func WithHealth(c *health.Checker) Option {
+	return func(mux *http.ServeMux) {
+		c.Register(mux)
+	}
+}
+
+// WithAccessList exposes the allow and deny lists for inspection and editing:
+//
+//	GET    /access  lists all rules
+//	POST   /access  adds the rule in the request body
+//	DELETE /access  removes the rule in the request body
+func WithAccessList(l *access.List) Option {
+	return func(mux *http.ServeMux) {
+		mux.HandleFunc("GET /access", func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, http.StatusOK, l.Rules())
+		})
+		mux.HandleFunc("POST /access", func(w http.ResponseWriter, r *http.Request) {
+			var rule access.Rule
+			if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := l.Add(rule); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			writeJSON(w, http.StatusCreated, rule)
+		})
+		mux.HandleFunc("DELETE /access", func(w http.ResponseWriter, r *http.Request) {
+			var rule access.Rule
+			if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			removed, err := l.Remove(rule)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if !removed {
+				http.Error(w, "Rule not found", http.StatusNotFound)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
+
+// WithBans exposes the penalty box:
+//
+//	GET    /bans        lists active bans
+//	DELETE /bans/{key}  lifts the ban on key
+func WithBans(b *ban.Box) Option {
+	return func(mux *http.ServeMux) {
+		mux.HandleFunc("GET /bans", func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, http.StatusOK, b.List())
+		})
+		mux.HandleFunc("DELETE /bans/{key}", func(w http.ResponseWriter, r *http.Request) {
+			key := r.PathValue("key")
+			if !b.Lift(key) {
+				http.Error(w, "Ban not found", http.StatusNotFound)
+				return
+			}
+			slog.Info("Ban lifted", slog.String("key", key))
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
+
+// WithBreakers exposes the upstream circuit breakers:
+//
+//	GET /breakers  lists the state of each breaker
+func WithBreakers(g *breaker.Group) Option {
+	return func(mux *http.ServeMux) {
+		mux.HandleFunc("GET /breakers", func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, http.StatusOK, g.List())
+		})
+	}
+}
+
+// New creates the admin API handler. Metrics are always served at
+// /debug/vars.
+func New(opts ...Option) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("GET /debug/vars", expvar.Handler())
+	for _, opt := range opts {
+		opt(mux)
+	}
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	jsonData, _ := json.MarshalIndent(v, "", "  ")
+	_, _ = w.Write(jsonData)
+}
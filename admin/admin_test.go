@@ -0,0 +1,123 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"strongdm/access"
+	"strongdm/ban"
+	"strongdm/breaker"
+)
+
+func TestAccess_AddListRemove(t *testing.T) {
+	l := &access.List{}
+	h := New(WithAccessList(l))
+
+	body := `{"action":"deny","cidr":"10.0.0.0/8"}`
+	req := httptest.NewRequest(http.MethodPost, "/access", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d", http.StatusCreated, w.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/access", nil)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	var rules []access.Rule
+	if err := json.Unmarshal(w.Body.Bytes(), &rules); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if len(rules) != 1 || rules[0].CIDR != "10.0.0.0/8" {
+		t.Errorf("Unexpected rules: %+v", rules)
+	}
+
+	req = httptest.NewRequest(http.MethodDelete, "/access", strings.NewReader(body))
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusNoContent {
+		t.Errorf("Expected status %d, got %d", http.StatusNoContent, w.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodDelete, "/access", strings.NewReader(body))
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestAccess_InvalidRule(t *testing.T) {
+	h := New(WithAccessList(&access.List{}))
+
+	req := httptest.NewRequest(http.MethodPost, "/access", strings.NewReader(`{"action":"deny"}`))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	h := New()
+
+	req := httptest.NewRequest(http.MethodGet, "/debug/vars", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+}
+
+func TestBans_ListAndLift(t *testing.T) {
+	bans := ban.New(ban.Policy{Threshold: 1, Window: time.Minute, Duration: time.Minute})
+	bans.RecordRejection("192.168.1.1")
+	h := New(WithBans(bans))
+
+	req := httptest.NewRequest(http.MethodGet, "/bans", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	var list []ban.Ban
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if len(list) != 1 || list[0].Key != "192.168.1.1" {
+		t.Errorf("Unexpected bans: %+v", list)
+	}
+
+	req = httptest.NewRequest(http.MethodDelete, "/bans/192.168.1.1", nil)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusNoContent {
+		t.Errorf("Expected status %d, got %d", http.StatusNoContent, w.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodDelete, "/bans/192.168.1.1", nil)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestBreakers_List(t *testing.T) {
+	breakers := breaker.New(breaker.Policy{ConsecutiveFailures: 1, Cooldown: time.Minute, Probes: 1})
+	token, _ := breakers.Breaker("http://api:8080").Allow()
+	token.Done(true)
+	h := New(WithBreakers(breakers))
+
+	req := httptest.NewRequest(http.MethodGet, "/breakers", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	var list []breaker.Status
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if len(list) != 1 || list[0].Upstream != "http://api:8080" || list[0].State != breaker.StateOpen {
+		t.Errorf("Unexpected breakers: %+v", list)
+	}
+}
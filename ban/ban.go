@@ -0,0 +1,199 @@
+// Package ban implements a penalty box for clients that keep sending requests
+// after being rate limited. Keys that collect too many rejections within a
+// window are banned for a period that doubles on each repeat offense.
+package ban
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Policy describes when a key is banned and for how long.
+type Policy struct {
+	// Threshold is the number of rejections within Window that triggers a
+	// ban.
+	Threshold int
+
+	// Window is the sliding window over which rejections are counted.
+	Window time.Duration
+
+	// Duration is the length of a first ban. Each repeat offense doubles it.
+	Duration time.Duration
+
+	// MaxDuration caps the length of a ban. Zero means no cap.
+	MaxDuration time.Duration
+
+	// ForgetAfter is how long after a ban ends before the key's offense count
+	// is reset. Zero means offenses are never forgotten.
+	ForgetAfter time.Duration
+}
+
+// Ban describes an active ban.
+type Ban struct {
+	Key        string    `json:"key"`
+	Since      time.Time `json:"since"`
+	Until      time.Time `json:"until"`
+	Offense    int       `json:"offense"`
+	Rejections int       `json:"rejections"`
+}
+
+// Box tracks rejections and bans per key. It is safe for concurrent use.
+type Box struct {
+	policy Policy
+	now    func() time.Time
+
+	mu      sync.Mutex
+	offends map[string]*offender
+	// pruned is when state for keys that no longer need tracking was last
+	// discarded.
+	pruned time.Time
+}
+
+// offender is the tracked state of a single key.
+type offender struct {
+	// rejections holds the times of recent rejections, oldest first.
+	rejections []time.Time
+	// offenses is the number of bans the key has received.
+	offenses int
+	// ban is the current or most recent ban, if any.
+	ban *Ban
+}
+
+// New creates a penalty box enforcing the given policy.
+func New(policy Policy) *Box {
+	return &Box{
+		policy:  policy,
+		now:     time.Now,
+		offends: map[string]*offender{},
+	}
+}
+
+// Banned reports whether key is currently banned, and if so the ban.
+func (b *Box) Banned(key string) (Ban, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	o := b.offends[key]
+	if o == nil || o.ban == nil || !b.now().Before(o.ban.Until) {
+		return Ban{}, false
+	}
+	return *o.ban, true
+}
+
+// RecordRejection notes that a request for key was rejected by the rate
+// limiter. If this pushes the key over the policy threshold, a ban starts and
+// is returned with true.
+func (b *Box) RecordRejection(key string) (Ban, bool) {
+	now := b.now()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// Sweeping once per window keeps the cost per rejection constant while
+	// bounding memory by the keys rejected recently.
+	if now.Sub(b.pruned) >= b.policy.Window {
+		b.prune(now)
+		b.pruned = now
+	}
+
+	o := b.offends[key]
+	if o == nil {
+		o = &offender{}
+		b.offends[key] = o
+	}
+	if o.ban != nil && now.Before(o.ban.Until) {
+		return Ban{}, false
+	}
+	if o.ban != nil && b.policy.ForgetAfter > 0 && now.Sub(o.ban.Until) >= b.policy.ForgetAfter {
+		o.offenses = 0
+		o.ban = nil
+	}
+
+	o.rejections = append(pruneBefore(o.rejections, now.Add(-b.policy.Window)), now)
+	if len(o.rejections) < b.policy.Threshold {
+		return Ban{}, false
+	}
+
+	o.offenses++
+	o.ban = &Ban{
+		Key:        key,
+		Since:      now,
+		Until:      now.Add(b.duration(o.offenses)),
+		Offense:    o.offenses,
+		Rejections: len(o.rejections),
+	}
+	o.rejections = nil
+	return *o.ban, true
+}
+
+// prune discards state for keys that are not banned, have no rejections in
+// the current window, and whose offenses have been forgotten. The caller must
+// hold mu.
+func (b *Box) prune(now time.Time) {
+	for key, o := range b.offends {
+		if o.ban != nil && now.Before(o.ban.Until) {
+			continue
+		}
+		o.rejections = pruneBefore(o.rejections, now.Add(-b.policy.Window))
+		forgotten := o.ban == nil || (b.policy.ForgetAfter > 0 && now.Sub(o.ban.Until) >= b.policy.ForgetAfter)
+		if len(o.rejections) == 0 && forgotten {
+			delete(b.offends, key)
+		}
+	}
+}
+
+// List returns all active bans ordered by key.
+func (b *Box) List() []Ban {
+	now := b.now()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var bans []Ban
+	for _, o := range b.offends {
+		if o.ban != nil && now.Before(o.ban.Until) {
+			bans = append(bans, *o.ban)
+		}
+	}
+	sort.Slice(bans, func(i, j int) bool { return bans[i].Key < bans[j].Key })
+	return bans
+}
+
+// Lift ends the active ban on key, reporting whether one existed. The key's
+// offense count is kept so a repeat offense is still escalated.
+func (b *Box) Lift(key string) bool {
+	now := b.now()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	o := b.offends[key]
+	if o == nil || o.ban == nil || !now.Before(o.ban.Until) {
+		return false
+	}
+	o.ban.Until = now
+	return true
+}
+
+// duration returns the ban length for the given offense number.
+func (b *Box) duration(offense int) time.Duration {
+	d := b.policy.Duration
+	for i := 1; i < offense && d < math.MaxInt64/2; i++ {
+		d *= 2
+		if b.policy.MaxDuration > 0 && d >= b.policy.MaxDuration {
+			break
+		}
+	}
+	if b.policy.MaxDuration > 0 && d > b.policy.MaxDuration {
+		d = b.policy.MaxDuration
+	}
+	return d
+}
+
+// pruneBefore drops times before cutoff from the sorted slice ts.
+func pruneBefore(ts []time.Time, cutoff time.Time) []time.Time {
+	i := sort.Search(len(ts), func(i int) bool { return !ts[i].Before(cutoff) })
+	return ts[i:]
+}
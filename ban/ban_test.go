@@ -0,0 +1,145 @@
+package ban
+
+import (
+	"testing"
+	"time"
+)
+
+// newTestBox creates a Box whose clock is controlled by the returned pointer.
+func newTestBox(policy Policy) (*Box, *time.Time) {
+	now := time.Now()
+	b := New(policy)
+	b.now = func() time.Time { return now }
+	return b, &now
+}
+
+func TestBox_BanAfterThreshold(t *testing.T) {
+	b, _ := newTestBox(Policy{Threshold: 3, Window: time.Minute, Duration: time.Minute})
+
+	for i := 0; i < 2; i++ {
+		if _, started := b.RecordRejection("key"); started {
+			t.Fatalf("Rejection %d should not start a ban", i+1)
+		}
+	}
+	if _, banned := b.Banned("key"); banned {
+		t.Fatal("Key should not be banned below the threshold")
+	}
+
+	ban, started := b.RecordRejection("key")
+	if !started {
+		t.Fatal("Third rejection should start a ban")
+	}
+	if ban.Offense != 1 || ban.Rejections != 3 {
+		t.Errorf("Unexpected ban stats: %+v", ban)
+	}
+	if ban.Until.Sub(ban.Since) != time.Minute {
+		t.Errorf("Expected a 1m ban, got %v", ban.Until.Sub(ban.Since))
+	}
+	if _, banned := b.Banned("key"); !banned {
+		t.Error("Key should be banned")
+	}
+	if _, banned := b.Banned("other"); banned {
+		t.Error("Other keys should not be banned")
+	}
+}
+
+func TestBox_WindowExpiry(t *testing.T) {
+	b, now := newTestBox(Policy{Threshold: 2, Window: time.Minute, Duration: time.Minute})
+
+	b.RecordRejection("key")
+	*now = now.Add(2 * time.Minute)
+
+	if _, started := b.RecordRejection("key"); started {
+		t.Error("Rejections outside the window should not count")
+	}
+}
+
+func TestBox_Escalation(t *testing.T) {
+	b, now := newTestBox(Policy{
+		Threshold:   1,
+		Window:      time.Minute,
+		Duration:    time.Minute,
+		MaxDuration: 3 * time.Minute,
+	})
+
+	expected := []time.Duration{time.Minute, 2 * time.Minute, 3 * time.Minute, 3 * time.Minute}
+	for i, d := range expected {
+		ban, started := b.RecordRejection("key")
+		if !started {
+			t.Fatalf("Offense %d should start a ban", i+1)
+		}
+		if ban.Until.Sub(ban.Since) != d {
+			t.Errorf("Offense %d: expected %v ban, got %v", i+1, d, ban.Until.Sub(ban.Since))
+		}
+		if _, started := b.RecordRejection("key"); started {
+			t.Error("Rejections during a ban should not start another")
+		}
+		*now = ban.Until
+	}
+}
+
+func TestBox_ForgetAfter(t *testing.T) {
+	b, now := newTestBox(Policy{
+		Threshold:   1,
+		Window:      time.Minute,
+		Duration:    time.Minute,
+		ForgetAfter: time.Hour,
+	})
+
+	ban, _ := b.RecordRejection("key")
+	*now = ban.Until.Add(time.Hour)
+
+	ban, _ = b.RecordRejection("key")
+	if ban.Offense != 1 {
+		t.Errorf("Expected offenses to be forgotten, got offense %d", ban.Offense)
+	}
+}
+
+func TestBox_ListAndLift(t *testing.T) {
+	b, now := newTestBox(Policy{Threshold: 1, Window: time.Minute, Duration: time.Minute})
+
+	b.RecordRejection("b")
+	b.RecordRejection("a")
+
+	bans := b.List()
+	if len(bans) != 2 || bans[0].Key != "a" || bans[1].Key != "b" {
+		t.Fatalf("Unexpected bans: %+v", bans)
+	}
+
+	if !b.Lift("a") {
+		t.Error("Lift should report an active ban")
+	}
+	if b.Lift("a") {
+		t.Error("Lift should report no ban the second time")
+	}
+	if _, banned := b.Banned("a"); banned {
+		t.Error("Lifted key should not be banned")
+	}
+
+	*now = now.Add(2 * time.Minute)
+	if bans := b.List(); len(bans) != 0 {
+		t.Errorf("Expected no active bans, got %+v", bans)
+	}
+
+	// The lifted key is escalated on its next offense.
+	ban, _ := b.RecordRejection("a")
+	if ban.Offense != 2 {
+		t.Errorf("Expected offense 2, got %d", ban.Offense)
+	}
+}
+
+func TestBox_Prune(t *testing.T) {
+	b, now := newTestBox(Policy{Threshold: 2, Window: time.Minute, Duration: time.Minute})
+
+	for _, key := range []string{"a", "b", "c"} {
+		b.RecordRejection(key)
+	}
+	*now = now.Add(2 * time.Minute)
+	b.RecordRejection("d")
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.offends) != 1 {
+		t.Errorf("Expected keys without recent rejections to be discarded, got %d tracked", len(b.offends))
+	}
+}
@@ -0,0 +1,201 @@
+// Package bandwidth paces request and response bodies to a per-key byte rate,
+// for clients that stay within their request limits but transfer too much
+// data. Transfers are slowed down rather than rejected.
+package bandwidth
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"strongdm/bucket"
+	"strongdm/keys"
+)
+
+// maxChunk is the largest number of bytes read or written between pauses, so
+// that large transfers are paced smoothly rather than in bursts.
+const maxChunk = 32 << 10
+
+// sweepInterval is how often the buckets of idle keys are swept out.
+const sweepInterval = bucket.WindowDuration
+
+// Limiter paces transfers to BytesPerSecond per key, allowing bursts of up to
+// Burst bytes. It is safe for concurrent use.
+type Limiter struct {
+	// limitPerWindow is the byte rate in bucket.WindowDuration units.
+	limitPerWindow int64
+	burst          int64
+	// timeout, if positive, is how long the connection has to transfer the
+	// next chunk after each pause.
+	timeout time.Duration
+
+	// now and sleep are replaceable in tests.
+	now   func() time.Time
+	sleep func(ctx context.Context, d time.Duration) error
+
+	mu      sync.Mutex
+	buckets map[string]bucket.Bucket
+	// swept is when idle buckets were last swept out.
+	swept time.Time
+}
+
+// New creates a limiter for bytesPerSecond per key, with bursts of up to
+// burst bytes. A burst of zero allows one second's worth of bytes.
+//
+// Paced transfers can take longer than the server's read or write timeout,
+// which would cut them off. If timeout is positive, Middleware instead moves
+// the connection's read or write deadline to timeout after each pause, so it
+// still bounds a stalled client but not a paced one.
+func New(bytesPerSecond, burst int64, timeout time.Duration) *Limiter {
+	if burst <= 0 {
+		burst = bytesPerSecond
+	}
+	return &Limiter{
+		limitPerWindow: bytesPerSecond * int64(bucket.WindowDuration/time.Second),
+		burst:          burst,
+		timeout:        timeout,
+		now:            time.Now,
+		sleep:          sleep,
+		buckets:        map[string]bucket.Bucket{},
+	}
+}
+
+// reserve adds n bytes to the key's bucket and returns how long the caller
+// must wait for the bucket to leak back down to the burst size. Concurrent
+// transfers for the same key queue up behind each other's reservations.
+func (l *Limiter) reserve(key string, n int64) time.Duration {
+	now := l.now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Sweeping once per interval keeps the cost per chunk constant however
+	// many keys are active.
+	if now.Sub(l.swept) >= sweepInterval {
+		for k, b := range l.buckets {
+			if b.CountAt(now) == 0 {
+				delete(l.buckets, k)
+			}
+		}
+		l.swept = now
+	}
+
+	b := l.buckets[key].Plus(now, l.limitPerWindow, n)
+	l.buckets[key] = b
+	return b.WillReach(l.burst, now).Sub(now)
+}
+
+// chunk returns the number of bytes to transfer before the next pause.
+func (l *Limiter) chunk(n int) int {
+	return min(n, maxChunk, int(min(l.burst, int64(maxChunk))))
+}
+
+// Reader paces reads from r to the key's rate.
+func (l *Limiter) Reader(ctx context.Context, key string, r io.ReadCloser) io.ReadCloser {
+	return &pacedReader{ReadCloser: r, ctx: ctx, limiter: l, key: key}
+}
+
+// Writer paces writes to w to the key's rate. The returned writer supports
+// flushing, so streamed responses are delivered as they are paced.
+func (l *Limiter) Writer(ctx context.Context, key string, w http.ResponseWriter) http.ResponseWriter {
+	return &pacedWriter{ResponseWriter: w, ctx: ctx, limiter: l, key: key}
+}
+
+// Middleware paces the request and response bodies of each request to next,
+// keyed by key. Uploads and downloads are limited separately.
+func Middleware(in, out *Limiter, key keys.Func, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		k := key(r)
+		rc := http.NewResponseController(w)
+		if in != nil && r.Body != nil && r.Body != http.NoBody {
+			r.Body = &pacedReader{ReadCloser: r.Body, ctx: r.Context(), limiter: in, key: k, rc: rc}
+		}
+		if out != nil {
+			w = &pacedWriter{ResponseWriter: w, ctx: r.Context(), limiter: out, key: k, rc: rc}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+type pacedReader struct {
+	io.ReadCloser
+	ctx     context.Context
+	limiter *Limiter
+	key     string
+	// rc, if set, controls the connection's read deadline.
+	rc *http.ResponseController
+}
+
+func (r *pacedReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return r.ReadCloser.Read(p)
+	}
+	n, err := r.ReadCloser.Read(p[:r.limiter.chunk(len(p))])
+	if n > 0 {
+		if d := r.limiter.reserve(r.key, int64(n)); d > 0 {
+			if waitErr := r.limiter.sleep(r.ctx, d); waitErr != nil && err == nil {
+				err = waitErr
+			}
+			if r.rc != nil && r.limiter.timeout > 0 {
+				_ = r.rc.SetReadDeadline(time.Now().Add(r.limiter.timeout))
+			}
+		}
+	}
+	return n, err
+}
+
+type pacedWriter struct {
+	http.ResponseWriter
+	ctx     context.Context
+	limiter *Limiter
+	key     string
+	// rc, if set, controls the connection's write deadline.
+	rc *http.ResponseController
+}
+
+func (w *pacedWriter) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		n, err := w.ResponseWriter.Write(p[written : written+w.limiter.chunk(len(p)-written)])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		if d := w.limiter.reserve(w.key, int64(n)); d > 0 {
+			// Flush before pausing so the client receives what has been
+			// paced so far instead of it sitting in the server's buffer.
+			w.Flush()
+			if err := w.limiter.sleep(w.ctx, d); err != nil {
+				return written, err
+			}
+			if w.rc != nil && w.limiter.timeout > 0 {
+				_ = w.rc.SetWriteDeadline(time.Now().Add(w.limiter.timeout))
+			}
+		}
+	}
+	return written, nil
+}
+
+// Flush implements http.Flusher.
+func (w *pacedWriter) Flush() {
+	_ = http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Unwrap returns the underlying writer for http.ResponseController.
+func (w *pacedWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// sleep waits for d or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,170 @@
+package bandwidth
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"strongdm/keys"
+)
+
+// newTestLimiter returns a limiter whose clock only advances when it sleeps,
+// along with a pointer to the total time slept.
+func newTestLimiter(bytesPerSecond, burst int64) (*Limiter, *time.Duration) {
+	l := New(bytesPerSecond, burst, 0)
+	now := time.Now()
+	var slept time.Duration
+	l.now = func() time.Time { return now }
+	l.sleep = func(ctx context.Context, d time.Duration) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		slept += d
+		now = now.Add(d)
+		return nil
+	}
+	return l, &slept
+}
+
+func TestWriter(t *testing.T) {
+	l, slept := newTestLimiter(1000, 1000)
+	rr := httptest.NewRecorder()
+	w := l.Writer(context.Background(), "client", rr)
+
+	n, err := w.Write(bytes.Repeat([]byte("x"), 5000))
+	if err != nil || n != 5000 {
+		t.Fatalf("Write() = %d, %v", n, err)
+	}
+	if rr.Body.Len() != 5000 {
+		t.Errorf("Expected all 5000 bytes to be written, got %d", rr.Body.Len())
+	}
+	// The first 1000 bytes are the burst, the other 4000 take 4 seconds.
+	if *slept < 3900*time.Millisecond || *slept > 4100*time.Millisecond {
+		t.Errorf("Expected about 4s of pacing, got %v", *slept)
+	}
+	if !rr.Flushed {
+		t.Error("Expected paced output to be flushed")
+	}
+}
+
+func TestWriter_SeparateKeys(t *testing.T) {
+	l, slept := newTestLimiter(1000, 1000)
+
+	for _, key := range []string{"a", "b"} {
+		w := l.Writer(context.Background(), key, httptest.NewRecorder())
+		if _, err := w.Write(bytes.Repeat([]byte("x"), 1000)); err != nil {
+			t.Fatalf("Write() unexpected error: %v", err)
+		}
+	}
+	if *slept != 0 {
+		t.Errorf("Expected each key to have its own burst, got %v of pacing", *slept)
+	}
+}
+
+func TestLimiter_Sweep(t *testing.T) {
+	l, _ := newTestLimiter(1000, 1000)
+	now := time.Now()
+	l.now = func() time.Time { return now }
+
+	l.reserve("a", 1000)
+	now = now.Add(time.Second)
+	l.reserve("b", 1000)
+	if len(l.buckets) != 2 {
+		t.Fatalf("Expected idle buckets to be kept until the next sweep, got %d", len(l.buckets))
+	}
+
+	now = now.Add(sweepInterval)
+	l.reserve("b", 1000)
+	if _, ok := l.buckets["a"]; ok || len(l.buckets) != 1 {
+		t.Errorf("Expected the idle bucket to be swept, got %v", l.buckets)
+	}
+}
+
+func TestWriter_Cancelled(t *testing.T) {
+	l, _ := newTestLimiter(1000, 1000)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	w := l.Writer(ctx, "client", httptest.NewRecorder())
+	n, err := w.Write(bytes.Repeat([]byte("x"), 5000))
+	if err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if n >= 5000 {
+		t.Errorf("Expected the write to stop early, wrote %d", n)
+	}
+}
+
+func TestReader(t *testing.T) {
+	l, slept := newTestLimiter(2000, 1000)
+	r := l.Reader(context.Background(), "client", io.NopCloser(strings.NewReader(strings.Repeat("x", 5000))))
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll() unexpected error: %v", err)
+	}
+	if len(data) != 5000 {
+		t.Errorf("Expected 5000 bytes, got %d", len(data))
+	}
+	if *slept < 1900*time.Millisecond || *slept > 2100*time.Millisecond {
+		t.Errorf("Expected about 2s of pacing, got %v", *slept)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	in, inSlept := newTestLimiter(1000, 1000)
+	out, outSlept := newTestLimiter(1000, 1000)
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		_, _ = w.Write(body)
+		_, _ = w.Write(body)
+	})
+	h := Middleware(in, out, keys.RemoteIP, next)
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(strings.Repeat("x", 2000)))
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+
+	if rr.Body.Len() != 4000 {
+		t.Errorf("Expected 4000 bytes echoed, got %d", rr.Body.Len())
+	}
+	if *inSlept < 900*time.Millisecond || *inSlept > 1100*time.Millisecond {
+		t.Errorf("Expected about 1s of upload pacing, got %v", *inSlept)
+	}
+	if *outSlept < 2900*time.Millisecond || *outSlept > 3100*time.Millisecond {
+		t.Errorf("Expected about 3s of download pacing, got %v", *outSlept)
+	}
+}
+
+func TestMiddleware_WriteTimeout(t *testing.T) {
+	// Pacing 1500 bytes at 2000 bytes per second takes about 500ms, longer
+	// than the server's write timeout.
+	const timeout = 200 * time.Millisecond
+	out := New(2000, 500, timeout)
+	body := bytes.Repeat([]byte("x"), 1500)
+	srv := httptest.NewUnstartedServer(Middleware(nil, out, keys.RemoteIP, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(body)
+	})))
+	srv.Config.WriteTimeout = timeout
+	srv.Start()
+	defer srv.Close()
+
+	start := time.Now()
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("Get() unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil || len(data) != len(body) {
+		t.Fatalf("Expected the whole paced body, got %d bytes and %v", len(data), err)
+	}
+	if elapsed := time.Since(start); elapsed <= timeout {
+		t.Errorf("Expected the transfer to outlast the write timeout, took %v", elapsed)
+	}
+}
@@ -0,0 +1,330 @@
+// Package breaker implements circuit breakers that stop forwarding requests to
+// a failing upstream, giving it time to recover instead of adding to its load.
+//
+// A breaker starts closed, passing requests through. It opens when too many
+// recent requests fail, rejecting requests for a cooldown. It then half-opens,
+// letting a few probe requests through, and closes again if they succeed or
+// reopens if one fails.
+package breaker
+
+import (
+	"expvar"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"strongdm/internal/status"
+	"strongdm/metrics"
+)
+
+// State is the state of a breaker.
+type State string
+
+// Breaker states.
+const (
+	StateClosed   State = "closed"
+	StateOpen     State = "open"
+	StateHalfOpen State = "half-open"
+)
+
+// Policy describes when breakers open and how they recover. A breaker opens
+// when either threshold is reached.
+type Policy struct {
+	// ConsecutiveFailures opens the breaker after this many failures in a
+	// row. Zero disables the threshold.
+	ConsecutiveFailures int
+
+	// FailureRate opens the breaker when at least this fraction of the
+	// requests in the current Window fail, once there have been MinRequests.
+	// Zero disables the threshold.
+	FailureRate float64
+	MinRequests int
+	Window      time.Duration
+
+	// Cooldown is how long an open breaker rejects requests before letting
+	// probes through.
+	Cooldown time.Duration
+
+	// Probes is the number of requests let through at once while half-open,
+	// and the number that must succeed for the breaker to close.
+	Probes int
+}
+
+// Validate checks that the policy is well formed.
+func (p Policy) Validate() error {
+	if p.ConsecutiveFailures < 0 {
+		return fmt.Errorf("consecutive failures must not be negative")
+	}
+	if p.FailureRate < 0 || p.FailureRate > 1 {
+		return fmt.Errorf("failure rate must be between 0 and 1")
+	}
+	if p.ConsecutiveFailures == 0 && p.FailureRate == 0 {
+		return fmt.Errorf("a consecutive failure or failure rate threshold is required")
+	}
+	if p.FailureRate > 0 {
+		if p.MinRequests < 1 {
+			return fmt.Errorf("minimum requests must be at least 1")
+		}
+		if p.Window <= 0 {
+			return fmt.Errorf("window must be positive")
+		}
+	}
+	if p.Cooldown <= 0 {
+		return fmt.Errorf("cooldown must be positive")
+	}
+	if p.Probes < 1 {
+		return fmt.Errorf("probes must be at least 1")
+	}
+	return nil
+}
+
+// Status describes a breaker.
+type Status struct {
+	Upstream string `json:"upstream"`
+	State    State  `json:"state"`
+
+	// Requests and Failures are counted in the current window.
+	Requests            int `json:"requests"`
+	Failures            int `json:"failures"`
+	ConsecutiveFailures int `json:"consecutiveFailures"`
+
+	// OpenedAt is when the breaker last opened, and Until when it half-opens.
+	// They are set only while it is open.
+	OpenedAt time.Time `json:"openedAt,omitzero"`
+	Until    time.Time `json:"until,omitzero"`
+}
+
+// Group holds a breaker per upstream, all sharing a policy. It is safe for
+// concurrent use.
+type Group struct {
+	policy Policy
+	now    func() time.Time
+
+	mu       sync.Mutex
+	breakers map[string]*Breaker
+}
+
+// New creates a group of breakers enforcing the given policy.
+func New(policy Policy) *Group {
+	return &Group{
+		policy:   policy,
+		now:      time.Now,
+		breakers: map[string]*Breaker{},
+	}
+}
+
+// Breaker returns the breaker for upstream, creating it if needed.
+func (g *Group) Breaker(upstream string) *Breaker {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	b, ok := g.breakers[upstream]
+	if !ok {
+		b = &Breaker{
+			upstream: upstream,
+			policy:   g.policy,
+			now:      g.now,
+			state:    StateClosed,
+		}
+		b.publish()
+		g.breakers[upstream] = b
+	}
+	return b
+}
+
+// List returns the status of every breaker, ordered by upstream.
+func (g *Group) List() []Status {
+	g.mu.Lock()
+	breakers := make([]*Breaker, 0, len(g.breakers))
+	for _, b := range g.breakers {
+		breakers = append(breakers, b)
+	}
+	g.mu.Unlock()
+
+	statuses := make([]Status, len(breakers))
+	for i, b := range breakers {
+		statuses[i] = b.Status()
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Upstream < statuses[j].Upstream })
+	return statuses
+}
+
+// Breaker is the circuit breaker for a single upstream.
+type Breaker struct {
+	upstream string
+	policy   Policy
+	now      func() time.Time
+
+	mu    sync.Mutex
+	state State
+	// generation changes on every state change, so that requests admitted
+	// in an earlier state do not affect the current one.
+	generation int
+
+	windowStart time.Time
+	requests    int
+	failures    int
+	consecutive int
+
+	openedAt time.Time
+
+	// probes is the number of half-open probes in flight, and successes the
+	// number that have succeeded.
+	probes    int
+	successes int
+}
+
+// Token is held by a request let through by a breaker.
+type Token struct {
+	b          *Breaker
+	generation int
+}
+
+// Allow lets a request through, or returns false if the breaker is open or
+// its half-open probes are all in flight. The caller must call Done on the
+// token when the request ends.
+func (b *Breaker) Allow() (*Token, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == StateOpen && !b.now().Before(b.openedAt.Add(b.policy.Cooldown)) {
+		b.setState(StateHalfOpen)
+	}
+	switch b.state {
+	case StateOpen:
+		metrics.BreakerRejections.Add(b.upstream, 1)
+		return nil, false
+	case StateHalfOpen:
+		if b.probes >= b.policy.Probes {
+			metrics.BreakerRejections.Add(b.upstream, 1)
+			return nil, false
+		}
+		b.probes++
+	}
+	return &Token{b: b, generation: b.generation}, true
+}
+
+// Done records whether the request failed.
+func (t *Token) Done(failed bool) {
+	b := t.b
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if t.generation != b.generation {
+		return
+	}
+	switch b.state {
+	case StateClosed:
+		b.record(failed)
+	case StateHalfOpen:
+		b.probes--
+		switch {
+		case failed:
+			b.setState(StateOpen)
+		case b.successes+1 >= b.policy.Probes:
+			b.setState(StateClosed)
+		default:
+			b.successes++
+		}
+	}
+}
+
+// record counts a request completed while closed, opening the breaker if a
+// threshold is reached.
+func (b *Breaker) record(failed bool) {
+	now := b.now()
+	if b.policy.Window > 0 && !now.Before(b.windowStart.Add(b.policy.Window)) {
+		b.windowStart = now
+		b.requests = 0
+		b.failures = 0
+	}
+	b.requests++
+	if !failed {
+		b.consecutive = 0
+		return
+	}
+	b.failures++
+	b.consecutive++
+
+	p := b.policy
+	if p.ConsecutiveFailures > 0 && b.consecutive >= p.ConsecutiveFailures ||
+		p.FailureRate > 0 && b.requests >= p.MinRequests && float64(b.failures) >= p.FailureRate*float64(b.requests) {
+		b.setState(StateOpen)
+	}
+}
+
+func (b *Breaker) setState(s State) {
+	b.state = s
+	b.generation++
+	b.probes = 0
+	b.successes = 0
+	switch s {
+	case StateOpen:
+		b.openedAt = b.now()
+		metrics.BreakerTrips.Add(b.upstream, 1)
+	case StateClosed:
+		b.windowStart = b.now()
+		b.requests = 0
+		b.failures = 0
+		b.consecutive = 0
+	}
+	b.publish()
+}
+
+// publish reports the state in metrics.
+func (b *Breaker) publish() {
+	v := new(expvar.String)
+	v.Set(string(b.state))
+	metrics.BreakerStates.Set(b.upstream, v)
+}
+
+// Status returns the breaker's current status.
+func (b *Breaker) Status() Status {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	s := Status{
+		Upstream:            b.upstream,
+		State:               b.state,
+		Requests:            b.requests,
+		Failures:            b.failures,
+		ConsecutiveFailures: b.consecutive,
+	}
+	if b.state == StateOpen {
+		s.OpenedAt = b.openedAt
+		s.Until = b.openedAt.Add(b.policy.Cooldown)
+	}
+	return s
+}
+
+// Middleware passes requests to next while b lets them through, and answers
+// them with 503 otherwise. Responses with a 5xx status count as failures.
+func Middleware(b *Breaker, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := b.Allow()
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(b.retryAfter()))
+			http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		rec := &status.Recorder{ResponseWriter: w}
+		defer func() { token.Done(rec.Code >= http.StatusInternalServerError) }()
+		next.ServeHTTP(rec, r)
+	})
+}
+
+// retryAfter returns the whole number of seconds until an open breaker
+// half-opens, and at least 1.
+func (b *Breaker) retryAfter() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state != StateOpen {
+		return 1
+	}
+	wait := b.openedAt.Add(b.policy.Cooldown).Sub(b.now())
+	return max(1, int((wait+time.Second-1)/time.Second))
+}
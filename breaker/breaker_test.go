@@ -0,0 +1,181 @@
+package breaker
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newGroup(policy Policy) (*Group, *time.Time) {
+	now := time.Now()
+	g := New(policy)
+	g.now = func() time.Time { return now }
+	return g, &now
+}
+
+// send runs a request through b, reporting whether it was let through.
+func send(b *Breaker, failed bool) bool {
+	token, ok := b.Allow()
+	if ok {
+		token.Done(failed)
+	}
+	return ok
+}
+
+func TestBreaker_ConsecutiveFailures(t *testing.T) {
+	g, now := newGroup(Policy{ConsecutiveFailures: 3, Cooldown: 10 * time.Second, Probes: 2})
+	b := g.Breaker("http://api")
+
+	send(b, true)
+	send(b, true)
+	send(b, false)
+	send(b, true)
+	send(b, true)
+	if b.Status().State != StateClosed {
+		t.Fatal("Expected a success to reset the consecutive failure count")
+	}
+	send(b, true)
+	if s := b.Status(); s.State != StateOpen || !s.Until.Equal(now.Add(10*time.Second)) {
+		t.Fatalf("Expected the breaker to open for the cooldown, got %+v", s)
+	}
+	if send(b, false) {
+		t.Error("Expected an open breaker to reject requests")
+	}
+
+	*now = now.Add(10 * time.Second)
+	first, ok := b.Allow()
+	if !ok || b.Status().State != StateHalfOpen {
+		t.Fatal("Expected the breaker to half-open after the cooldown")
+	}
+	second, _ := b.Allow()
+	if send(b, false) {
+		t.Error("Expected requests beyond the probes to be rejected while half-open")
+	}
+	first.Done(false)
+	if b.Status().State != StateHalfOpen {
+		t.Error("Expected the breaker to stay half-open until every probe succeeds")
+	}
+	second.Done(false)
+	if b.Status().State != StateClosed {
+		t.Error("Expected the breaker to close once the probes succeeded")
+	}
+}
+
+func TestBreaker_ProbeFailure(t *testing.T) {
+	g, now := newGroup(Policy{ConsecutiveFailures: 1, Cooldown: time.Second, Probes: 1})
+	b := g.Breaker("http://api")
+
+	// A request admitted before the breaker opened must not count as a
+	// probe when it ends.
+	stale, _ := b.Allow()
+	send(b, true)
+	*now = now.Add(time.Second)
+	probe, _ := b.Allow()
+	stale.Done(false)
+	if b.Status().State != StateHalfOpen {
+		t.Error("Expected a request from before the breaker opened to be ignored")
+	}
+
+	probe.Done(true)
+	if s := b.Status(); s.State != StateOpen || !s.OpenedAt.Equal(*now) {
+		t.Errorf("Expected a failed probe to reopen the breaker, got %+v", s)
+	}
+}
+
+func TestBreaker_FailureRate(t *testing.T) {
+	g, now := newGroup(Policy{FailureRate: 0.5, MinRequests: 4, Window: time.Minute, Cooldown: time.Second, Probes: 1})
+	b := g.Breaker("http://api")
+
+	send(b, true)
+	send(b, false)
+	send(b, true)
+	if b.Status().State != StateClosed {
+		t.Fatal("Expected the breaker to stay closed below the minimum requests")
+	}
+
+	// A new window forgets the earlier failures.
+	*now = now.Add(time.Minute)
+	send(b, false)
+	send(b, false)
+	send(b, true)
+	if s := b.Status(); s.State != StateClosed || s.Requests != 3 || s.Failures != 1 {
+		t.Fatalf("Expected the window to restart, got %+v", s)
+	}
+	send(b, true)
+	if b.Status().State != StateOpen {
+		t.Error("Expected the breaker to open at the failure rate")
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	g, _ := newGroup(Policy{ConsecutiveFailures: 2, Cooldown: 30 * time.Second, Probes: 1})
+	b := g.Breaker("http://api")
+	h := Middleware(b, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+
+	for range 2 {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		if w.Code != http.StatusBadGateway {
+			t.Errorf("Expected the upstream's status, got %d", w.Code)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status %d from an open breaker, got %d", http.StatusServiceUnavailable, w.Code)
+	}
+	if got := w.Header().Get("Retry-After"); got != "30" {
+		t.Errorf("Expected Retry-After to be the rest of the cooldown, got %q", got)
+	}
+}
+
+func TestGroup_List(t *testing.T) {
+	g, _ := newGroup(Policy{ConsecutiveFailures: 1, Cooldown: time.Second, Probes: 1})
+	send(g.Breaker("http://b"), true)
+	send(g.Breaker("http://a"), false)
+	if g.Breaker("http://a") != g.Breaker("http://a") {
+		t.Error("Expected one breaker per upstream")
+	}
+
+	list := g.List()
+	if len(list) != 2 {
+		t.Fatalf("Expected 2 breakers, got %d", len(list))
+	}
+	if list[0].Upstream != "http://a" || list[0].State != StateClosed {
+		t.Errorf("Expected http://a to be closed, got %+v", list[0])
+	}
+	if list[1].Upstream != "http://b" || list[1].State != StateOpen {
+		t.Errorf("Expected http://b to be open, got %+v", list[1])
+	}
+}
+
+func TestPolicy_Validate(t *testing.T) {
+	valid := Policy{ConsecutiveFailures: 5, Cooldown: time.Second, Probes: 1}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("Validate() unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(*Policy)
+	}{
+		{"no threshold", func(p *Policy) { p.ConsecutiveFailures = 0 }},
+		{"failure rate", func(p *Policy) { p.FailureRate = 2 }},
+		{"window", func(p *Policy) { p.FailureRate = 0.5; p.MinRequests = 10 }},
+		{"cooldown", func(p *Policy) { p.Cooldown = 0 }},
+		{"probes", func(p *Policy) { p.Probes = 0 }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := valid
+			tt.modify(&p)
+			if err := p.Validate(); err == nil {
+				t.Error("Expected an error")
+			}
+		})
+	}
+}
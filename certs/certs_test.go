@@ -0,0 +1,199 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// issue creates a certificate for cn signed by parent, or self-signed if
+// parent is nil.
+func issue(t *testing.T, cn string, isCA bool, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: cn},
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return cert, key
+}
+
+// writePair writes cert and key as PEM files, stamping them with modTime.
+func writePair(t *testing.T, certFile, keyFile string, cert *x509.Certificate, key *ecdsa.PrivateKey, modTime time.Time) {
+	t.Helper()
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	writePEM(t, certFile, "CERTIFICATE", cert.Raw, modTime)
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyDER, modTime)
+}
+
+func writePEM(t *testing.T, name, blockType string, der []byte, modTime time.Time) {
+	t.Helper()
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if err := os.WriteFile(name, data, 0o600); err != nil {
+		t.Fatalf("Failed to write %s: %v", name, err)
+	}
+	if err := os.Chtimes(name, modTime, modTime); err != nil {
+		t.Fatalf("Failed to set modification time: %v", err)
+	}
+}
+
+func TestReloader_Reload(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+	modTime := time.Now().Add(-time.Minute)
+
+	first, firstKey := issue(t, "first", false, nil, nil)
+	writePair(t, certFile, keyFile, first, firstKey, modTime)
+
+	r, err := New(certFile, keyFile, "")
+	if err != nil {
+		t.Fatalf("New() unexpected error: %v", err)
+	}
+
+	servedCN := func() string {
+		cfg, err := r.TLSConfig(tls.NoClientCert).GetConfigForClient(&tls.ClientHelloInfo{})
+		if err != nil {
+			t.Fatalf("GetConfigForClient() unexpected error: %v", err)
+		}
+		cert, err := cfg.GetCertificate(&tls.ClientHelloInfo{})
+		if err != nil {
+			t.Fatalf("GetCertificate() unexpected error: %v", err)
+		}
+		leaf, _ := x509.ParseCertificate(cert.Certificate[0])
+		return leaf.Subject.CommonName
+	}
+
+	if cn := servedCN(); cn != "first" {
+		t.Errorf("Expected certificate 'first', got '%s'", cn)
+	}
+
+	reloaded, err := r.Reload()
+	if err != nil || reloaded {
+		t.Errorf("Reload() of unchanged files = %v, %v, expected false, nil", reloaded, err)
+	}
+
+	second, secondKey := issue(t, "second", false, nil, nil)
+	writePair(t, certFile, keyFile, second, secondKey, modTime.Add(time.Second))
+
+	reloaded, err = r.Reload()
+	if err != nil || !reloaded {
+		t.Fatalf("Reload() of changed files = %v, %v, expected true, nil", reloaded, err)
+	}
+	if cn := servedCN(); cn != "second" {
+		t.Errorf("Expected certificate 'second', got '%s'", cn)
+	}
+
+	// A broken rotation keeps serving the last good certificate
+	if err := os.WriteFile(keyFile, []byte("garbage"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Reload(); err == nil {
+		t.Error("Expected error reloading an invalid key")
+	}
+	if cn := servedCN(); cn != "second" {
+		t.Errorf("Expected certificate 'second' after failed reload, got '%s'", cn)
+	}
+}
+
+func TestReloader_MutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+	caFile := filepath.Join(dir, "ca.crt")
+	now := time.Now()
+
+	ca, caKey := issue(t, "ca", true, nil, nil)
+	serverCert, serverKey := issue(t, "localhost", false, ca, caKey)
+	clientCert, clientKey := issue(t, "batch", false, ca, caKey)
+	writePair(t, certFile, keyFile, serverCert, serverKey, now)
+	writePEM(t, caFile, "CERTIFICATE", ca.Raw, now)
+
+	r, err := New(certFile, keyFile, caFile)
+	if err != nil {
+		t.Fatalf("New() unexpected error: %v", err)
+	}
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		_, _ = io.WriteString(w, req.TLS.VerifiedChains[0][0].Subject.CommonName)
+	}))
+	srv.TLS = r.TLSConfig(tls.RequireAndVerifyClientCert)
+	srv.StartTLS()
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	newClient := func(certs ...tls.Certificate) *http.Client {
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			RootCAs:      roots,
+			Certificates: certs,
+		}}}
+	}
+
+	if _, err := newClient().Get(srv.URL); err == nil {
+		t.Error("Expected handshake to fail without a client certificate")
+	}
+
+	resp, err := newClient(tls.Certificate{
+		Certificate: [][]byte{clientCert.Raw},
+		PrivateKey:  clientKey,
+	}).Get(srv.URL)
+	if err != nil {
+		t.Fatalf("Request with client certificate failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "batch" {
+		t.Errorf("Expected verified identity 'batch', got '%s'", body)
+	}
+}
+
+func TestNew_InvalidCABundle(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+	caFile := filepath.Join(dir, "ca.crt")
+
+	cert, key := issue(t, "localhost", false, nil, nil)
+	writePair(t, certFile, keyFile, cert, key, time.Now())
+	if err := os.WriteFile(caFile, []byte("not pem"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := New(certFile, keyFile, caFile); err == nil {
+		t.Error("Expected error for a CA bundle without certificates")
+	}
+}
@@ -0,0 +1,1040 @@
+// Package config loads the service configuration. Settings are layered, with
+// later layers taking precedence: built-in defaults, then a JSON config file,
+// then environment variables, then command-line flags.
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"strongdm/access"
+	"strongdm/adaptive"
+	"strongdm/breaker"
+	"strongdm/cost"
+	"strongdm/decision/decisionapi"
+	"strongdm/keys"
+	"strongdm/logging"
+	"strongdm/metering"
+	"strongdm/notify"
+	"strongdm/policy"
+	"strongdm/proxy"
+	"strongdm/quota"
+	"strongdm/rls"
+	"strongdm/routes"
+	"strongdm/shed"
+)
+
+// Backend types.
+const (
+	// BackendMemory keeps rate limit state in memory only.
+	BackendMemory = "memory"
+
+	// BackendFile keeps rate limit state in memory, loading it from and
+	// saving it to a file across restarts.
+	BackendFile = "file"
+)
+
+// Config is the complete service configuration.
+type Config struct {
+	BindAddr string `json:"bindAddr"`
+	// ForwardAuthPath is where the forward-auth decision endpoint is served
+	// on the main listener. It is disabled if empty.
+	ForwardAuthPath string    `json:"forwardAuthPath,omitempty"`
+	KeyStrategy     string    `json:"keyStrategy"`
+	Limit           Limit     `json:"limit"`
+	Backend         Backend   `json:"backend"`
+	Server          Server    `json:"server"`
+	TLS             TLS       `json:"tls"`
+	Proxy           Proxy     `json:"proxy"`
+	Breaker         Breaker   `json:"breaker"`
+	RLS             RLS       `json:"rls"`
+	Decision        Decision  `json:"decision"`
+	Bandwidth       Bandwidth `json:"bandwidth"`
+	Adaptive        Adaptive  `json:"adaptive"`
+	Shed            Shed      `json:"shed"`
+	Log             Log       `json:"log"`
+	Admin           Admin     `json:"admin"`
+	Access          Access    `json:"access"`
+	Ban             Ban       `json:"ban"`
+	Quota           Quota     `json:"quota"`
+	Metering        Metering  `json:"metering"`
+	Notify          Notify    `json:"notify"`
+
+	// PrintConfig requests that the effective configuration be printed
+	// instead of starting the service. It can only be set by flag.
+	PrintConfig bool `json:"-"`
+}
+
+// Limit configures the rate limit policies.
+type Limit struct {
+	PerMinute       int64       `json:"perMinute"`
+	Mode            policy.Mode `json:"mode"`
+	ShadowPerMinute int64       `json:"shadowPerMinute"`
+
+	// MaxDelay and MaxQueued hold requests that would be rejected until
+	// their bucket has room, as described in policy.Policy.
+	MaxDelay  Duration `json:"maxDelay,omitempty"`
+	MaxQueued int      `json:"maxQueued,omitempty"`
+
+	// Schedules replace PerMinute at certain times, as described in
+	// policy.Schedule.
+	Schedules []policy.Schedule `json:"schedules,omitempty"`
+
+	// Quota limits each key's usage over a calendar day or month, on top of
+	// PerMinute.
+	Quota quota.Quota `json:"quota,omitzero"`
+
+	// Cost is the cost.Parse strategy for the number of tokens a request
+	// spends.
+	Cost string `json:"cost"`
+	// PostChargeEvery, if positive, charges an extra token for every
+	// PostChargeEvery a proxied request took to respond.
+	PostChargeEvery Duration `json:"postChargeEvery,omitempty"`
+
+	// Routes apply their own policies to matching requests in place of the
+	// default one.
+	Routes []routes.Route `json:"routes,omitempty"`
+}
+
+// Backend configures where rate limit state is kept.
+type Backend struct {
+	Type string `json:"type"`
+	Path string `json:"path,omitempty"`
+}
+
+// Server configures the HTTP servers.
+type Server struct {
+	ReadHeaderTimeout Duration `json:"readHeaderTimeout"`
+	ReadTimeout       Duration `json:"readTimeout"`
+	WriteTimeout      Duration `json:"writeTimeout"`
+	IdleTimeout       Duration `json:"idleTimeout"`
+	ShutdownTimeout   Duration `json:"shutdownTimeout"`
+	MaxHeaderBytes    int      `json:"maxHeaderBytes"`
+}
+
+// Client certificate verification modes.
+const (
+	// ClientAuthRequire rejects connections without a valid client
+	// certificate.
+	ClientAuthRequire = "require"
+
+	// ClientAuthOptional verifies client certificates if presented, but
+	// accepts connections without one.
+	ClientAuthOptional = "optional"
+)
+
+// TLS configures HTTPS on the rate limited endpoint. It is served over plain
+// HTTP unless CertFile and KeyFile are set.
+type TLS struct {
+	CertFile string `json:"certFile,omitempty"`
+	KeyFile  string `json:"keyFile,omitempty"`
+
+	// ClientCAFile is a PEM bundle of CAs that client certificates are
+	// verified against. Mutual TLS is disabled if it is empty.
+	ClientCAFile string `json:"clientCAFile,omitempty"`
+	ClientAuth   string `json:"clientAuth"`
+
+	// ReloadInterval is how often the files are checked for changes.
+	ReloadInterval Duration `json:"reloadInterval"`
+}
+
+// Enabled reports whether TLS is configured.
+func (t TLS) Enabled() bool {
+	return t.CertFile != "" || t.KeyFile != ""
+}
+
+// Proxy configures reverse proxy mode, in which allowed requests of any method
+// are forwarded to an upstream instead of being answered with the rate limit
+// info. It is enabled when Upstream or Routes is set.
+type Proxy struct {
+	// Upstream receives requests that match none of Routes.
+	Upstream string        `json:"upstream,omitempty"`
+	Routes   []proxy.Route `json:"routes,omitempty"`
+}
+
+// Enabled reports whether proxy mode is configured.
+func (p Proxy) Enabled() bool {
+	return p.Upstream != "" || len(p.Routes) > 0
+}
+
+// AllRoutes returns Routes followed by a catch-all route to Upstream, if set.
+func (p Proxy) AllRoutes() []proxy.Route {
+	routes := append([]proxy.Route(nil), p.Routes...)
+	if p.Upstream != "" {
+		routes = append(routes, proxy.Route{Pattern: "/", Upstream: p.Upstream})
+	}
+	return routes
+}
+
+// Breaker configures a circuit breaker per proxy upstream, as described in
+// package breaker. It is enabled when either threshold is set.
+type Breaker struct {
+	ConsecutiveFailures int      `json:"consecutiveFailures,omitempty"`
+	FailureRate         float64  `json:"failureRate,omitempty"`
+	MinRequests         int      `json:"minRequests"`
+	Window              Duration `json:"window"`
+	Cooldown            Duration `json:"cooldown"`
+	Probes              int      `json:"probes"`
+}
+
+// Enabled reports whether a threshold is configured.
+func (b Breaker) Enabled() bool {
+	return b.ConsecutiveFailures != 0 || b.FailureRate != 0
+}
+
+// Policy returns the breaker.Policy described by b.
+func (b Breaker) Policy() breaker.Policy {
+	return breaker.Policy{
+		ConsecutiveFailures: b.ConsecutiveFailures,
+		FailureRate:         b.FailureRate,
+		MinRequests:         b.MinRequests,
+		Window:              time.Duration(b.Window),
+		Cooldown:            time.Duration(b.Cooldown),
+		Probes:              b.Probes,
+	}
+}
+
+// RLS configures the Envoy external rate limit gRPC service.
+type RLS struct {
+	// Addr is the address the gRPC service listens on. It is disabled if
+	// empty.
+	Addr  string     `json:"addr,omitempty"`
+	Rules []rls.Rule `json:"rules,omitempty"`
+}
+
+// Bandwidth configures per-key pacing of request and response bodies.
+type Bandwidth struct {
+	// UploadBytesPerSecond limits request bodies. It is disabled if zero.
+	UploadBytesPerSecond int64 `json:"uploadBytesPerSecond,omitempty"`
+
+	// DownloadBytesPerSecond limits response bodies. It is disabled if zero.
+	DownloadBytesPerSecond int64 `json:"downloadBytesPerSecond,omitempty"`
+
+	// Burst is the number of bytes that can be transferred at full speed
+	// before pacing starts. Zero means one second's worth.
+	Burst int64 `json:"burst,omitempty"`
+}
+
+// Enabled reports whether either direction is limited.
+func (b Bandwidth) Enabled() bool {
+	return b.UploadBytesPerSecond > 0 || b.DownloadBytesPerSecond > 0
+}
+
+// Adaptive configures the adaptive concurrency limit on requests passed to
+// the wrapped handler, as described in package adaptive.
+type Adaptive struct {
+	// Algorithm is adaptive.AlgorithmAIMD or adaptive.AlgorithmVegas. The
+	// limit is disabled if it is empty.
+	Algorithm    string   `json:"algorithm,omitempty"`
+	InitialLimit int      `json:"initialLimit"`
+	MinLimit     int      `json:"minLimit"`
+	MaxLimit     int      `json:"maxLimit"`
+	Timeout      Duration `json:"timeout"`
+	Backoff      float64  `json:"backoff"`
+}
+
+// Enabled reports whether an algorithm is configured.
+func (a Adaptive) Enabled() bool {
+	return a.Algorithm != ""
+}
+
+// Config returns the adaptive.Config described by a.
+func (a Adaptive) Config() adaptive.Config {
+	return adaptive.Config{
+		Algorithm:    a.Algorithm,
+		InitialLimit: a.InitialLimit,
+		MinLimit:     a.MinLimit,
+		MaxLimit:     a.MaxLimit,
+		Timeout:      time.Duration(a.Timeout),
+		Backoff:      a.Backoff,
+	}
+}
+
+// Shed configures load shedding by priority, as described in package shed.
+type Shed struct {
+	// Signal is shed.SignalInFlight or shed.SignalLatency. Load shedding is
+	// disabled if it is empty.
+	Signal          string      `json:"signal,omitempty"`
+	Capacity        int         `json:"capacity,omitempty"`
+	TargetLatency   Duration    `json:"targetLatency,omitempty"`
+	Start           float64     `json:"start"`
+	DefaultPriority int         `json:"defaultPriority"`
+	Rules           []shed.Rule `json:"rules,omitempty"`
+}
+
+// Enabled reports whether a load signal is configured.
+func (s Shed) Enabled() bool {
+	return s.Signal != ""
+}
+
+// Config returns the shed.Config described by s.
+func (s Shed) Config() shed.Config {
+	return shed.Config{
+		Signal:          s.Signal,
+		Capacity:        s.Capacity,
+		TargetLatency:   time.Duration(s.TargetLatency),
+		Start:           s.Start,
+		DefaultPriority: s.DefaultPriority,
+		Rules:           s.Rules,
+	}
+}
+
+// Decision configures the standalone decision API.
+type Decision struct {
+	// Addr is the address the JSON/HTTP API listens on. It is disabled if
+	// empty.
+	Addr string `json:"addr,omitempty"`
+
+	// GRPCAddr is the address the gRPC API listens on. It is disabled if
+	// empty.
+	GRPCAddr string `json:"grpcAddr,omitempty"`
+
+	// Policies are named policies that checks can select, in addition to
+	// the default policy configured by Limit.
+	Policies []policy.Policy `json:"policies,omitempty"`
+}
+
+// Enabled reports whether either decision API listener is configured.
+func (d Decision) Enabled() bool {
+	return d.Addr != "" || d.GRPCAddr != ""
+}
+
+// Log configures the decision log.
+type Log struct {
+	Level         string  `json:"level"`
+	Format        string  `json:"format"`
+	SampleAllowed float64 `json:"sampleAllowed"`
+}
+
+// Admin configures the admin API.
+type Admin struct {
+	// Addr is the address the admin API listens on. It is disabled if empty.
+	Addr string `json:"addr,omitempty"`
+
+	// PrivateHealth serves the health and version endpoints only on the admin
+	// API rather than also on the main listener, for deployments whose probes
+	// can reach it.
+	PrivateHealth bool `json:"privateHealth,omitempty"`
+}
+
+// Access configures the static allow and deny lists.
+type Access struct {
+	Allow    []string `json:"allow,omitempty"`
+	Deny     []string `json:"deny,omitempty"`
+	DenyBody string   `json:"denyBody"`
+}
+
+// Ban configures the penalty box.
+type Ban struct {
+	// Threshold is the number of rejections within Window that trigger a
+	// ban. The penalty box is disabled if it is zero.
+	Threshold   int      `json:"threshold"`
+	Window      Duration `json:"window"`
+	Duration    Duration `json:"duration"`
+	MaxDuration Duration `json:"maxDuration"`
+	ForgetAfter Duration `json:"forgetAfter"`
+}
+
+// Quota configures where calendar quota usage is kept. Quotas themselves are
+// set on policies.
+type Quota struct {
+	// StateFile is where usage is saved so that it survives restarts. It is
+	// required when any policy has a quota.
+	StateFile string `json:"stateFile,omitempty"`
+
+	// SyncInterval is how often usage is saved. Usage is also saved on
+	// shutdown.
+	SyncInterval Duration `json:"syncInterval"`
+}
+
+// Metering sink types.
+const (
+	// MeteringFile appends usage records to a rotating local file.
+	MeteringFile = "file"
+
+	// MeteringWebhook POSTs usage records to a URL.
+	MeteringWebhook = "webhook"
+)
+
+// Metering configures the export of per-minute usage records, as described
+// in package metering.
+type Metering struct {
+	// Sink is MeteringFile or MeteringWebhook. Metering is disabled if it
+	// is empty.
+	Sink string `json:"sink,omitempty"`
+
+	// File, Format, MaxBytes and MaxFiles configure the file sink.
+	File     string `json:"file,omitempty"`
+	Format   string `json:"format"`
+	MaxBytes int64  `json:"maxBytes"`
+	MaxFiles int    `json:"maxFiles"`
+
+	// WebhookURL and WebhookTimeout configure the webhook sink.
+	WebhookURL     string   `json:"webhookURL,omitempty"`
+	WebhookTimeout Duration `json:"webhookTimeout"`
+
+	// SpoolDir holds records until the sink accepts them.
+	SpoolDir string `json:"spoolDir,omitempty"`
+}
+
+// Enabled reports whether a sink is configured.
+func (m Metering) Enabled() bool {
+	return m.Sink != ""
+}
+
+// Notification sink types.
+const (
+	// NotifyWebhook POSTs events to a URL.
+	NotifyWebhook = "webhook"
+
+	// NotifyFile appends events to a local file.
+	NotifyFile = "file"
+)
+
+// Notify configures event notifications when keys change state, as described
+// in package notify.
+type Notify struct {
+	// Sink is NotifyWebhook or NotifyFile. Notifications are disabled if it
+	// is empty.
+	Sink string `json:"sink,omitempty"`
+
+	// WebhookURL, WebhookSecret and WebhookTimeout configure the webhook
+	// sink. Requests are signed if WebhookSecret is set.
+	WebhookURL     string   `json:"webhookURL,omitempty"`
+	WebhookSecret  string   `json:"webhookSecret,omitempty"`
+	WebhookTimeout Duration `json:"webhookTimeout"`
+
+	// File configures the file sink.
+	File string `json:"file,omitempty"`
+
+	// Events are the notify.Type values sent. Empty means all of them.
+	Events       []string `json:"events,omitempty"`
+	QuotaPercent int      `json:"quotaPercent,omitempty"`
+	RecoverAfter Duration `json:"recoverAfter"`
+	DedupWindow  Duration `json:"dedupWindow"`
+	MaxPerMinute int64    `json:"maxPerMinute"`
+}
+
+// Enabled reports whether a sink is configured.
+func (n Notify) Enabled() bool {
+	return n.Sink != ""
+}
+
+// Config returns the notify.Config described by n.
+func (n Notify) Config() notify.Config {
+	types := make([]notify.Type, len(n.Events))
+	for i, e := range n.Events {
+		types[i] = notify.Type(e)
+	}
+	return notify.Config{
+		Types:        types,
+		QuotaPercent: n.QuotaPercent,
+		RecoverAfter: time.Duration(n.RecoverAfter),
+		DedupWindow:  time.Duration(n.DedupWindow),
+		MaxPerMinute: n.MaxPerMinute,
+	}
+}
+
+// Duration is a time.Duration that is written to and read from JSON in
+// time.ParseDuration format, such as "1m30s". It is shared with policies,
+// which are embedded in the configuration.
+type Duration = policy.Duration
+
+// Default returns the built-in configuration.
+func Default() Config {
+	return Config{
+		BindAddr:    ":8080",
+		KeyStrategy: keys.StrategyIP,
+		Limit: Limit{
+			PerMinute: policy.DefaultLimitPerWindow,
+			Mode:      policy.ModeEnforce,
+			Cost:      "1",
+		},
+		Backend: Backend{
+			Type: BackendMemory,
+		},
+		Server: Server{
+			ReadHeaderTimeout: Duration(5 * time.Second),
+			ReadTimeout:       Duration(10 * time.Second),
+			WriteTimeout:      Duration(10 * time.Second),
+			IdleTimeout:       Duration(2 * time.Minute),
+			ShutdownTimeout:   Duration(25 * time.Second),
+			MaxHeaderBytes:    64 << 10,
+		},
+		TLS: TLS{
+			ClientAuth:     ClientAuthRequire,
+			ReloadInterval: Duration(30 * time.Second),
+		},
+		Breaker: Breaker{
+			MinRequests: 20,
+			Window:      Duration(10 * time.Second),
+			Cooldown:    Duration(30 * time.Second),
+			Probes:      1,
+		},
+		Adaptive: Adaptive{
+			InitialLimit: 20,
+			MinLimit:     1,
+			MaxLimit:     1000,
+			Timeout:      Duration(time.Second),
+			Backoff:      0.9,
+		},
+		Shed: Shed{
+			Start: shed.DefaultStart,
+		},
+		Log: Log{
+			Level:         "info",
+			Format:        logging.FormatJSON,
+			SampleAllowed: 1,
+		},
+		Access: Access{
+			DenyBody: access.DefaultDenyBody,
+		},
+		Ban: Ban{
+			Window:      Duration(time.Minute),
+			Duration:    Duration(5 * time.Minute),
+			MaxDuration: Duration(24 * time.Hour),
+			ForgetAfter: Duration(24 * time.Hour),
+		},
+		Quota: Quota{
+			SyncInterval: Duration(5 * time.Second),
+		},
+		Metering: Metering{
+			Format:         metering.FormatJSONLines,
+			MaxBytes:       100 << 20,
+			MaxFiles:       10,
+			WebhookTimeout: Duration(10 * time.Second),
+		},
+		Notify: Notify{
+			WebhookTimeout: Duration(10 * time.Second),
+			RecoverAfter:   Duration(time.Minute),
+			DedupWindow:    Duration(10 * time.Minute),
+			MaxPerMinute:   60,
+		},
+	}
+}
+
+// setting is a configuration value that can be overridden by an environment
+// variable and a command-line flag.
+type setting struct {
+	flag  string
+	env   string
+	usage string
+	set   func(c *Config, v string) error
+}
+
+var settings = []setting{
+	{"bind-addr", "BIND_ADDR", "address the rate limited endpoint listens on", str(func(c *Config) *string { return &c.BindAddr })},
+	{"forward-auth-path", "FORWARD_AUTH_PATH", "path of the forward-auth decision endpoint, such as /auth (disabled if empty)", str(func(c *Config) *string { return &c.ForwardAuthPath })},
+	{"key-strategy", "KEY_STRATEGY", `how clients are keyed: "ip", "forwarded-for", "client-cert" or "header:<Name>"`, str(func(c *Config) *string { return &c.KeyStrategy })},
+	{"limit-per-minute", "LIMIT_PER_MINUTE", "requests allowed per minute per key", integer(func(c *Config) *int64 { return &c.Limit.PerMinute })},
+	{"policy-mode", "POLICY_MODE", `"enforce" or "observe"`, func(c *Config, v string) error { c.Limit.Mode = policy.Mode(v); return nil }},
+	{"shadow-limit-per-minute", "SHADOW_LIMIT_PER_MINUTE", "limit of a shadow policy evaluated alongside the enforced one", integer(func(c *Config) *int64 { return &c.Limit.ShadowPerMinute })},
+	{"max-delay", "MAX_DELAY", "hold requests that would be rejected for up to this long (disabled if 0)", duration(func(c *Config) *Duration { return &c.Limit.MaxDelay })},
+	{"max-queued", "MAX_QUEUED", "requests per key held at once under MAX_DELAY (default 10)", integer(func(c *Config) *int { return &c.Limit.MaxQueued })},
+	{"quota-period", "QUOTA_PERIOD", `calendar period of the per-key quota: "day" or "month"`, str(func(c *Config) *string { return &c.Limit.Quota.Period })},
+	{"quota-limit", "QUOTA_LIMIT", "tokens allowed per key per quota period (disabled if 0)", integer(func(c *Config) *int64 { return &c.Limit.Quota.Limit })},
+	{"quota-location", "QUOTA_LOCATION", "IANA time zone quota periods are aligned to (default UTC)", str(func(c *Config) *string { return &c.Limit.Quota.Location })},
+	{"quota-state-file", "QUOTA_STATE_FILE", "file quota usage is saved to", str(func(c *Config) *string { return &c.Quota.StateFile })},
+	{"quota-sync-interval", "QUOTA_SYNC_INTERVAL", "how often quota usage is saved", duration(func(c *Config) *Duration { return &c.Quota.SyncInterval })},
+	{"cost", "COST", `tokens per request: "<n>", "content-length:<bytes>" or "query:<param>[:<per-token>]"`, str(func(c *Config) *string { return &c.Limit.Cost })},
+	{"post-charge-every", "POST_CHARGE_EVERY", "charge proxied requests an extra token per this much response time (disabled if 0)", duration(func(c *Config) *Duration { return &c.Limit.PostChargeEvery })},
+	{"backend", "BACKEND", `where rate limit state is kept: "memory" or "file"`, str(func(c *Config) *string { return &c.Backend.Type })},
+	{"state-file", "STATE_FILE", `state file for the "file" backend`, str(func(c *Config) *string { return &c.Backend.Path })},
+	{"read-header-timeout", "READ_HEADER_TIMEOUT", "time allowed to read request headers", duration(func(c *Config) *Duration { return &c.Server.ReadHeaderTimeout })},
+	{"read-timeout", "READ_TIMEOUT", "time allowed to read a request", duration(func(c *Config) *Duration { return &c.Server.ReadTimeout })},
+	{"write-timeout", "WRITE_TIMEOUT", "time allowed to write a response", duration(func(c *Config) *Duration { return &c.Server.WriteTimeout })},
+	{"idle-timeout", "IDLE_TIMEOUT", "time an idle keep-alive connection is kept open", duration(func(c *Config) *Duration { return &c.Server.IdleTimeout })},
+	{"shutdown-timeout", "SHUTDOWN_TIMEOUT", "time allowed to drain in-flight requests on shutdown", duration(func(c *Config) *Duration { return &c.Server.ShutdownTimeout })},
+	{"max-header-bytes", "MAX_HEADER_BYTES", "maximum size of request headers", integer(func(c *Config) *int { return &c.Server.MaxHeaderBytes })},
+	{"tls-cert-file", "TLS_CERT_FILE", "PEM certificate to serve HTTPS with", str(func(c *Config) *string { return &c.TLS.CertFile })},
+	{"tls-key-file", "TLS_KEY_FILE", "PEM private key to serve HTTPS with", str(func(c *Config) *string { return &c.TLS.KeyFile })},
+	{"tls-client-ca-file", "TLS_CLIENT_CA_FILE", "PEM bundle of CAs that client certificates are verified against", str(func(c *Config) *string { return &c.TLS.ClientCAFile })},
+	{"tls-client-auth", "TLS_CLIENT_AUTH", `client certificate verification: "require" or "optional"`, str(func(c *Config) *string { return &c.TLS.ClientAuth })},
+	{"tls-reload-interval", "TLS_RELOAD_INTERVAL", "how often certificate files are checked for changes", duration(func(c *Config) *Duration { return &c.TLS.ReloadInterval })},
+	{"proxy-upstream", "PROXY_UPSTREAM", "URL to proxy allowed requests to (enables proxy mode)", str(func(c *Config) *string { return &c.Proxy.Upstream })},
+	{"breaker-consecutive-failures", "BREAKER_CONSECUTIVE_FAILURES", "open an upstream's circuit breaker after this many failures in a row (disabled if 0)", integer(func(c *Config) *int { return &c.Breaker.ConsecutiveFailures })},
+	{"breaker-failure-rate", "BREAKER_FAILURE_RATE", "open an upstream's circuit breaker at this fraction of failed requests (disabled if 0)", float(func(c *Config) *float64 { return &c.Breaker.FailureRate })},
+	{"breaker-min-requests", "BREAKER_MIN_REQUESTS", "requests in the breaker window before the failure rate applies", integer(func(c *Config) *int { return &c.Breaker.MinRequests })},
+	{"breaker-window", "BREAKER_WINDOW", "window over which the breaker failure rate is measured", duration(func(c *Config) *Duration { return &c.Breaker.Window })},
+	{"breaker-cooldown", "BREAKER_COOLDOWN", "time an open circuit breaker rejects requests before probing", duration(func(c *Config) *Duration { return &c.Breaker.Cooldown })},
+	{"breaker-probes", "BREAKER_PROBES", "probe requests that must succeed to close a circuit breaker", integer(func(c *Config) *int { return &c.Breaker.Probes })},
+	{"rls-addr", "RLS_ADDR", "address the Envoy rate limit gRPC service listens on (disabled if empty)", str(func(c *Config) *string { return &c.RLS.Addr })},
+	{"upload-bytes-per-second", "UPLOAD_BYTES_PER_SECOND", "request body bytes per second per key (disabled if 0)", integer(func(c *Config) *int64 { return &c.Bandwidth.UploadBytesPerSecond })},
+	{"download-bytes-per-second", "DOWNLOAD_BYTES_PER_SECOND", "response body bytes per second per key (disabled if 0)", integer(func(c *Config) *int64 { return &c.Bandwidth.DownloadBytesPerSecond })},
+	{"bandwidth-burst", "BANDWIDTH_BURST", "bytes transferred at full speed before pacing starts (default one second's worth)", integer(func(c *Config) *int64 { return &c.Bandwidth.Burst })},
+	{"adaptive-algorithm", "ADAPTIVE_ALGORITHM", `adaptive concurrency limit algorithm: "aimd" or "vegas" (disabled if empty)`, str(func(c *Config) *string { return &c.Adaptive.Algorithm })},
+	{"adaptive-initial-limit", "ADAPTIVE_INITIAL_LIMIT", "concurrency limit to start from", integer(func(c *Config) *int { return &c.Adaptive.InitialLimit })},
+	{"adaptive-min-limit", "ADAPTIVE_MIN_LIMIT", "lowest concurrency limit", integer(func(c *Config) *int { return &c.Adaptive.MinLimit })},
+	{"adaptive-max-limit", "ADAPTIVE_MAX_LIMIT", "highest concurrency limit", integer(func(c *Config) *int { return &c.Adaptive.MaxLimit })},
+	{"adaptive-timeout", "ADAPTIVE_TIMEOUT", `latency above which a request counts as a failure under "aimd"`, duration(func(c *Config) *Duration { return &c.Adaptive.Timeout })},
+	{"adaptive-backoff", "ADAPTIVE_BACKOFF", "factor the concurrency limit is multiplied by on failure, between 0 and 1", float(func(c *Config) *float64 { return &c.Adaptive.Backoff })},
+	{"shed-signal", "SHED_SIGNAL", `load signal for shedding low priority requests: "in-flight" or "latency" (disabled if empty)`, str(func(c *Config) *string { return &c.Shed.Signal })},
+	{"shed-capacity", "SHED_CAPACITY", `requests in flight at full load under "in-flight"`, integer(func(c *Config) *int { return &c.Shed.Capacity })},
+	{"shed-target-latency", "SHED_TARGET_LATENCY", `average latency at full load under "latency"`, duration(func(c *Config) *Duration { return &c.Shed.TargetLatency })},
+	{"shed-start", "SHED_START", "load, from 0 to 1, at which the lowest priority is shed", float(func(c *Config) *float64 { return &c.Shed.Start })},
+	{"shed-default-priority", "SHED_DEFAULT_PRIORITY", "priority of requests that match no priority rule", integer(func(c *Config) *int { return &c.Shed.DefaultPriority })},
+	{"decision-addr", "DECISION_ADDR", "address the JSON/HTTP decision API listens on (disabled if empty)", str(func(c *Config) *string { return &c.Decision.Addr })},
+	{"decision-grpc-addr", "DECISION_GRPC_ADDR", "address the gRPC decision API listens on (disabled if empty)", str(func(c *Config) *string { return &c.Decision.GRPCAddr })},
+	{"log-level", "LOG_LEVEL", `minimum log level: "debug", "info", "warn" or "error"`, str(func(c *Config) *string { return &c.Log.Level })},
+	{"log-format", "LOG_FORMAT", `log output format: "json" or "text"`, str(func(c *Config) *string { return &c.Log.Format })},
+	{"log-sample-allowed", "LOG_SAMPLE_ALLOWED", "fraction of allowed decisions to log, from 0 to 1", float(func(c *Config) *float64 { return &c.Log.SampleAllowed })},
+	{"admin-addr", "ADMIN_ADDR", "address the admin API listens on (disabled if empty)", str(func(c *Config) *string { return &c.Admin.Addr })},
+	{"private-health", "PRIVATE_HEALTH", "serve /healthz, /readyz and /version only on the admin API", boolean(func(c *Config) *bool { return &c.Admin.PrivateHealth })},
+	{"allow-list", "ALLOW_LIST", "comma separated rules that bypass rate limiting", list(func(c *Config) *[]string { return &c.Access.Allow })},
+	{"deny-list", "DENY_LIST", "comma separated rules that are refused with 403", list(func(c *Config) *[]string { return &c.Access.Deny })},
+	{"deny-body", "DENY_BODY", "response body sent to denylisted clients", str(func(c *Config) *string { return &c.Access.DenyBody })},
+	{"metering-sink", "METERING_SINK", `where usage records are exported: "file" or "webhook" (disabled if empty)`, str(func(c *Config) *string { return &c.Metering.Sink })},
+	{"metering-file", "METERING_FILE", "file usage records are appended to", str(func(c *Config) *string { return &c.Metering.File })},
+	{"metering-format", "METERING_FORMAT", `usage record file format: "jsonl" or "csv"`, str(func(c *Config) *string { return &c.Metering.Format })},
+	{"metering-max-bytes", "METERING_MAX_BYTES", "size at which the usage record file is rotated (never if 0)", integer(func(c *Config) *int64 { return &c.Metering.MaxBytes })},
+	{"metering-max-files", "METERING_MAX_FILES", "rotated usage record files kept (all if 0)", integer(func(c *Config) *int { return &c.Metering.MaxFiles })},
+	{"metering-webhook-url", "METERING_WEBHOOK_URL", "URL usage records are POSTed to", str(func(c *Config) *string { return &c.Metering.WebhookURL })},
+	{"metering-webhook-timeout", "METERING_WEBHOOK_TIMEOUT", "time allowed for the usage record webhook to respond", duration(func(c *Config) *Duration { return &c.Metering.WebhookTimeout })},
+	{"metering-spool-dir", "METERING_SPOOL_DIR", "directory usage records are kept in until delivered", str(func(c *Config) *string { return &c.Metering.SpoolDir })},
+	{"notify-sink", "NOTIFY_SINK", `where key state events are sent: "webhook" or "file" (disabled if empty)`, str(func(c *Config) *string { return &c.Notify.Sink })},
+	{"notify-webhook-url", "NOTIFY_WEBHOOK_URL", "URL events are POSTed to", str(func(c *Config) *string { return &c.Notify.WebhookURL })},
+	{"notify-webhook-secret", "NOTIFY_WEBHOOK_SECRET", "secret event webhook requests are signed with (unsigned if empty)", str(func(c *Config) *string { return &c.Notify.WebhookSecret })},
+	{"notify-webhook-timeout", "NOTIFY_WEBHOOK_TIMEOUT", "time allowed for the event webhook to respond", duration(func(c *Config) *Duration { return &c.Notify.WebhookTimeout })},
+	{"notify-file", "NOTIFY_FILE", "file events are appended to", str(func(c *Config) *string { return &c.Notify.File })},
+	{"notify-events", "NOTIFY_EVENTS", "comma separated event types to send (all if empty)", list(func(c *Config) *[]string { return &c.Notify.Events })},
+	{"notify-quota-percent", "NOTIFY_QUOTA_PERCENT", "percentage of a quota at which an event is sent (disabled if 0)", integer(func(c *Config) *int { return &c.Notify.QuotaPercent })},
+	{"notify-recover-after", "NOTIFY_RECOVER_AFTER", "time without a rejection before a throttled key recovers", duration(func(c *Config) *Duration { return &c.Notify.RecoverAfter })},
+	{"notify-dedup-window", "NOTIFY_DEDUP_WINDOW", "time within which a repeated event for the same key is suppressed", duration(func(c *Config) *Duration { return &c.Notify.DedupWindow })},
+	{"notify-max-per-minute", "NOTIFY_MAX_PER_MINUTE", "events sent per minute across all keys", integer(func(c *Config) *int64 { return &c.Notify.MaxPerMinute })},
+	{"ban-threshold", "BAN_THRESHOLD", "rejections within the ban window that trigger a ban (disabled if 0)", integer(func(c *Config) *int { return &c.Ban.Threshold })},
+	{"ban-window", "BAN_WINDOW", "window over which rejections are counted for bans", duration(func(c *Config) *Duration { return &c.Ban.Window })},
+	{"ban-duration", "BAN_DURATION", "length of a first ban, doubled on each repeat", duration(func(c *Config) *Duration { return &c.Ban.Duration })},
+	{"ban-max-duration", "BAN_MAX_DURATION", "longest ban issued", duration(func(c *Config) *Duration { return &c.Ban.MaxDuration })},
+	{"ban-forget-after", "BAN_FORGET_AFTER", "time after a ban ends before offenses are forgotten", duration(func(c *Config) *Duration { return &c.Ban.ForgetAfter })},
+}
+
+// Load builds the configuration from the given command-line arguments and
+// environment. The config file is named by the -config flag or CONFIG_FILE
+// environment variable. The result is validated before it is returned.
+func Load(args []string, lookupEnv func(string) (string, bool)) (Config, error) {
+	fs := flag.NewFlagSet("strongdm", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+
+	configFile := fs.String("config", "", "JSON config file (env CONFIG_FILE)")
+	printConfig := fs.Bool("print-config", false, "print the effective configuration and exit")
+	flagValues := map[string]string{}
+	for _, s := range settings {
+		fs.Func(s.flag, fmt.Sprintf("%s (env %s)", s.usage, s.env), func(v string) error {
+			flagValues[s.flag] = v
+			return nil
+		})
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			fs.SetOutput(os.Stderr)
+			fs.PrintDefaults()
+		}
+		return Config{}, err
+	}
+	if fs.NArg() > 0 {
+		return Config{}, fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+
+	cfg := Default()
+
+	path := *configFile
+	if path == "" {
+		path, _ = lookupEnv("CONFIG_FILE")
+	}
+	if path != "" {
+		if err := cfg.loadFile(path); err != nil {
+			return Config{}, err
+		}
+	}
+
+	for _, s := range settings {
+		if v, ok := lookupEnv(s.env); ok {
+			if err := s.set(&cfg, v); err != nil {
+				return Config{}, fmt.Errorf("invalid %s: %w", s.env, err)
+			}
+		}
+	}
+	for _, s := range settings {
+		if v, ok := flagValues[s.flag]; ok {
+			if err := s.set(&cfg, v); err != nil {
+				return Config{}, fmt.Errorf("invalid -%s: %w", s.flag, err)
+			}
+		}
+	}
+	cfg.PrintConfig = *printConfig
+
+	if err := cfg.Validate(); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+// loadFile overlays the settings present in the JSON file at path.
+func (c *Config) loadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+	defer f.Close()
+
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(c); err != nil {
+		return fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// Validate checks the configuration, reporting every problem found.
+func (c Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	if _, _, err := net.SplitHostPort(c.BindAddr); err != nil {
+		errs = append(errs, fmt.Errorf("bindAddr %q: %w", c.BindAddr, err))
+	}
+	if c.Admin.Addr != "" {
+		if _, _, err := net.SplitHostPort(c.Admin.Addr); err != nil {
+			errs = append(errs, fmt.Errorf("admin.addr %q: %w", c.Admin.Addr, err))
+		}
+	}
+	check(!c.Admin.PrivateHealth || c.Admin.Addr != "", "admin.privateHealth requires admin.addr, or nothing serves the health endpoints")
+	check(c.ForwardAuthPath == "" || strings.HasPrefix(c.ForwardAuthPath, "/"),
+		"forwardAuthPath %q must start with /", c.ForwardAuthPath)
+	if _, err := keys.Parse(c.KeyStrategy); err != nil {
+		errs = append(errs, fmt.Errorf("keyStrategy: %w", err))
+	}
+
+	for _, p := range c.Policies() {
+		if err := p.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("limit: %w", err))
+		}
+	}
+	check(c.Limit.ShadowPerMinute >= 0, "limit.shadowPerMinute must not be negative")
+	if _, err := cost.Parse(c.Limit.Cost); err != nil {
+		errs = append(errs, fmt.Errorf("limit.cost: %w", err))
+	}
+	check(c.Limit.PostChargeEvery >= 0, "limit.postChargeEvery must not be negative")
+	if _, err := routes.New(c.Limit.Routes); err != nil {
+		errs = append(errs, fmt.Errorf("limit.routes: %w", err))
+	}
+
+	switch c.Backend.Type {
+	case BackendMemory:
+	case BackendFile:
+		check(c.Backend.Path != "", "backend.path is required for the %q backend", BackendFile)
+	default:
+		errs = append(errs, fmt.Errorf("backend.type %q must be %q or %q", c.Backend.Type, BackendMemory, BackendFile))
+	}
+
+	check(c.Server.ReadHeaderTimeout > 0, "server.readHeaderTimeout must be positive")
+	check(c.Server.ReadTimeout > 0, "server.readTimeout must be positive")
+	check(c.Server.WriteTimeout > 0, "server.writeTimeout must be positive")
+	check(c.Server.IdleTimeout > 0, "server.idleTimeout must be positive")
+	check(c.Server.ShutdownTimeout > 0, "server.shutdownTimeout must be positive")
+	check(c.Server.MaxHeaderBytes > 0, "server.maxHeaderBytes must be positive")
+
+	if c.TLS.Enabled() {
+		check(c.TLS.CertFile != "" && c.TLS.KeyFile != "", "tls.certFile and tls.keyFile must be set together")
+		check(c.TLS.ReloadInterval > 0, "tls.reloadInterval must be positive")
+	} else {
+		check(c.TLS.ClientCAFile == "", "tls.clientCAFile requires tls.certFile and tls.keyFile")
+	}
+	check(c.TLS.ClientAuth == ClientAuthRequire || c.TLS.ClientAuth == ClientAuthOptional,
+		"tls.clientAuth %q must be %q or %q", c.TLS.ClientAuth, ClientAuthRequire, ClientAuthOptional)
+	if c.KeyStrategy == keys.StrategyClientCert {
+		check(c.TLS.ClientCAFile != "", "keyStrategy %q requires tls.clientCAFile", keys.StrategyClientCert)
+	}
+
+	if c.Proxy.Enabled() {
+		if _, err := proxy.New(c.Proxy.AllRoutes()); err != nil {
+			errs = append(errs, fmt.Errorf("proxy: %w", err))
+		}
+	}
+
+	if c.Breaker.Enabled() {
+		if err := c.Breaker.Policy().Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("breaker: %w", err))
+		}
+		check(c.Proxy.Enabled(), "breaker requires proxy mode")
+	}
+
+	if c.RLS.Addr != "" {
+		if _, _, err := net.SplitHostPort(c.RLS.Addr); err != nil {
+			errs = append(errs, fmt.Errorf("rls.addr %q: %w", c.RLS.Addr, err))
+		}
+	}
+	for _, r := range c.RLS.Rules {
+		if err := r.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("rls: %w", err))
+		}
+	}
+
+	check(c.Bandwidth.UploadBytesPerSecond >= 0, "bandwidth.uploadBytesPerSecond must not be negative")
+	check(c.Bandwidth.DownloadBytesPerSecond >= 0, "bandwidth.downloadBytesPerSecond must not be negative")
+	check(c.Bandwidth.Burst >= 0, "bandwidth.burst must not be negative")
+
+	if c.Adaptive.Enabled() {
+		if err := c.Adaptive.Config().Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("adaptive: %w", err))
+		}
+		check(c.Proxy.Enabled(), "adaptive requires proxy mode")
+	}
+
+	if c.Shed.Enabled() {
+		if err := c.Shed.Config().Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("shed: %w", err))
+		}
+		check(c.Proxy.Enabled(), "shed requires proxy mode")
+		routeNames := map[string]bool{policy.DefaultName: true}
+		for _, r := range c.Limit.Routes {
+			routeNames[r.Name] = true
+		}
+		for _, r := range c.Shed.Rules {
+			check(r.Route == "" || routeNames[r.Route], "shed: priority rule names unknown route %q", r.Route)
+		}
+	}
+
+	if c.Decision.Addr != "" {
+		if _, _, err := net.SplitHostPort(c.Decision.Addr); err != nil {
+			errs = append(errs, fmt.Errorf("decision.addr %q: %w", c.Decision.Addr, err))
+		}
+	}
+	if c.Decision.GRPCAddr != "" {
+		if _, _, err := net.SplitHostPort(c.Decision.GRPCAddr); err != nil {
+			errs = append(errs, fmt.Errorf("decision.grpcAddr %q: %w", c.Decision.GRPCAddr, err))
+		}
+	}
+	names := map[string]bool{}
+	for _, p := range c.DecisionPolicies() {
+		if err := p.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("decision: %w", err))
+		}
+		check(!names[p.Name], "decision: duplicate policy %q", p.Name)
+		check(p.Name+"/" != decisionapi.AdHocPrefix, "decision: policy name %q is reserved for ad hoc checks", p.Name)
+		names[p.Name] = true
+	}
+	// Routes and decision policies namespace their buckets by name on the
+	// same counter.
+	if c.Decision.Enabled() {
+		for _, r := range c.Limit.Routes {
+			check(!names[r.Name], "limit.routes: route %q would share buckets with the decision policy of the same name", r.Name)
+			check(r.Name+"/" != decisionapi.AdHocPrefix, "limit.routes: route name %q is reserved for ad hoc checks", r.Name)
+		}
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.Log.Level)); err != nil {
+		errs = append(errs, fmt.Errorf("log.level %q is not a valid level", c.Log.Level))
+	}
+	check(c.Log.Format == logging.FormatJSON || c.Log.Format == logging.FormatText,
+		"log.format %q must be %q or %q", c.Log.Format, logging.FormatJSON, logging.FormatText)
+	check(c.Log.SampleAllowed >= 0 && c.Log.SampleAllowed <= 1, "log.sampleAllowed must be between 0 and 1")
+
+	if _, err := c.AccessRules(); err != nil {
+		errs = append(errs, fmt.Errorf("access: %w", err))
+	}
+
+	if c.QuotasEnabled() {
+		check(c.Quota.StateFile != "", "quota.stateFile is required when a policy has a quota")
+		check(c.Quota.SyncInterval > 0, "quota.syncInterval must be positive")
+	}
+
+	if c.Metering.Enabled() {
+		switch c.Metering.Sink {
+		case MeteringFile:
+			check(c.Metering.File != "", "metering.file is required for the %q sink", MeteringFile)
+			check(c.Metering.Format == metering.FormatJSONLines || c.Metering.Format == metering.FormatCSV,
+				"metering.format %q must be %q or %q", c.Metering.Format, metering.FormatJSONLines, metering.FormatCSV)
+			check(c.Metering.MaxBytes >= 0, "metering.maxBytes must not be negative")
+			check(c.Metering.MaxFiles >= 0, "metering.maxFiles must not be negative")
+		case MeteringWebhook:
+			u, err := url.Parse(c.Metering.WebhookURL)
+			check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "",
+				"metering.webhookURL %q must be an http or https URL", c.Metering.WebhookURL)
+			check(c.Metering.WebhookTimeout > 0, "metering.webhookTimeout must be positive")
+		default:
+			errs = append(errs, fmt.Errorf("metering.sink %q must be %q or %q", c.Metering.Sink, MeteringFile, MeteringWebhook))
+		}
+		check(c.Metering.SpoolDir != "", "metering.spoolDir is required")
+	}
+
+	if c.Notify.Enabled() {
+		switch c.Notify.Sink {
+		case NotifyWebhook:
+			u, err := url.Parse(c.Notify.WebhookURL)
+			check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "",
+				"notify.webhookURL %q must be an http or https URL", c.Notify.WebhookURL)
+			check(c.Notify.WebhookTimeout > 0, "notify.webhookTimeout must be positive")
+		case NotifyFile:
+			check(c.Notify.File != "", "notify.file is required for the %q sink", NotifyFile)
+		default:
+			errs = append(errs, fmt.Errorf("notify.sink %q must be %q or %q", c.Notify.Sink, NotifyWebhook, NotifyFile))
+		}
+		if err := c.Notify.Config().Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("notify: %w", err))
+		}
+	}
+
+	if c.Ban.Threshold != 0 {
+		check(c.Ban.Threshold > 0, "ban.threshold must not be negative")
+		check(c.Ban.Window > 0, "ban.window must be positive")
+		check(c.Ban.Duration > 0, "ban.duration must be positive")
+		check(c.Ban.MaxDuration >= 0, "ban.maxDuration must not be negative")
+		check(c.Ban.ForgetAfter >= 0, "ban.forgetAfter must not be negative")
+	}
+
+	return errors.Join(errs...)
+}
+
+// Policies returns the enforced policy, followed by the shadow policy if one
+// is configured.
+func (c Config) Policies() []policy.Policy {
+	policies := []policy.Policy{{
+		Name:           policy.DefaultName,
+		LimitPerWindow: c.Limit.PerMinute,
+		Mode:           c.Limit.Mode,
+		MaxDelay:       c.Limit.MaxDelay,
+		MaxQueued:      c.Limit.MaxQueued,
+		Schedules:      c.Limit.Schedules,
+		Quota:          c.Limit.Quota,
+	}}
+	if c.Limit.ShadowPerMinute > 0 {
+		policies = append(policies, policy.Policy{
+			Name:           "shadow",
+			LimitPerWindow: c.Limit.ShadowPerMinute,
+			Mode:           policy.ModeObserve,
+		})
+	}
+	return policies
+}
+
+// DecisionPolicies returns the policies available to the decision API: the
+// enforced policy followed by the named decision policies.
+func (c Config) DecisionPolicies() []policy.Policy {
+	return append(c.Policies()[:1:1], c.Decision.Policies...)
+}
+
+// QuotasEnabled reports whether the default policy, a route or a decision
+// policy has a calendar quota.
+func (c Config) QuotasEnabled() bool {
+	for _, p := range c.DecisionPolicies() {
+		if p.Quota.Enabled() {
+			return true
+		}
+	}
+	for _, r := range c.Limit.Routes {
+		if r.Quota.Enabled() {
+			return true
+		}
+	}
+	return false
+}
+
+// AccessRules parses the configured allow and deny lists.
+func (c Config) AccessRules() ([]access.Rule, error) {
+	var rules []access.Rule
+	for _, s := range c.Access.Allow {
+		rule, err := access.ParseRule(access.ActionAllow, s)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	for _, s := range c.Access.Deny {
+		rule, err := access.ParseRule(access.ActionDeny, s)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// Print writes the configuration to w as indented JSON, with secrets
+// redacted.
+func (c Config) Print(w io.Writer) error {
+	if c.Notify.WebhookSecret != "" {
+		c.Notify.WebhookSecret = "REDACTED"
+	}
+	jsonData, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(jsonData))
+	return err
+}
+
+func str(field func(*Config) *string) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		*field(c) = v
+		return nil
+	}
+}
+
+func integer[T int | int64](field func(*Config) *T) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return err
+		}
+		*field(c) = T(n)
+		return nil
+	}
+}
+
+func boolean(field func(*Config) *bool) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return err
+		}
+		*field(c) = b
+		return nil
+	}
+}
+
+func float(field func(*Config) *float64) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return err
+		}
+		*field(c) = f
+		return nil
+	}
+}
+
+func duration(field func(*Config) *Duration) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		return field(c).UnmarshalText([]byte(v))
+	}
+}
+
+// list parses a comma separated list, ignoring empty entries.
+func list(field func(*Config) *[]string) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		var items []string
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		*field(c) = items
+		return nil
+	}
+}
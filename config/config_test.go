@@ -0,0 +1,288 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"strongdm/policy"
+	"strongdm/quota"
+	"strongdm/rls"
+	"strongdm/routes"
+	"strongdm/shed"
+)
+
+// env returns a lookup function over the given variables.
+func env(vars map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		v, ok := vars[name]
+		return v, ok
+	}
+}
+
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	return path
+}
+
+func TestLoad_Defaults(t *testing.T) {
+	cfg, err := Load(nil, env(nil))
+	if err != nil {
+		t.Fatalf("Load() unexpected error: %v", err)
+	}
+	if cfg.BindAddr != ":8080" {
+		t.Errorf("Expected default bind address ':8080', got '%s'", cfg.BindAddr)
+	}
+	if cfg.Limit.PerMinute != 120 {
+		t.Errorf("Expected default limit 120, got %d", cfg.Limit.PerMinute)
+	}
+}
+
+func TestLoad_Precedence(t *testing.T) {
+	path := writeConfigFile(t, `{
+		"bindAddr": ":7000",
+		"limit": {"perMinute": 30},
+		"server": {"shutdownTimeout": "5s"},
+		"log": {"format": "text"}
+	}`)
+
+	cfg, err := Load(
+		[]string{"-config", path, "-limit-per-minute", "90"},
+		env(map[string]string{"LIMIT_PER_MINUTE": "60", "BIND_ADDR": ":9000"}),
+	)
+	if err != nil {
+		t.Fatalf("Load() unexpected error: %v", err)
+	}
+
+	if cfg.BindAddr != ":9000" {
+		t.Errorf("Env should override file, got bind address '%s'", cfg.BindAddr)
+	}
+	if cfg.Limit.PerMinute != 90 {
+		t.Errorf("Flag should override env, got limit %d", cfg.Limit.PerMinute)
+	}
+	if time.Duration(cfg.Server.ShutdownTimeout) != 5*time.Second {
+		t.Errorf("File should override default, got shutdown timeout %v", time.Duration(cfg.Server.ShutdownTimeout))
+	}
+	if cfg.Log.Format != "text" {
+		t.Errorf("Expected log format from file, got '%s'", cfg.Log.Format)
+	}
+	if cfg.Log.Level != "info" {
+		t.Errorf("Settings absent from the file should keep defaults, got log level '%s'", cfg.Log.Level)
+	}
+}
+
+func TestLoad_ConfigFileFromEnv(t *testing.T) {
+	path := writeConfigFile(t, `{"admin": {"addr": ":9090"}}`)
+
+	cfg, err := Load(nil, env(map[string]string{"CONFIG_FILE": path, "PRIVATE_HEALTH": "true"}))
+	if err != nil {
+		t.Fatalf("Load() unexpected error: %v", err)
+	}
+	if cfg.Admin.Addr != ":9090" {
+		t.Errorf("Expected admin address ':9090', got '%s'", cfg.Admin.Addr)
+	}
+	if !cfg.Admin.PrivateHealth {
+		t.Error("Expected PRIVATE_HEALTH to keep health endpoints on the admin API")
+	}
+}
+
+func TestLoad_UnknownFileField(t *testing.T) {
+	path := writeConfigFile(t, `{"bindAdr": ":7000"}`)
+
+	if _, err := Load([]string{"-config", path}, env(nil)); err == nil {
+		t.Error("Expected error for unknown config file field")
+	}
+}
+
+func TestLoad_Lists(t *testing.T) {
+	cfg, err := Load([]string{"-allow-list", "10.0.0.0/8, key:internal,"}, env(nil))
+	if err != nil {
+		t.Fatalf("Load() unexpected error: %v", err)
+	}
+	if len(cfg.Access.Allow) != 2 {
+		t.Errorf("Expected 2 allow rules, got %v", cfg.Access.Allow)
+	}
+}
+
+func TestLoad_InvalidValue(t *testing.T) {
+	_, err := Load(nil, env(map[string]string{"BAN_WINDOW": "soon"}))
+	if err == nil || !strings.Contains(err.Error(), "BAN_WINDOW") {
+		t.Errorf("Expected error naming BAN_WINDOW, got %v", err)
+	}
+}
+
+func TestLoad_PrintConfig(t *testing.T) {
+	cfg, err := Load([]string{"--print-config"}, env(nil))
+	if err != nil {
+		t.Fatalf("Load() unexpected error: %v", err)
+	}
+	if !cfg.PrintConfig {
+		t.Error("Expected PrintConfig to be set")
+	}
+
+	var buf bytes.Buffer
+	if err := cfg.Print(&buf); err != nil {
+		t.Fatalf("Print() unexpected error: %v", err)
+	}
+	var printed Config
+	if err := json.Unmarshal(buf.Bytes(), &printed); err != nil {
+		t.Fatalf("Printed config should be valid JSON: %v", err)
+	}
+	if printed.Server.IdleTimeout != cfg.Server.IdleTimeout {
+		t.Error("Printed config should round trip")
+	}
+}
+
+func TestPrint_RedactsSecrets(t *testing.T) {
+	cfg := Default()
+	cfg.Notify.WebhookSecret = "s3cret"
+
+	var buf bytes.Buffer
+	if err := cfg.Print(&buf); err != nil {
+		t.Fatalf("Print() unexpected error: %v", err)
+	}
+	if strings.Contains(buf.String(), "s3cret") {
+		t.Error("Expected the webhook secret to be redacted")
+	}
+	if cfg.Notify.WebhookSecret != "s3cret" {
+		t.Error("Expected Print not to modify the configuration")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(c *Config)
+		wantErr string
+	}{
+		{name: "default", modify: func(c *Config) {}},
+		{name: "bind address", modify: func(c *Config) { c.BindAddr = "8080" }, wantErr: "bindAddr"},
+		{name: "forward auth path", modify: func(c *Config) { c.ForwardAuthPath = "auth" }, wantErr: "forwardAuthPath"},
+		{name: "key strategy", modify: func(c *Config) { c.KeyStrategy = "cookie" }, wantErr: "keyStrategy"},
+		{name: "negative limit", modify: func(c *Config) { c.Limit.PerMinute = -1 }, wantErr: "limit"},
+		{name: "mode", modify: func(c *Config) { c.Limit.Mode = "maybe" }, wantErr: "mode"},
+		{name: "max delay", modify: func(c *Config) { c.Limit.MaxDelay = -1 }, wantErr: "max delay"},
+		{name: "cost", modify: func(c *Config) { c.Limit.Cost = "body" }, wantErr: "limit.cost"},
+		{name: "post charge", modify: func(c *Config) { c.Limit.PostChargeEvery = -1 }, wantErr: "limit.postChargeEvery"},
+		{name: "route", modify: func(c *Config) {
+			c.Limit.Routes = []routes.Route{{Pattern: "POST", Policy: policy.Policy{Name: "login"}}}
+		}, wantErr: "limit.routes"},
+		{name: "file backend path", modify: func(c *Config) { c.Backend.Type = BackendFile }, wantErr: "backend.path"},
+		{name: "backend type", modify: func(c *Config) { c.Backend.Type = "redis" }, wantErr: "backend.type"},
+		{name: "timeout", modify: func(c *Config) { c.Server.ReadTimeout = 0 }, wantErr: "server.readTimeout"},
+		{name: "tls pair", modify: func(c *Config) { c.TLS.CertFile = "tls.crt" }, wantErr: "tls.certFile"},
+		{name: "client ca without tls", modify: func(c *Config) { c.TLS.ClientCAFile = "ca.crt" }, wantErr: "tls.clientCAFile"},
+		{name: "client auth", modify: func(c *Config) { c.TLS.ClientAuth = "maybe" }, wantErr: "tls.clientAuth"},
+		{name: "client cert key without mtls", modify: func(c *Config) { c.KeyStrategy = "client-cert" }, wantErr: "client-cert"},
+		{name: "proxy upstream", modify: func(c *Config) { c.Proxy.Upstream = "localhost:8080" }, wantErr: "proxy"},
+		{name: "breaker", modify: func(c *Config) { c.Breaker.FailureRate = 1.5 }, wantErr: "breaker"},
+		{name: "breaker without proxy", modify: func(c *Config) { c.Breaker.ConsecutiveFailures = 5 }, wantErr: "breaker requires proxy mode"},
+		{name: "rls rule", modify: func(c *Config) { c.RLS.Rules = []rls.Rule{{Domain: "edge"}} }, wantErr: "rls"},
+		{name: "bandwidth", modify: func(c *Config) { c.Bandwidth.DownloadBytesPerSecond = -1 }, wantErr: "bandwidth.downloadBytesPerSecond"},
+		{name: "schedule", modify: func(c *Config) { c.Limit.Schedules = []policy.Schedule{{Name: "night", Start: "22:00", End: "6am"}} }, wantErr: "night"},
+		{name: "quota period", modify: func(c *Config) {
+			c.Limit.Quota = quota.Quota{Period: "week", Limit: 10}
+			c.Quota.StateFile = "quota.json"
+		}, wantErr: "quota period"},
+		{name: "quota state file", modify: func(c *Config) {
+			c.Limit.Routes = []routes.Route{{Pattern: "/api/", Policy: policy.Policy{Name: "api", LimitPerWindow: 60, Quota: quota.Quota{Period: quota.PeriodMonth, Limit: 1000}}}}
+		}, wantErr: "quota.stateFile"},
+		{name: "metering sink", modify: func(c *Config) { c.Metering.Sink = "kafka"; c.Metering.SpoolDir = "spool" }, wantErr: "metering.sink"},
+		{name: "metering spool", modify: func(c *Config) { c.Metering.Sink = "file"; c.Metering.File = "usage.jsonl" }, wantErr: "metering.spoolDir"},
+		{name: "metering webhook", modify: func(c *Config) {
+			c.Metering.Sink = "webhook"
+			c.Metering.WebhookURL = "billing:8080/usage"
+			c.Metering.SpoolDir = "spool"
+		}, wantErr: "metering.webhookURL"},
+		{name: "notify sink", modify: func(c *Config) { c.Notify.Sink = "slack" }, wantErr: "notify.sink"},
+		{name: "notify events", modify: func(c *Config) {
+			c.Notify.Sink = "file"
+			c.Notify.File = "events.jsonl"
+			c.Notify.Events = []string{"throttled", "slow"}
+		}, wantErr: "notify: unknown event type"},
+		{name: "adaptive algorithm", modify: func(c *Config) { c.Adaptive.Algorithm = "cubic" }, wantErr: "adaptive"},
+		{name: "adaptive limits", modify: func(c *Config) { c.Adaptive.Algorithm = "aimd"; c.Adaptive.MaxLimit = 0 }, wantErr: "maximum limit"},
+		{name: "adaptive without proxy", modify: func(c *Config) { c.Adaptive.Algorithm = "aimd" }, wantErr: "adaptive requires proxy mode"},
+		{name: "shed signal", modify: func(c *Config) { c.Shed.Signal = "cpu" }, wantErr: "shed"},
+		{name: "shed route", modify: func(c *Config) {
+			c.Shed.Signal = "in-flight"
+			c.Shed.Capacity = 100
+			c.Shed.Rules = []shed.Rule{{Priority: 1, Route: "checkout"}}
+		}, wantErr: "unknown route"},
+		{name: "shed without proxy", modify: func(c *Config) {
+			c.Shed.Signal = "in-flight"
+			c.Shed.Capacity = 100
+			c.Shed.Rules = []shed.Rule{{Priority: 1, Header: "X-Priority"}}
+		}, wantErr: "shed requires proxy mode"},
+		{name: "decision address", modify: func(c *Config) { c.Decision.GRPCAddr = "9090" }, wantErr: "decision.grpcAddr"},
+		{name: "decision policy", modify: func(c *Config) { c.Decision.Policies = []policy.Policy{{Name: "login", LimitPerWindow: -1}} }, wantErr: "decision"},
+		{name: "decision policy name", modify: func(c *Config) { c.Decision.Policies = []policy.Policy{policy.Default()} }, wantErr: "duplicate policy"},
+		{name: "route and decision policy name", modify: func(c *Config) {
+			c.Decision.Addr = ":9091"
+			c.Decision.Policies = []policy.Policy{{Name: "login", LimitPerWindow: 60}}
+			c.Limit.Routes = []routes.Route{{Pattern: "POST /login", Policy: policy.Policy{Name: "login", LimitPerWindow: 60}}}
+		}, wantErr: "share buckets"},
+		{name: "private health", modify: func(c *Config) { c.Admin.PrivateHealth = true }, wantErr: "admin.privateHealth"},
+		{name: "log level", modify: func(c *Config) { c.Log.Level = "loud" }, wantErr: "log.level"},
+		{name: "log format", modify: func(c *Config) { c.Log.Format = "xml" }, wantErr: "log.format"},
+		{name: "sample rate", modify: func(c *Config) { c.Log.SampleAllowed = 2 }, wantErr: "log.sampleAllowed"},
+		{name: "access rule", modify: func(c *Config) { c.Access.Deny = []string{"10.0.0.0/99"} }, wantErr: "access"},
+		{name: "ban window", modify: func(c *Config) { c.Ban.Threshold = 3; c.Ban.Window = 0 }, wantErr: "ban.window"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Default()
+			tt.modify(&cfg)
+			err := cfg.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate() unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Validate() error = %v, expected it to mention %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPolicies(t *testing.T) {
+	cfg := Default()
+	if len(cfg.Policies()) != 1 {
+		t.Errorf("Expected only the enforced policy by default")
+	}
+
+	cfg.Limit.ShadowPerMinute = 60
+	policies := cfg.Policies()
+	if len(policies) != 2 || policies[1].Enforced() {
+		t.Errorf("Expected an observe-only shadow policy, got %+v", policies)
+	}
+}
+
+func TestProxy_AllRoutes(t *testing.T) {
+	cfg := Default()
+	if cfg.Proxy.Enabled() {
+		t.Error("Proxy mode should be disabled by default")
+	}
+
+	path := writeConfigFile(t, `{"proxy": {"routes": [{"pattern": "/api/", "upstream": "http://api:8080"}]}}`)
+	cfg, err := Load([]string{"-config", path, "-proxy-upstream", "http://web:8080"}, env(nil))
+	if err != nil {
+		t.Fatalf("Load() unexpected error: %v", err)
+	}
+
+	routes := cfg.Proxy.AllRoutes()
+	if len(routes) != 2 || routes[1].Pattern != "/" || routes[1].Upstream != "http://web:8080" {
+		t.Errorf("Expected configured routes followed by the default upstream, got %+v", routes)
+	}
+}
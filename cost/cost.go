@@ -0,0 +1,136 @@
+// Package cost implements the strategies used to decide how many tokens a
+// request spends, so that expensive requests use more of the budget.
+package cost
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Func returns the number of tokens a request costs.
+type Func func(r *http.Request) int64
+
+// Strategy prefixes accepted by Parse.
+const (
+	StrategyContentLengthPrefix = "content-length:"
+	StrategyQueryPrefix         = "query:"
+)
+
+// One charges every request a single token.
+func One(*http.Request) int64 {
+	return 1
+}
+
+// Parse returns the cost function for a strategy:
+//
+//	"<n>"                           a constant n tokens per request
+//	"content-length:<bytes>"        one token per started <bytes> of body
+//	"query:<param>[:<per-token>]"   the integer value of the query parameter,
+//	                                divided by per-token and rounded up
+//
+// Variable costs are at least 1, and fall back to 1 if the request lacks the
+// attribute, such as a missing query parameter. A body of unknown length is
+// buffered to be measured, as described in ContentLength.
+func Parse(strategy string) (Func, error) {
+	switch {
+	case strings.HasPrefix(strategy, StrategyContentLengthPrefix):
+		perToken, err := positive(strings.TrimPrefix(strategy, StrategyContentLengthPrefix))
+		if err != nil {
+			return nil, fmt.Errorf("cost strategy %q: %w", strategy, err)
+		}
+		return ContentLength(perToken), nil
+	case strings.HasPrefix(strategy, StrategyQueryPrefix):
+		name, per, hasPer := strings.Cut(strings.TrimPrefix(strategy, StrategyQueryPrefix), ":")
+		if name == "" {
+			return nil, fmt.Errorf("cost strategy %q is missing a parameter name", strategy)
+		}
+		perToken := int64(1)
+		if hasPer {
+			var err error
+			if perToken, err = positive(per); err != nil {
+				return nil, fmt.Errorf("cost strategy %q: %w", strategy, err)
+			}
+		}
+		return Query(name, perToken), nil
+	default:
+		n, err := strconv.ParseInt(strategy, 10, 64)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("unknown cost strategy %q, must be a non-negative integer, %q or %q", strategy,
+				StrategyContentLengthPrefix+"<bytes>", StrategyQueryPrefix+"<param>[:<per-token>]")
+		}
+		return Constant(n), nil
+	}
+}
+
+// Constant charges every request n tokens.
+func Constant(n int64) Func {
+	return func(*http.Request) int64 {
+		return n
+	}
+}
+
+// MaxBufferedBody is the largest body of unknown length, such as a chunked
+// one, that ContentLength buffers to measure.
+const MaxBufferedBody = 1 << 20
+
+// ContentLength charges one token per started bytesPerToken of request body.
+// A body of unknown length is read into memory and charged by its size, and
+// r.Body is replaced so that it can still be read in full. A body longer than
+// MaxBufferedBody costs math.MaxInt64, so it is more than any bucket holds.
+func ContentLength(bytesPerToken int64) Func {
+	return func(r *http.Request) int64 {
+		if r.ContentLength < 0 && r.Body != nil && r.Body != http.NoBody {
+			measure(r)
+		}
+		if r.ContentLength < 0 {
+			return math.MaxInt64
+		}
+		return ceilDiv(r.ContentLength, bytesPerToken)
+	}
+}
+
+// measure reads up to MaxBufferedBody bytes of r.Body and puts them back in
+// front of the rest. If that was the whole body, it sets r.ContentLength.
+func measure(r *http.Request) {
+	buf, err := io.ReadAll(io.LimitReader(r.Body, MaxBufferedBody+1))
+	if err == nil && len(buf) <= MaxBufferedBody {
+		r.ContentLength = int64(len(buf))
+	}
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(buf), r.Body), r.Body}
+}
+
+// Query charges the integer value of the named query parameter, such as
+// page_size, divided by perToken.
+func Query(name string, perToken int64) Func {
+	return func(r *http.Request) int64 {
+		n, err := strconv.ParseInt(r.URL.Query().Get(name), 10, 64)
+		if err != nil {
+			return 1
+		}
+		return ceilDiv(n, perToken)
+	}
+}
+
+// ceilDiv divides n by d, rounding up, with a minimum of 1.
+func ceilDiv(n, d int64) int64 {
+	if n <= 0 {
+		return 1
+	}
+	return max(1, n/d+min(1, n%d))
+}
+
+func positive(s string) (int64, error) {
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("%q must be a positive integer", s)
+	}
+	return n, nil
+}
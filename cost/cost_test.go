@@ -0,0 +1,86 @@
+package cost
+
+import (
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		strategy string
+		target   string
+		body     string
+		expected int64
+		wantErr  bool
+	}{
+		{strategy: "1", target: "/", expected: 1},
+		{strategy: "10", target: "/", expected: 10},
+		{strategy: "0", target: "/", expected: 0},
+		{strategy: "content-length:1024", target: "/", body: strings.Repeat("x", 1024), expected: 1},
+		{strategy: "content-length:1024", target: "/", body: strings.Repeat("x", 1025), expected: 2},
+		{strategy: "content-length:1024", target: "/", expected: 1},
+		{strategy: "query:page_size", target: "/?page_size=50", expected: 50},
+		{strategy: "query:page_size:100", target: "/?page_size=250", expected: 3},
+		{strategy: "query:page_size:100", target: "/?page_size=0", expected: 1},
+		{strategy: "query:page_size", target: "/?page_size=all", expected: 1},
+		{strategy: "query:page_size", target: "/", expected: 1},
+		{strategy: "-1", wantErr: true},
+		{strategy: "content-length:", wantErr: true},
+		{strategy: "content-length:0", wantErr: true},
+		{strategy: "query:", wantErr: true},
+		{strategy: "query:page_size:x", wantErr: true},
+		{strategy: "body", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.strategy+tt.target, func(t *testing.T) {
+			fn, err := Parse(tt.strategy)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Parse(%q) expected error", tt.strategy)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse(%q) unexpected error: %v", tt.strategy, err)
+			}
+
+			req := httptest.NewRequest(http.MethodPost, tt.target, strings.NewReader(tt.body))
+			if got := fn(req); got != tt.expected {
+				t.Errorf("Expected cost %d, got %d", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestContentLength_Unknown(t *testing.T) {
+	body := strings.Repeat("x", 2049)
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req.Body = io.NopCloser(strings.NewReader(body))
+	req.ContentLength = -1
+
+	if got := ContentLength(1024)(req); got != 3 {
+		t.Errorf("Expected a chunked body to be charged by its size, got %d", got)
+	}
+	if req.ContentLength != int64(len(body)) {
+		t.Errorf("Expected ContentLength %d, got %d", len(body), req.ContentLength)
+	}
+	if b, err := io.ReadAll(req.Body); err != nil || string(b) != body {
+		t.Errorf("Expected the body to be readable in full, got %d bytes, %v", len(b), err)
+	}
+
+	big := strings.Repeat("x", MaxBufferedBody+1)
+	req = httptest.NewRequest(http.MethodPost, "/", nil)
+	req.Body = io.NopCloser(strings.NewReader(big))
+	req.ContentLength = -1
+	if got := ContentLength(1024)(req); got != math.MaxInt64 {
+		t.Errorf("Expected a body over MaxBufferedBody to cost math.MaxInt64, got %d", got)
+	}
+	if b, err := io.ReadAll(req.Body); err != nil || string(b) != big {
+		t.Errorf("Expected the body to be readable in full, got %d bytes, %v", len(b), err)
+	}
+}
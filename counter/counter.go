@@ -1,14 +1,21 @@
 package counter
 
 import (
+	"encoding/json"
+	"io"
+	"sync"
 	"time"
 
 	"strongdm/bucket"
+	"strongdm/quota"
 )
 
 // Counter implements a leaky bucket algorithm to limit total calls per minute
 // (CPM).
 type Counter struct {
+	// mu guards buckets, which is shared between concurrent HTTP requests.
+	mu sync.Mutex
+
 	// buckets is the current state of the rate limit buckets.
 	buckets map[string]bucket.Bucket
 }
@@ -25,22 +32,134 @@ func New() *Counter {
 // state, and true/false to indicate whether the value was successfully added to
 // the bucket. If the limit is zero, it always returns success.
 func (p *Counter) Add(key string, limitPerWindow int64, add int64) Info {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	info, newBucket := p.check(time.Now(), key, limitPerWindow, add)
+	if info.Allowed && limitPerWindow != 0 {
+		p.buckets[key] = newBucket
+	}
+	return info
+}
+
+// Charge adds "add" tokens to the bucket specified by "key" even if that takes
+// it over its size, so that work that has already been done, such as a slow
+// response, is paid for by delaying the key's later requests.
+func (p *Counter) Charge(key string, limitPerWindow int64, add int64) Info {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	info, _ := p.check(now, key, limitPerWindow, 0)
+	if limitPerWindow == 0 || add <= 0 {
+		return info
+	}
+
+	b := p.buckets[key].Plus(now, limitPerWindow, add)
+	p.buckets[key] = b
+	info.Remaining = max(0, info.BucketSize-b.CountAt(now))
+	info.ResetAt = b.WillReach(info.BucketSize-1, now)
+	return info
+}
+
+// WouldAllow reports the Info that Add would return for the same arguments,
+// without adding anything to the bucket.
+func (p *Counter) WouldAllow(key string, limitPerWindow int64, add int64) Info {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	info, _ := p.check(time.Now(), key, limitPerWindow, add)
+	return info
+}
+
+// Peek returns the current state of the rate limit bucket specified by "key"
+// without modifying it. Allowed reports whether a single token could be added
+// right now, and ResetAt is the time at which that will become possible.
+func (p *Counter) Peek(key string, limitPerWindow int64) Info {
+	now := time.Now()
 	if limitPerWindow == 0 {
 		return Info{
 			Bucket:  key,
-			ResetAt: time.Now(),
+			ResetAt: now,
 			Allowed: true,
 		}
 	}
 
+	p.mu.Lock()
+	existingBucket := p.buckets[key]
+	p.mu.Unlock()
+
+	bucketSize := bucket.Size(limitPerWindow)
+	remaining := max(0, bucketSize-existingBucket.CountAt(now))
+	return Info{
+		Bucket:     key,
+		ResetAt:    existingBucket.WillReach(bucketSize-1, now),
+		BucketSize: bucketSize,
+		Remaining:  remaining,
+		Allowed:    remaining > 0,
+	}
+}
+
+// Save writes the state of all buckets that have not fully leaked to w, so it
+// can be restored with Load after a restart.
+func (p *Counter) Save(w io.Writer) error {
 	now := time.Now()
 
+	p.mu.Lock()
+	live := make(map[string]bucket.Bucket, len(p.buckets))
+	for key, b := range p.buckets {
+		if b.CountAt(now) > 0 {
+			live[key] = b
+		}
+	}
+	p.mu.Unlock()
+
+	return json.NewEncoder(w).Encode(live)
+}
+
+// Load replaces the state of the counter with buckets previously written by
+// Save.
+func (p *Counter) Load(r io.Reader) error {
+	buckets := map[string]bucket.Bucket{}
+	if err := json.NewDecoder(r).Decode(&buckets); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.buckets = buckets
+	return nil
+}
+
+// check computes the outcome of adding "add" tokens to the bucket specified by
+// "key" at the given time. It returns the resulting Info along with the bucket
+// that should be stored if the addition is allowed. The caller must hold mu.
+func (p *Counter) check(now time.Time, key string, limitPerWindow int64, add int64) (Info, bucket.Bucket) {
+	if limitPerWindow == 0 {
+		return Info{
+			Bucket:  key,
+			ResetAt: now,
+			Allowed: true,
+		}, bucket.Bucket{}
+	}
+
 	existingBucket := p.buckets[key]
 
 	newBucket := existingBucket.Plus(now, limitPerWindow, add)
 
 	newCount := newBucket.CountAt(now)
 	bucketSize := bucket.Size(limitPerWindow)
+	if add > bucketSize {
+		return Info{
+			Bucket:     key,
+			ResetAt:    now,
+			BucketSize: bucketSize,
+			Remaining:  max(0, bucketSize-existingBucket.CountAt(now)),
+			Allowed:    false,
+			Oversized:  true,
+		}, existingBucket
+	}
 	if newCount > bucketSize {
 		return Info{
 			Bucket:     key,
@@ -48,11 +167,9 @@ func (p *Counter) Add(key string, limitPerWindow int64, add int64) Info {
 			BucketSize: bucketSize,
 			Remaining:  max(0, bucketSize-existingBucket.CountAt(now)),
 			Allowed:    false,
-		}
+		}, existingBucket
 	}
 
-	p.buckets[key] = newBucket
-
 	remaining := bucketSize - newCount
 	return Info{
 		Bucket:     key,
@@ -60,7 +177,7 @@ func (p *Counter) Add(key string, limitPerWindow int64, add int64) Info {
 		BucketSize: bucketSize,
 		Remaining:  remaining,
 		Allowed:    true,
-	}
+	}, newBucket
 }
 
 // Info contains rate limit information produced by a rate limit check.
@@ -70,4 +187,20 @@ type Info struct {
 	BucketSize int64     `json:"bucketSize"`
 	Remaining  int64     `json:"remaining"`
 	Allowed    bool      `json:"allowed"`
+
+	// Oversized reports that the request cost more than BucketSize, so it
+	// was rejected and will never be allowed, however long the caller waits.
+	Oversized bool `json:"oversized,omitempty"`
+
+	// ConcurrencyLimit is the adaptive concurrency limit in effect, if
+	// any. It is set by the HTTP handler, not by Counter.
+	ConcurrencyLimit int64 `json:"concurrencyLimit,omitempty"`
+
+	// Schedule names the policy schedule that set the limit, if any. It is
+	// set by callers that apply policies, not by Counter.
+	Schedule string `json:"schedule,omitempty"`
+
+	// Quota is the key's usage of the policy's calendar quota, if it has
+	// one. It is set by callers that apply policies, not by Counter.
+	Quota *quota.Usage `json:"quota,omitempty"`
 }
@@ -1,6 +1,8 @@
 package counter
 
 import (
+	"bytes"
+	"strings"
 	"testing"
 	"time"
 
@@ -199,6 +201,62 @@ func TestCounter_Add_AddMultipleTokens(t *testing.T) {
 	}
 }
 
+func TestCounter_Add_Oversized(t *testing.T) {
+	counter := New()
+	limitPerWindow := int64(180) // 3 per second
+
+	info := counter.Add("test-key", limitPerWindow, 4)
+	if info.Allowed {
+		t.Error("Adding more tokens than the bucket holds should be rejected")
+	}
+	if !info.Oversized {
+		t.Error("Expected oversized=true")
+	}
+	if info.Remaining != 3 {
+		t.Errorf("Expected Remaining=3, got %d", info.Remaining)
+	}
+
+	// A full bucket rejects a fitting request without it being oversized
+	counter.Add("test-key", limitPerWindow, 3)
+	info = counter.Add("test-key", limitPerWindow, 3)
+	if info.Allowed || info.Oversized {
+		t.Errorf("Expected an ordinary rejection, got %+v", info)
+	}
+}
+
+func TestCounter_Charge(t *testing.T) {
+	counter := New()
+	limitPerWindow := int64(120) // 2 per second
+
+	counter.Add("test-key", limitPerWindow, 1)
+	info := counter.Charge("test-key", limitPerWindow, 5)
+	if info.Remaining != 0 {
+		t.Errorf("Expected Remaining=0, got %d", info.Remaining)
+	}
+	if wait := time.Until(info.ResetAt); wait < 2*time.Second {
+		t.Errorf("Expected the overcharge to delay the reset by at least 2s, got %v", wait)
+	}
+	if counter.Add("test-key", limitPerWindow, 1).Allowed {
+		t.Error("Expected requests to be rejected after an overcharge")
+	}
+
+	if info := counter.Charge("unlimited", 0, 5); !info.Allowed || info.BucketSize != 0 {
+		t.Errorf("Charging an unlimited key should do nothing, got %+v", info)
+	}
+}
+
+func TestCounter_Add_LimitChange(t *testing.T) {
+	c := New()
+	c.Add("key", 60, 1)
+
+	// The bucket keeps its count when the limit changes, and the new limit
+	// applies from this Add.
+	info := c.Add("key", 600, 1)
+	if !info.Allowed || info.BucketSize != 10 || info.Remaining != 8 {
+		t.Errorf("Expected the earlier token to carry over to the larger bucket, got %+v", info)
+	}
+}
+
 func TestCounter_Add_NonExistentKey(t *testing.T) {
 	counter := New()
 	limitPerWindow := int64(60) // 1 per second
@@ -275,3 +333,125 @@ func TestInfo_Fields(t *testing.T) {
 		t.Error("Expected Allowed=true")
 	}
 }
+
+func TestCounter_Peek_DoesNotConsume(t *testing.T) {
+	counter := New()
+	limitPerWindow := int64(120) // 2 per second
+
+	for i := 0; i < 3; i++ {
+		info := counter.Peek("test-key", limitPerWindow)
+		if !info.Allowed {
+			t.Errorf("Peek %d should report allowed", i+1)
+		}
+		if info.Remaining != 2 {
+			t.Errorf("Expected Remaining=2, got %d", info.Remaining)
+		}
+		if info.BucketSize != 2 {
+			t.Errorf("Expected BucketSize=2, got %d", info.BucketSize)
+		}
+	}
+
+	if _, exists := counter.buckets["test-key"]; exists {
+		t.Error("Peek should not create a bucket")
+	}
+}
+
+func TestCounter_Peek_AfterAdd(t *testing.T) {
+	counter := New()
+	limitPerWindow := int64(60) // 1 per second
+
+	counter.Add("test-key", limitPerWindow, 1)
+
+	info := counter.Peek("test-key", limitPerWindow)
+	if info.Allowed {
+		t.Error("Peek should report not allowed for a full bucket")
+	}
+	if info.Remaining != 0 {
+		t.Errorf("Expected Remaining=0, got %d", info.Remaining)
+	}
+	if !info.ResetAt.After(time.Now()) {
+		t.Error("ResetAt should be in the future for a full bucket")
+	}
+}
+
+func TestCounter_Peek_ZeroLimit(t *testing.T) {
+	counter := New()
+
+	info := counter.Peek("test-key", 0)
+	if !info.Allowed {
+		t.Error("Expected allowed=true for zero limit")
+	}
+	if info.BucketSize != 0 {
+		t.Errorf("Expected BucketSize=0, got %d", info.BucketSize)
+	}
+}
+
+func TestCounter_WouldAllow(t *testing.T) {
+	counter := New()
+	limitPerWindow := int64(180) // 3 per second
+
+	info := counter.WouldAllow("test-key", limitPerWindow, 2)
+	if !info.Allowed {
+		t.Error("WouldAllow should allow 2 tokens in an empty bucket")
+	}
+	if info.Remaining != 1 {
+		t.Errorf("Expected Remaining=1, got %d", info.Remaining)
+	}
+	if _, exists := counter.buckets["test-key"]; exists {
+		t.Error("WouldAllow should not create a bucket")
+	}
+
+	counter.Add("test-key", limitPerWindow, 2)
+
+	info = counter.WouldAllow("test-key", limitPerWindow, 2)
+	if info.Allowed {
+		t.Error("WouldAllow should reject 2 tokens when only 1 remains")
+	}
+	info = counter.WouldAllow("test-key", limitPerWindow, 1)
+	if !info.Allowed {
+		t.Error("WouldAllow should allow 1 token when 1 remains")
+	}
+
+	// The dry runs above must not have consumed anything.
+	info = counter.Add("test-key", limitPerWindow, 1)
+	if !info.Allowed {
+		t.Error("Add should still allow the last token after dry runs")
+	}
+}
+
+func TestCounter_SaveLoad(t *testing.T) {
+	counter := New()
+	limitPerWindow := int64(60) // 1 per second
+
+	counter.Add("full", limitPerWindow, 1)
+	counter.buckets["leaked"] = bucket.Bucket{
+		UpdatedAt:      time.Now().Add(-time.Minute),
+		LimitPerWindow: limitPerWindow,
+		Count:          1.0,
+	}
+
+	var buf bytes.Buffer
+	if err := counter.Save(&buf); err != nil {
+		t.Fatalf("Save() unexpected error: %v", err)
+	}
+
+	restored := New()
+	if err := restored.Load(&buf); err != nil {
+		t.Fatalf("Load() unexpected error: %v", err)
+	}
+
+	if _, exists := restored.buckets["leaked"]; exists {
+		t.Error("Fully leaked buckets should not be saved")
+	}
+	info := restored.Add("full", limitPerWindow, 1)
+	if info.Allowed {
+		t.Error("Restored bucket should still be full")
+	}
+}
+
+func TestCounter_Load_Invalid(t *testing.T) {
+	counter := New()
+	if err := counter.Load(strings.NewReader("not json")); err == nil {
+		t.Error("Expected error loading invalid state")
+	}
+}
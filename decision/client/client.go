@@ -0,0 +1,353 @@
+// Package client is a Go client for the decision API. It exposes the same Add
+// surface as counter.Counter, so code written against a local counter can be
+// pointed at a shared decision service instead.
+//
+// A Client holds a single gRPC connection. Concurrent calls are coalesced:
+// while one CheckBatch RPC is in flight, new calls queue up and are sent
+// together in the next one.
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"strongdm/counter"
+	"strongdm/decision/decisionapi"
+	"strongdm/decision/decisionpb"
+	"strongdm/quota"
+)
+
+// DefaultTimeout bounds each RPC to the decision service.
+const DefaultTimeout = 250 * time.Millisecond
+
+// ErrInvalidAdd is returned for calls adding fewer than 1 token. The decision
+// service charges a cost of 0 as 1, so unlike counter.Counter, a Client cannot
+// check a bucket without spending from it.
+var ErrInvalidAdd = errors.New("add must be at least 1")
+
+// sweepThreshold is the number of cached rejections above which expired ones
+// are swept out.
+const sweepThreshold = 1024
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithTimeout sets the time allowed for each RPC to the decision service.
+func WithTimeout(d time.Duration) Option {
+	return func(c *Client) {
+		c.timeout = d
+	}
+}
+
+// WithFailOpen sets whether checks are allowed when the decision service
+// cannot be reached or returns an error. Clients fail open by default, so an
+// outage of the limiter does not become an outage of its callers.
+func WithFailOpen(failOpen bool) Option {
+	return func(c *Client) {
+		c.failOpen = failOpen
+	}
+}
+
+// WithPolicy charges Add calls under the named policy instead of the
+// limitPerWindow passed to Add.
+func WithPolicy(name string) Option {
+	return func(c *Client) {
+		c.policy = name
+	}
+}
+
+// WithLocalRejection remembers rejections and rejects further calls for the
+// same key locally, without an RPC, until the rejection's ResetAt.
+func WithLocalRejection() Option {
+	return func(c *Client) {
+		c.localRejection = true
+	}
+}
+
+// WithDialOptions adds options used when connecting to the decision service,
+// such as transport credentials. Connections are insecure unless overridden.
+func WithDialOptions(opts ...grpc.DialOption) Option {
+	return func(c *Client) {
+		c.dialOptions = append(c.dialOptions, opts...)
+	}
+}
+
+// Client checks rate limits against a remote decision service.
+type Client struct {
+	timeout        time.Duration
+	failOpen       bool
+	policy         string
+	localRejection bool
+	dialOptions    []grpc.DialOption
+
+	conn *grpc.ClientConn
+	api  decisionpb.DecisionServiceClient
+
+	// mu guards pending, flushing and rejected.
+	mu       sync.Mutex
+	pending  []*call
+	flushing bool
+	rejected map[cacheKey]rejection
+
+	// now is the clock used for local rejections, replaceable in tests.
+	now func() time.Time
+}
+
+// call is a check waiting for a batch RPC to complete.
+type call struct {
+	check  decisionapi.Check
+	result decisionapi.Result
+	err    error
+	done   chan struct{}
+}
+
+// cacheKey identifies a bucket for local rejection.
+type cacheKey struct {
+	key            string
+	policy         string
+	limitPerWindow int64
+}
+
+// rejection is a cached rejection, valid until info.ResetAt for calls adding
+// at least add tokens.
+type rejection struct {
+	info counter.Info
+	add  int64
+}
+
+// New creates a client for the decision service at target, such as
+// "limiter:8083".
+func New(target string, opts ...Option) (*Client, error) {
+	c := &Client{
+		timeout:     DefaultTimeout,
+		failOpen:    true,
+		dialOptions: []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())},
+		rejected:    map[cacheKey]rejection{},
+		now:         time.Now,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	conn, err := grpc.NewClient(target, c.dialOptions...)
+	if err != nil {
+		return nil, err
+	}
+	c.conn = conn
+	c.api = decisionpb.NewDecisionServiceClient(conn)
+	return c, nil
+}
+
+// Close closes the connection to the decision service.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Add adds "add" tokens to the bucket specified by "key", like
+// counter.Counter.Add. If the decision service cannot answer, the returned
+// Info is allowed or not according to the fail-open policy. Adding fewer than
+// 1 token is never allowed, as described in ErrInvalidAdd.
+func (c *Client) Add(key string, limitPerWindow int64, add int64) counter.Info {
+	info, _ := c.AddContext(context.Background(), key, limitPerWindow, add)
+	return info
+}
+
+// AddContext is like Add, but also returns the error that caused the
+// fail-open policy to be applied, if any. The RPC is not cancelled by ctx once
+// sent, since it may be shared with other calls, but AddContext returns early
+// if ctx is done, and a call still waiting for a batch is then not sent.
+func (c *Client) AddContext(ctx context.Context, key string, limitPerWindow int64, add int64) (counter.Info, error) {
+	if add < 1 {
+		return counter.Info{Bucket: key, ResetAt: c.now()}, ErrInvalidAdd
+	}
+	check := decisionapi.Check{
+		Key:            key,
+		Cost:           add,
+		Policy:         c.policy,
+		LimitPerWindow: limitPerWindow,
+	}
+	if c.policy != "" {
+		check.LimitPerWindow = 0
+	} else if limitPerWindow == 0 {
+		// As with counter.Counter, a zero limit is unlimited. The service
+		// would apply its default policy instead, so it is not asked.
+		return counter.Info{Bucket: key, ResetAt: c.now(), Allowed: true}, nil
+	}
+	ck := cacheKey{key: key, policy: check.Policy, limitPerWindow: check.LimitPerWindow}
+
+	if c.localRejection {
+		if info, ok := c.cachedRejection(ck, add); ok {
+			return info, nil
+		}
+	}
+
+	result, err := c.do(ctx, check)
+	if err != nil {
+		return counter.Info{
+			Bucket:  key,
+			ResetAt: c.now(),
+			Allowed: c.failOpen,
+		}, err
+	}
+
+	if c.localRejection && !result.Allowed {
+		c.cacheRejection(ck, result.Info, add)
+	}
+	return result.Info, nil
+}
+
+// cachedRejection returns a remembered rejection for ck that still applies to
+// adding "add" tokens.
+func (c *Client) cachedRejection(ck cacheKey, add int64) (counter.Info, bool) {
+	now := c.now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	r, ok := c.rejected[ck]
+	if !ok {
+		return counter.Info{}, false
+	}
+	if !now.Before(r.info.ResetAt) {
+		delete(c.rejected, ck)
+		return counter.Info{}, false
+	}
+	// A smaller addition may fit before ResetAt, so only larger ones are
+	// rejected locally.
+	if add < r.add {
+		return counter.Info{}, false
+	}
+	return r.info, true
+}
+
+func (c *Client) cacheRejection(ck cacheKey, info counter.Info, add int64) {
+	now := c.now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.rejected) >= sweepThreshold {
+		for k, r := range c.rejected {
+			if !now.Before(r.info.ResetAt) {
+				delete(c.rejected, k)
+			}
+		}
+	}
+	c.rejected[ck] = rejection{info: info, add: add}
+}
+
+// do queues check for the next batch RPC and waits for its result.
+func (c *Client) do(ctx context.Context, check decisionapi.Check) (decisionapi.Result, error) {
+	cl := &call{check: check, done: make(chan struct{})}
+
+	c.mu.Lock()
+	c.pending = append(c.pending, cl)
+	if !c.flushing {
+		c.flushing = true
+		go c.flush()
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-cl.done:
+		return cl.result, cl.err
+	case <-ctx.Done():
+		// A call that has not been sent yet is dropped, so it is not charged.
+		c.mu.Lock()
+		if i := slices.Index(c.pending, cl); i >= 0 {
+			c.pending = slices.Delete(c.pending, i, i+1)
+		}
+		c.mu.Unlock()
+		return decisionapi.Result{}, ctx.Err()
+	}
+}
+
+// flush sends pending calls in batches until none are left.
+func (c *Client) flush() {
+	for {
+		c.mu.Lock()
+		batch := c.pending
+		if len(batch) > decisionapi.MaxBatch {
+			batch = batch[:decisionapi.MaxBatch]
+		}
+		c.pending = c.pending[len(batch):]
+		if len(batch) == 0 {
+			c.pending = nil
+			c.flushing = false
+			c.mu.Unlock()
+			return
+		}
+		c.mu.Unlock()
+
+		c.send(batch)
+	}
+}
+
+// send makes a single CheckBatch RPC for batch and completes its calls.
+func (c *Client) send(batch []*call) {
+	req := &decisionpb.CheckBatchRequest{
+		Checks: make([]*decisionpb.CheckRequest, len(batch)),
+	}
+	for i, cl := range batch {
+		req.Checks[i] = &decisionpb.CheckRequest{
+			Key:            cl.check.Key,
+			Cost:           cl.check.Cost,
+			Policy:         cl.check.Policy,
+			LimitPerWindow: cl.check.LimitPerWindow,
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	resp, err := c.api.CheckBatch(ctx, req)
+	if err == nil && len(resp.GetResults()) != len(batch) {
+		err = fmt.Errorf("expected %d results, got %d", len(batch), len(resp.GetResults()))
+	}
+
+	for i, cl := range batch {
+		if err != nil {
+			cl.err = err
+		} else {
+			cl.result, cl.err = resultFromProto(resp.GetResults()[i])
+		}
+		close(cl.done)
+	}
+}
+
+func resultFromProto(r *decisionpb.CheckResponse) (decisionapi.Result, error) {
+	if r.GetError() != "" {
+		return decisionapi.Result{}, errors.New(r.GetError())
+	}
+	result := decisionapi.Result{
+		Info: counter.Info{
+			Bucket:     r.GetBucket(),
+			BucketSize: r.GetBucketSize(),
+			Remaining:  r.GetRemaining(),
+			Allowed:    r.GetAllowed(),
+			Oversized:  r.GetOversized(),
+			Schedule:   r.GetSchedule(),
+		},
+		Policy: r.GetPolicy(),
+	}
+	if r.GetResetAt() != nil {
+		result.ResetAt = r.GetResetAt().AsTime()
+	}
+	if q := r.GetQuota(); q != nil {
+		result.Quota = &quota.Usage{
+			Period:    q.GetPeriod(),
+			Limit:     q.GetLimit(),
+			Used:      q.GetUsed(),
+			Remaining: q.GetRemaining(),
+			ResetAt:   q.GetResetAt().AsTime(),
+			Allowed:   q.GetAllowed(),
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,265 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+
+	"strongdm/counter"
+	"strongdm/decision"
+	"strongdm/policy"
+)
+
+// testServer is an in-memory decision service that counts the RPCs it
+// receives.
+type testServer struct {
+	lis  *bufconn.Listener
+	rpcs atomic.Int64
+
+	// gate, if set, blocks each RPC until it receives a value.
+	gate chan struct{}
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	service, err := decision.New(counter.New(), []policy.Policy{
+		{Name: policy.DefaultName, LimitPerWindow: 120},
+		{Name: "login", LimitPerWindow: 60},
+	}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatalf("decision.New() unexpected error: %v", err)
+	}
+
+	ts := &testServer{lis: bufconn.Listen(1 << 20)}
+	srv := grpc.NewServer(grpc.UnaryInterceptor(func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ts.rpcs.Add(1)
+		if ts.gate != nil {
+			<-ts.gate
+		}
+		return handler(ctx, req)
+	}))
+	service.Register(srv)
+	go func() { _ = srv.Serve(ts.lis) }()
+	t.Cleanup(srv.Stop)
+	return ts
+}
+
+func (ts *testServer) client(t *testing.T, opts ...Option) *Client {
+	t.Helper()
+	opts = append(opts, WithDialOptions(grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+		return ts.lis.DialContext(ctx)
+	})))
+	c, err := New("passthrough:///bufnet", opts...)
+	if err != nil {
+		t.Fatalf("New() unexpected error: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestAdd(t *testing.T) {
+	c := newTestServer(t).client(t)
+
+	tests := []struct {
+		key       string
+		limit     int64
+		add       int64
+		allowed   bool
+		remaining int64
+	}{
+		{"alice", 60, 1, true, 0},
+		{"alice", 60, 1, false, 0},
+		{"bob", 180, 2, true, 1},
+		{"carol", 0, 100, true, 0},
+	}
+	for _, tt := range tests {
+		info := c.Add(tt.key, tt.limit, tt.add)
+		if info.Allowed != tt.allowed {
+			t.Errorf("Add(%q, %d, %d): expected allowed %v, got %v", tt.key, tt.limit, tt.add, tt.allowed, info.Allowed)
+		}
+		if info.Remaining != tt.remaining {
+			t.Errorf("Add(%q, %d, %d): expected %d remaining, got %d", tt.key, tt.limit, tt.add, tt.remaining, info.Remaining)
+		}
+	}
+}
+
+func TestAdd_Policy(t *testing.T) {
+	c := newTestServer(t).client(t, WithPolicy("login"))
+
+	info := c.Add("alice", 1000, 1)
+	if !info.Allowed || info.Bucket != "login/alice" || info.BucketSize != 1 {
+		t.Errorf("Expected the login policy to apply, got %+v", info)
+	}
+}
+
+func TestAdd_Coalesced(t *testing.T) {
+	ts := newTestServer(t)
+	ts.gate = make(chan struct{})
+	c := ts.client(t)
+
+	// Hold the first RPC open until the other calls have queued behind it,
+	// then let both RPCs through.
+	const calls = 10
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		c.Add("first", 600, 1)
+	}()
+	for ts.rpcs.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	for range calls - 1 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.Add("rest", 600, 1)
+		}()
+	}
+	for {
+		c.mu.Lock()
+		n := len(c.pending)
+		c.mu.Unlock()
+		if n == calls-1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	ts.gate <- struct{}{}
+	ts.gate <- struct{}{}
+	wg.Wait()
+
+	if got := ts.rpcs.Load(); got != 2 {
+		t.Errorf("Expected %d calls to be coalesced into 2 RPCs, got %d", calls, got)
+	}
+	if info := c.Add("rest", 600, 1); info.Remaining != 0 {
+		t.Errorf("Expected every coalesced call to be charged, got %d remaining", info.Remaining)
+	}
+}
+
+func TestAdd_FailurePolicy(t *testing.T) {
+	ts := newTestServer(t)
+	ts.lis.Close()
+
+	for _, failOpen := range []bool{true, false} {
+		c := ts.client(t, WithFailOpen(failOpen), WithTimeout(50*time.Millisecond))
+		info, err := c.AddContext(context.Background(), "alice", 60, 1)
+		if err == nil {
+			t.Error("Expected an error when the service is unreachable")
+		}
+		if info.Allowed != failOpen {
+			t.Errorf("Fail open %v: expected allowed %v, got %v", failOpen, failOpen, info.Allowed)
+		}
+	}
+}
+
+func TestAdd_UnknownPolicy(t *testing.T) {
+	c := newTestServer(t).client(t, WithPolicy("missing"), WithFailOpen(false))
+
+	info, err := c.AddContext(context.Background(), "alice", 0, 1)
+	if err == nil || info.Allowed {
+		t.Errorf("Expected a failed closed check, got %+v, %v", info, err)
+	}
+}
+
+func TestAdd_LocalRejection(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.client(t, WithLocalRejection())
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	c.Add("alice", 60, 1)
+	rejected := c.Add("alice", 60, 1)
+	if rejected.Allowed {
+		t.Fatal("Expected the second call to be rejected")
+	}
+	rpcs := ts.rpcs.Load()
+
+	if info := c.Add("alice", 60, 1); info.Allowed || info != rejected {
+		t.Errorf("Expected the cached rejection, got %+v", info)
+	}
+	if got := ts.rpcs.Load(); got != rpcs {
+		t.Errorf("Expected no RPC for a locally rejected call, got %d more", got-rpcs)
+	}
+
+	if info := c.Add("bob", 60, 1); !info.Allowed {
+		t.Error("Expected other keys not to be rejected locally")
+	}
+
+	now = rejected.ResetAt
+	c.Add("alice", 60, 1)
+	if got := ts.rpcs.Load(); got != rpcs+2 {
+		t.Errorf("Expected calls after ResetAt to reach the service, got %d RPCs", got-rpcs)
+	}
+}
+
+func TestAdd_Zero(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.client(t)
+
+	info, err := c.AddContext(context.Background(), "alice", 60, 0)
+	if !errors.Is(err, ErrInvalidAdd) || info.Allowed {
+		t.Errorf("Expected ErrInvalidAdd, got %+v, %v", info, err)
+	}
+	if got := ts.rpcs.Load(); got != 0 {
+		t.Errorf("Expected no RPC, got %d", got)
+	}
+	if info := c.Add("alice", 60, 1); !info.Allowed {
+		t.Error("Expected the rejected call not to be charged")
+	}
+}
+
+func TestAdd_Cancelled(t *testing.T) {
+	ts := newTestServer(t)
+	ts.gate = make(chan struct{})
+	c := ts.client(t)
+
+	// Hold the first RPC open so the next call queues behind it, then cancel
+	// the queued call before it is sent.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.Add("first", 600, 1)
+	}()
+	for ts.rpcs.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error)
+	go func() {
+		_, err := c.AddContext(ctx, "alice", 60, 1)
+		errc <- err
+	}()
+	for {
+		c.mu.Lock()
+		n := len(c.pending)
+		c.mu.Unlock()
+		if n == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if err := <-errc; !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	ts.gate <- struct{}{}
+	<-done
+
+	close(ts.gate)
+	if info := c.Add("alice", 60, 1); !info.Allowed {
+		t.Error("Expected the cancelled call not to be charged")
+	}
+	if got := ts.rpcs.Load(); got != 2 {
+		t.Errorf("Expected the cancelled call not to be sent, got %d RPCs", got)
+	}
+}
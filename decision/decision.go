@@ -0,0 +1,239 @@
+// Package decision implements a standalone rate limit check API for services
+// that are not behind the HTTP rate limiter. Callers ask whether a key may
+// spend a number of tokens under a named policy, over JSON/HTTP or gRPC, and
+// get back the counter.Info for the bucket.
+package decision
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"strongdm/counter"
+	"strongdm/decision/decisionapi"
+	"strongdm/keys"
+	"strongdm/metering"
+	"strongdm/metrics"
+	"strongdm/notify"
+	"strongdm/policy"
+	"strongdm/quota"
+)
+
+// MaxBatch is the largest number of checks accepted in a single batch.
+const MaxBatch = decisionapi.MaxBatch
+
+var (
+	// ErrInvalidCheck is returned for malformed checks, such as one without
+	// a key or with a negative cost.
+	ErrInvalidCheck = errors.New("invalid check")
+
+	// ErrUnknownPolicy is returned for checks that name a policy that is not
+	// configured.
+	ErrUnknownPolicy = errors.New("unknown policy")
+)
+
+// Check asks whether Key may spend Cost tokens.
+type Check = decisionapi.Check
+
+// AdHocPrefix namespaces the bucket keys of ad hoc checks. Keys are escaped
+// after it, as by keys.Bucket, so no client key can name an ad hoc bucket. No
+// policy or route may be named after it.
+const AdHocPrefix = decisionapi.AdHocPrefix
+
+// Result is the outcome of a Check.
+type Result = decisionapi.Result
+
+// Service answers checks against a counter.
+type Service struct {
+	counter  *counter.Counter
+	policies map[string]policy.Policy
+	logger   *slog.Logger
+	quotas   *quota.Store
+	meter    *metering.Meter
+	notifier *notify.Notifier
+}
+
+// Option configures a Service.
+type Option func(*Service)
+
+// WithQuotas enforces policies' calendar quotas, tracking usage in s.
+func WithQuotas(s *quota.Store) Option {
+	return func(svc *Service) {
+		svc.quotas = s
+	}
+}
+
+// WithMeter counts each check's result per key and policy in m.
+func WithMeter(m *metering.Meter) Option {
+	return func(svc *Service) {
+		svc.meter = m
+	}
+}
+
+// WithNotifier reports rejections and quota usage to n, which sends events
+// when keys change state.
+func WithNotifier(n *notify.Notifier) Option {
+	return func(svc *Service) {
+		svc.notifier = n
+	}
+}
+
+// New creates a service that charges checks to c. Policies are looked up by
+// name, and one named policy.DefaultName is used for checks that name none.
+func New(c *counter.Counter, policies []policy.Policy, logger *slog.Logger, opts ...Option) (*Service, error) {
+	byName := make(map[string]policy.Policy, len(policies))
+	for _, p := range policies {
+		if err := p.Validate(); err != nil {
+			return nil, err
+		}
+		if _, ok := byName[p.Name]; ok {
+			return nil, fmt.Errorf("duplicate policy %q", p.Name)
+		}
+		if p.Name+"/" == AdHocPrefix {
+			return nil, fmt.Errorf("policy name %q is reserved for ad hoc checks", p.Name)
+		}
+		byName[p.Name] = p
+	}
+	if _, ok := byName[policy.DefaultName]; !ok {
+		return nil, fmt.Errorf("policy %q is required", policy.DefaultName)
+	}
+	s := &Service{
+		counter:  c,
+		policies: byName,
+		logger:   logger,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s, nil
+}
+
+// Check charges a single check.
+func (s *Service) Check(ctx context.Context, check Check) (Result, error) {
+	if check.Key == "" {
+		return Result{}, fmt.Errorf("%w: key must not be empty", ErrInvalidCheck)
+	}
+	if check.Cost < 0 {
+		return Result{}, fmt.Errorf("%w: cost must not be negative", ErrInvalidCheck)
+	}
+	if check.LimitPerWindow < 0 {
+		return Result{}, fmt.Errorf("%w: limit must not be negative", ErrInvalidCheck)
+	}
+	cost := check.Cost
+	if cost == 0 {
+		cost = 1
+	}
+
+	// Named policies get their own key namespace so the same key can be
+	// limited independently under each of them. Keys are escaped, so they
+	// cannot name a bucket in another namespace.
+	var p policy.Policy
+	bucketKey := check.Key
+	switch {
+	case check.Policy != "":
+		var ok bool
+		p, ok = s.policies[check.Policy]
+		if !ok {
+			return Result{}, fmt.Errorf("%w %q", ErrUnknownPolicy, check.Policy)
+		}
+		bucketKey = keys.Bucket(p.Name, check.Key)
+	case check.LimitPerWindow != 0:
+		p = policy.Policy{LimitPerWindow: check.LimitPerWindow}
+		bucketKey = AdHocPrefix + keys.Escape(check.Key)
+	default:
+		p = s.policies[policy.DefaultName]
+		bucketKey = keys.Bucket(p.Name, check.Key)
+	}
+
+	p, schedule := p.At(time.Now())
+	var info counter.Info
+	outcome := metrics.OutcomeAllowed
+	quotaOn := s.quotas != nil && p.Quota.Enabled()
+	var usage quota.Usage
+	if quotaOn {
+		usage = s.quotas.WouldAllow(bucketKey, p.Quota, cost)
+	}
+	switch {
+	case quotaOn && !usage.Allowed:
+		// A check the quota refuses does not spend per-minute tokens.
+		info = s.counter.WouldAllow(bucketKey, p.LimitPerWindow, cost)
+		outcome = metrics.OutcomeQuotaExceeded
+		info.Allowed = false
+		info.ResetAt = usage.ResetAt
+	case quotaOn:
+		info = s.counter.Add(bucketKey, p.LimitPerWindow, cost)
+		if info.Allowed {
+			usage = s.quotas.Add(bucketKey, p.Quota, cost)
+			if !usage.Allowed {
+				outcome = metrics.OutcomeQuotaExceeded
+				info.Allowed = false
+				info.ResetAt = usage.ResetAt
+			}
+		} else {
+			usage = s.quotas.Peek(bucketKey, p.Quota)
+		}
+	default:
+		info = s.counter.Add(bucketKey, p.LimitPerWindow, cost)
+	}
+	if quotaOn {
+		info.Quota = &usage
+	}
+	info.Schedule = schedule
+
+	level := slog.LevelDebug
+	if !info.Allowed {
+		switch {
+		case info.Oversized:
+			outcome = metrics.OutcomeOversized
+		case outcome != metrics.OutcomeQuotaExceeded:
+			outcome = metrics.OutcomeRejected
+		}
+		level = slog.LevelInfo
+		if !p.Enforced() {
+			outcome = metrics.OutcomeWouldReject
+			info.Allowed = true
+		}
+	}
+	metrics.APIDecisions.Add(outcome, 1)
+	if s.meter != nil {
+		s.meter.Count(check.Key, p.Name, info.Allowed)
+	}
+	if s.notifier != nil {
+		if outcome == metrics.OutcomeRejected {
+			s.notifier.Rejected(check.Key, p.Name)
+		}
+		if info.Quota != nil {
+			s.notifier.QuotaUsed(check.Key, p.Name, *info.Quota)
+		}
+	}
+	s.logger.LogAttrs(ctx, level, "Decision API decision",
+		slog.String("key", bucketKey),
+		slog.String("policy", p.Name),
+		slog.Int64("cost", cost),
+		slog.String("outcome", outcome),
+		slog.Bool("allowed", info.Allowed),
+		slog.Int64("remaining", info.Remaining),
+		slog.Time("reset", info.ResetAt),
+	)
+
+	return Result{Info: info, Policy: p.Name}, nil
+}
+
+// CheckBatch charges each check independently, returning one result per
+// check in the same order. Checks that cannot be made have Error set.
+func (s *Service) CheckBatch(ctx context.Context, checks []Check) ([]Result, error) {
+	if len(checks) > MaxBatch {
+		return nil, fmt.Errorf("%w: batch of %d exceeds the maximum of %d", ErrInvalidCheck, len(checks), MaxBatch)
+	}
+	results := make([]Result, len(checks))
+	for i, check := range checks {
+		result, err := s.Check(ctx, check)
+		if err != nil {
+			result = Result{Policy: check.Policy, Error: err.Error()}
+		}
+		results[i] = result
+	}
+	return results, nil
+}
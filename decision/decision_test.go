@@ -0,0 +1,286 @@
+package decision
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"strongdm/counter"
+	"strongdm/decision/decisionpb"
+	"strongdm/policy"
+	"strongdm/quota"
+)
+
+func newTestService(t *testing.T) *Service {
+	t.Helper()
+	s, err := New(counter.New(), []policy.Policy{
+		{Name: policy.DefaultName, LimitPerWindow: 120},
+		{Name: "login", LimitPerWindow: 60},
+		{Name: "trial", LimitPerWindow: 60, Mode: policy.ModeObserve},
+	}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatalf("New() unexpected error: %v", err)
+	}
+	return s
+}
+
+func TestCheck(t *testing.T) {
+	s := newTestService(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name      string
+		check     Check
+		bucket    string
+		policy    string
+		allowed   bool
+		remaining int64
+	}{
+		{"default policy", Check{Key: "alice"}, "default/alice", "default", true, 1},
+		{"default policy again", Check{Key: "alice"}, "default/alice", "default", true, 0},
+		{"default policy exhausted", Check{Key: "alice"}, "default/alice", "default", false, 0},
+		{"named policy has its own bucket", Check{Key: "alice", Policy: "login"}, "login/alice", "login", true, 0},
+		{"cost", Check{Key: "bob", Cost: 2}, "default/bob", "default", true, 0},
+		{"ad hoc limit", Check{Key: "carol", LimitPerWindow: 180}, "adhoc/carol", "", true, 2},
+		{"observe mode", Check{Key: "dave", Policy: "trial", Cost: 2}, "trial/dave", "trial", true, 1},
+		{"key is escaped", Check{Key: "login/alice"}, "default/login%2Falice", "default", true, 1},
+	}
+	for _, tt := range tests {
+		result, err := s.Check(ctx, tt.check)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.name, err)
+		}
+		if result.Bucket != tt.bucket {
+			t.Errorf("%s: expected bucket %q, got %q", tt.name, tt.bucket, result.Bucket)
+		}
+		if result.Policy != tt.policy {
+			t.Errorf("%s: expected policy %q, got %q", tt.name, tt.policy, result.Policy)
+		}
+		if result.Allowed != tt.allowed {
+			t.Errorf("%s: expected allowed %v, got %v", tt.name, tt.allowed, result.Allowed)
+		}
+		if result.Remaining != tt.remaining {
+			t.Errorf("%s: expected %d remaining, got %d", tt.name, tt.remaining, result.Remaining)
+		}
+	}
+}
+
+func TestCheck_Invalid(t *testing.T) {
+	s := newTestService(t)
+
+	tests := []struct {
+		check Check
+		err   error
+	}{
+		{Check{}, ErrInvalidCheck},
+		{Check{Key: "alice", Cost: -1}, ErrInvalidCheck},
+		{Check{Key: "alice", LimitPerWindow: -1}, ErrInvalidCheck},
+		{Check{Key: "alice", Policy: "missing"}, ErrUnknownPolicy},
+	}
+	for _, tt := range tests {
+		if _, err := s.Check(context.Background(), tt.check); !errors.Is(err, tt.err) {
+			t.Errorf("Check(%+v): expected %v, got %v", tt.check, tt.err, err)
+		}
+	}
+}
+
+func TestCheck_Quota(t *testing.T) {
+	store, err := quota.Open("")
+	if err != nil {
+		t.Fatalf("Open() unexpected error: %v", err)
+	}
+	s, err := New(counter.New(), []policy.Policy{
+		{Name: policy.DefaultName, LimitPerWindow: 600, Quota: quota.Quota{Period: quota.PeriodDay, Limit: 1}},
+	}, slog.New(slog.NewTextHandler(io.Discard, nil)), WithQuotas(store))
+	if err != nil {
+		t.Fatalf("New() unexpected error: %v", err)
+	}
+	ctx := context.Background()
+
+	first, _ := s.Check(ctx, Check{Key: "alice"})
+	if !first.Allowed || first.Quota == nil || first.Quota.Remaining != 0 {
+		t.Fatalf("Expected the first check to use up the quota, got %+v", first)
+	}
+	second, _ := s.Check(ctx, Check{Key: "alice"})
+	if second.Allowed {
+		t.Error("Expected the check to be rejected once the quota is used up")
+	}
+	if second.Quota == nil || second.Quota.Used != 1 || !second.ResetAt.Equal(second.Quota.ResetAt) {
+		t.Errorf("Expected the reset to be the end of the quota period, got %+v", second.Info)
+	}
+	if got := s.counter.Peek("default/alice", 600).Remaining; got != 9 {
+		t.Errorf("Expected the rejected check not to spend per-minute tokens, got %d remaining", got)
+	}
+}
+
+func TestCheckBatch(t *testing.T) {
+	s := newTestService(t)
+
+	results, err := s.CheckBatch(context.Background(), []Check{
+		{Key: "alice", Policy: "login"},
+		{Key: "alice", Policy: "missing"},
+		{Key: "alice", Policy: "login"},
+	})
+	if err != nil {
+		t.Fatalf("CheckBatch() unexpected error: %v", err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("Expected 3 results, got %d", len(results))
+	}
+	if !results[0].Allowed || results[0].Error != "" {
+		t.Errorf("Expected first check to be allowed, got %+v", results[0])
+	}
+	if results[1].Error == "" {
+		t.Error("Expected an error for the unknown policy")
+	}
+	if results[2].Allowed {
+		t.Error("Expected third check to be rejected")
+	}
+
+	if _, err := s.CheckBatch(context.Background(), make([]Check, MaxBatch+1)); !errors.Is(err, ErrInvalidCheck) {
+		t.Errorf("Expected oversized batch to be invalid, got %v", err)
+	}
+}
+
+func TestNew_Invalid(t *testing.T) {
+	logger := slog.Default()
+	if _, err := New(counter.New(), []policy.Policy{{Name: "login", LimitPerWindow: 60}}, logger); err == nil {
+		t.Error("Expected error without a default policy")
+	}
+	if _, err := New(counter.New(), []policy.Policy{policy.Default(), policy.Default()}, logger); err == nil {
+		t.Error("Expected error for duplicate policies")
+	}
+	if _, err := New(counter.New(), []policy.Policy{policy.Default(), {Name: "adhoc", LimitPerWindow: 60}}, logger); err == nil {
+		t.Error("Expected error for a policy named like the ad hoc namespace")
+	}
+}
+
+func TestHandler(t *testing.T) {
+	h := newTestService(t).Handler()
+
+	tests := []struct {
+		path   string
+		body   string
+		status int
+		want   string
+	}{
+		{"/v1/check", `{"key":"alice","policy":"login"}`, http.StatusOK, `"allowed":true`},
+		{"/v1/check", `{"key":"alice","policy":"login"}`, http.StatusOK, `"allowed":false`},
+		{"/v1/check", `{"key":"alice","policy":"missing"}`, http.StatusNotFound, "unknown policy"},
+		{"/v1/check", `{"key":""}`, http.StatusBadRequest, "key must not be empty"},
+		{"/v1/check", `{"key":"alice","limit":1}`, http.StatusBadRequest, "unknown field"},
+		{"/v1/check/batch", `{"checks":[{"key":"bob"},{"key":"bob","cost":-1}]}`, http.StatusOK, `"error":"invalid check: cost must not be negative"`},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+
+		if rr.Code != tt.status {
+			t.Errorf("POST %s %s: expected status %d, got %d", tt.path, tt.body, tt.status, rr.Code)
+		}
+		if !strings.Contains(rr.Body.String(), tt.want) {
+			t.Errorf("POST %s %s: expected body to contain %q, got %q", tt.path, tt.body, tt.want, rr.Body.String())
+		}
+	}
+}
+
+func TestHandler_ResultFields(t *testing.T) {
+	h := newTestService(t).Handler()
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/check", strings.NewReader(`{"key":"alice"}`))
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+
+	var result Result
+	if err := json.Unmarshal(rr.Body.Bytes(), &result); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if result.Bucket != "default/alice" || result.BucketSize != 2 || result.Remaining != 1 || result.ResetAt.IsZero() {
+		t.Errorf("Expected the counter.Info fields, got %+v", result)
+	}
+}
+
+// newTestClient serves s over an in-memory connection and returns a generated
+// client for it.
+func newTestClient(t *testing.T, s *Service) decisionpb.DecisionServiceClient {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer()
+	s.Register(srv)
+	go func() { _ = srv.Serve(lis) }()
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return decisionpb.NewDecisionServiceClient(conn)
+}
+
+func TestGRPC(t *testing.T) {
+	client := newTestClient(t, newTestService(t))
+	ctx := context.Background()
+
+	resp, err := client.Check(ctx, &decisionpb.CheckRequest{Key: "alice", Policy: "login"})
+	if err != nil {
+		t.Fatalf("Check() unexpected error: %v", err)
+	}
+	if !resp.GetAllowed() || resp.GetBucket() != "login/alice" || resp.GetBucketSize() != 1 || resp.GetResetAt() == nil {
+		t.Errorf("Unexpected response: %v", resp)
+	}
+
+	resp, err = client.Check(ctx, &decisionpb.CheckRequest{Key: "bob", Policy: "login", Cost: 2})
+	if err != nil {
+		t.Fatalf("Check() unexpected error: %v", err)
+	}
+	if resp.GetAllowed() || !resp.GetOversized() {
+		t.Errorf("Expected an oversized rejection, got %v", resp)
+	}
+
+	batch, err := client.CheckBatch(ctx, &decisionpb.CheckBatchRequest{
+		Checks: []*decisionpb.CheckRequest{
+			{Key: "alice", Policy: "login"},
+			{Key: "bob", Policy: "login"},
+			{Key: "bob", Policy: "missing"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("CheckBatch() unexpected error: %v", err)
+	}
+	results := batch.GetResults()
+	if len(results) != 3 {
+		t.Fatalf("Expected 3 results, got %d", len(results))
+	}
+	if results[0].GetAllowed() || !results[1].GetAllowed() || results[2].GetError() == "" {
+		t.Errorf("Unexpected batch results: %v", results)
+	}
+
+	_, err = client.Check(ctx, &decisionpb.CheckRequest{Key: "alice", Policy: "missing"})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("Expected NotFound, got %v", err)
+	}
+	_, err = client.Check(ctx, &decisionpb.CheckRequest{})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument, got %v", err)
+	}
+}
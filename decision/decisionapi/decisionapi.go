@@ -0,0 +1,43 @@
+// Package decisionapi holds the request and response types of the decision
+// API. It is shared by the decision service and its client, and imports as
+// little as possible so that clients do not pull in the service.
+package decisionapi
+
+import "strongdm/counter"
+
+// MaxBatch is the largest number of checks accepted in a single batch.
+const MaxBatch = 1000
+
+// AdHocPrefix namespaces the bucket keys of ad hoc checks. Keys are escaped
+// after it, as by keys.Bucket, so no client key can name an ad hoc bucket. No
+// policy or route may be named after it.
+const AdHocPrefix = "adhoc/"
+
+// Check asks whether Key may spend Cost tokens.
+type Check struct {
+	Key string `json:"key"`
+
+	// Cost is the number of tokens to spend. Zero means 1.
+	Cost int64 `json:"cost,omitempty"`
+
+	// Policy names the policy to check under. If empty, LimitPerWindow is
+	// used instead, or the default policy if that is also zero.
+	Policy string `json:"policy,omitempty"`
+
+	// LimitPerWindow is an ad hoc limit used when no policy is named. Ad hoc
+	// checks have their own buckets, under AdHocPrefix, so they cannot spend
+	// from the buckets of clients limited by the HTTP handler or by policies.
+	LimitPerWindow int64 `json:"limitPerWindow,omitempty"`
+}
+
+// Result is the outcome of a Check.
+type Result struct {
+	counter.Info
+
+	// Policy is the policy the check was made under. It is empty for ad hoc
+	// limits.
+	Policy string `json:"policy,omitempty"`
+
+	// Error explains why a check in a batch could not be made.
+	Error string `json:"error,omitempty"`
+}
@@ -0,0 +1,496 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        (unknown)
+// source: decision/decisionpb/decision.proto
+
+package decisionpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type CheckRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// The bucket key, such as a user or API key.
+	Key string `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	// The number of tokens to spend. Defaults to 1.
+	Cost int64 `protobuf:"varint,2,opt,name=cost,proto3" json:"cost,omitempty"`
+	// The name of a configured policy. If empty, limit_per_window is used, or
+	// the default policy if that is also unset.
+	Policy string `protobuf:"bytes,3,opt,name=policy,proto3" json:"policy,omitempty"`
+	// An ad hoc limit in calls per minute, used when no policy is named.
+	LimitPerWindow int64 `protobuf:"varint,4,opt,name=limit_per_window,json=limitPerWindow,proto3" json:"limit_per_window,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *CheckRequest) Reset() {
+	*x = CheckRequest{}
+	mi := &file_decision_decisionpb_decision_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CheckRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CheckRequest) ProtoMessage() {}
+
+func (x *CheckRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_decision_decisionpb_decision_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CheckRequest.ProtoReflect.Descriptor instead.
+func (*CheckRequest) Descriptor() ([]byte, []int) {
+	return file_decision_decisionpb_decision_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *CheckRequest) GetKey() string {
+	if x != nil {
+		return x.Key
+	}
+	return ""
+}
+
+func (x *CheckRequest) GetCost() int64 {
+	if x != nil {
+		return x.Cost
+	}
+	return 0
+}
+
+func (x *CheckRequest) GetPolicy() string {
+	if x != nil {
+		return x.Policy
+	}
+	return ""
+}
+
+func (x *CheckRequest) GetLimitPerWindow() int64 {
+	if x != nil {
+		return x.LimitPerWindow
+	}
+	return 0
+}
+
+// CheckResponse mirrors counter.Info.
+type CheckResponse struct {
+	state      protoimpl.MessageState `protogen:"open.v1"`
+	Bucket     string                 `protobuf:"bytes,1,opt,name=bucket,proto3" json:"bucket,omitempty"`
+	ResetAt    *timestamppb.Timestamp `protobuf:"bytes,2,opt,name=reset_at,json=resetAt,proto3" json:"reset_at,omitempty"`
+	BucketSize int64                  `protobuf:"varint,3,opt,name=bucket_size,json=bucketSize,proto3" json:"bucket_size,omitempty"`
+	Remaining  int64                  `protobuf:"varint,4,opt,name=remaining,proto3" json:"remaining,omitempty"`
+	Allowed    bool                   `protobuf:"varint,5,opt,name=allowed,proto3" json:"allowed,omitempty"`
+	// The policy the check was made under.
+	Policy string `protobuf:"bytes,6,opt,name=policy,proto3" json:"policy,omitempty"`
+	// Set when the cost exceeds bucket_size, so the check can never be
+	// allowed however long the caller waits.
+	Oversized bool `protobuf:"varint,8,opt,name=oversized,proto3" json:"oversized,omitempty"`
+	// The policy schedule that set the limit, if any.
+	Schedule string `protobuf:"bytes,9,opt,name=schedule,proto3" json:"schedule,omitempty"`
+	// Usage of the policy's calendar quota, if it has one.
+	Quota *QuotaUsage `protobuf:"bytes,10,opt,name=quota,proto3" json:"quota,omitempty"`
+	// Set when the check could not be made, for example because the policy
+	// does not exist. Only used in batch responses.
+	Error         string `protobuf:"bytes,7,opt,name=error,proto3" json:"error,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CheckResponse) Reset() {
+	*x = CheckResponse{}
+	mi := &file_decision_decisionpb_decision_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CheckResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CheckResponse) ProtoMessage() {}
+
+func (x *CheckResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_decision_decisionpb_decision_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CheckResponse.ProtoReflect.Descriptor instead.
+func (*CheckResponse) Descriptor() ([]byte, []int) {
+	return file_decision_decisionpb_decision_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *CheckResponse) GetBucket() string {
+	if x != nil {
+		return x.Bucket
+	}
+	return ""
+}
+
+func (x *CheckResponse) GetResetAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.ResetAt
+	}
+	return nil
+}
+
+func (x *CheckResponse) GetBucketSize() int64 {
+	if x != nil {
+		return x.BucketSize
+	}
+	return 0
+}
+
+func (x *CheckResponse) GetRemaining() int64 {
+	if x != nil {
+		return x.Remaining
+	}
+	return 0
+}
+
+func (x *CheckResponse) GetAllowed() bool {
+	if x != nil {
+		return x.Allowed
+	}
+	return false
+}
+
+func (x *CheckResponse) GetPolicy() string {
+	if x != nil {
+		return x.Policy
+	}
+	return ""
+}
+
+func (x *CheckResponse) GetOversized() bool {
+	if x != nil {
+		return x.Oversized
+	}
+	return false
+}
+
+func (x *CheckResponse) GetSchedule() string {
+	if x != nil {
+		return x.Schedule
+	}
+	return ""
+}
+
+func (x *CheckResponse) GetQuota() *QuotaUsage {
+	if x != nil {
+		return x.Quota
+	}
+	return nil
+}
+
+func (x *CheckResponse) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+// QuotaUsage mirrors quota.Usage.
+type QuotaUsage struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Period        string                 `protobuf:"bytes,1,opt,name=period,proto3" json:"period,omitempty"`
+	Limit         int64                  `protobuf:"varint,2,opt,name=limit,proto3" json:"limit,omitempty"`
+	Used          int64                  `protobuf:"varint,3,opt,name=used,proto3" json:"used,omitempty"`
+	Remaining     int64                  `protobuf:"varint,4,opt,name=remaining,proto3" json:"remaining,omitempty"`
+	ResetAt       *timestamppb.Timestamp `protobuf:"bytes,5,opt,name=reset_at,json=resetAt,proto3" json:"reset_at,omitempty"`
+	Allowed       bool                   `protobuf:"varint,6,opt,name=allowed,proto3" json:"allowed,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *QuotaUsage) Reset() {
+	*x = QuotaUsage{}
+	mi := &file_decision_decisionpb_decision_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *QuotaUsage) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*QuotaUsage) ProtoMessage() {}
+
+func (x *QuotaUsage) ProtoReflect() protoreflect.Message {
+	mi := &file_decision_decisionpb_decision_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use QuotaUsage.ProtoReflect.Descriptor instead.
+func (*QuotaUsage) Descriptor() ([]byte, []int) {
+	return file_decision_decisionpb_decision_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *QuotaUsage) GetPeriod() string {
+	if x != nil {
+		return x.Period
+	}
+	return ""
+}
+
+func (x *QuotaUsage) GetLimit() int64 {
+	if x != nil {
+		return x.Limit
+	}
+	return 0
+}
+
+func (x *QuotaUsage) GetUsed() int64 {
+	if x != nil {
+		return x.Used
+	}
+	return 0
+}
+
+func (x *QuotaUsage) GetRemaining() int64 {
+	if x != nil {
+		return x.Remaining
+	}
+	return 0
+}
+
+func (x *QuotaUsage) GetResetAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.ResetAt
+	}
+	return nil
+}
+
+func (x *QuotaUsage) GetAllowed() bool {
+	if x != nil {
+		return x.Allowed
+	}
+	return false
+}
+
+type CheckBatchRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Checks        []*CheckRequest        `protobuf:"bytes,1,rep,name=checks,proto3" json:"checks,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CheckBatchRequest) Reset() {
+	*x = CheckBatchRequest{}
+	mi := &file_decision_decisionpb_decision_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CheckBatchRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CheckBatchRequest) ProtoMessage() {}
+
+func (x *CheckBatchRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_decision_decisionpb_decision_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CheckBatchRequest.ProtoReflect.Descriptor instead.
+func (*CheckBatchRequest) Descriptor() ([]byte, []int) {
+	return file_decision_decisionpb_decision_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *CheckBatchRequest) GetChecks() []*CheckRequest {
+	if x != nil {
+		return x.Checks
+	}
+	return nil
+}
+
+type CheckBatchResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// One result per check, in request order.
+	Results       []*CheckResponse `protobuf:"bytes,1,rep,name=results,proto3" json:"results,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CheckBatchResponse) Reset() {
+	*x = CheckBatchResponse{}
+	mi := &file_decision_decisionpb_decision_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CheckBatchResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CheckBatchResponse) ProtoMessage() {}
+
+func (x *CheckBatchResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_decision_decisionpb_decision_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CheckBatchResponse.ProtoReflect.Descriptor instead.
+func (*CheckBatchResponse) Descriptor() ([]byte, []int) {
+	return file_decision_decisionpb_decision_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *CheckBatchResponse) GetResults() []*CheckResponse {
+	if x != nil {
+		return x.Results
+	}
+	return nil
+}
+
+var File_decision_decisionpb_decision_proto protoreflect.FileDescriptor
+
+const file_decision_decisionpb_decision_proto_rawDesc = "" +
+	"\n" +
+	"\"decision/decisionpb/decision.proto\x12\x14strongdm.decision.v1\x1a\x1fgoogle/protobuf/timestamp.proto\"v\n" +
+	"\fCheckRequest\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x12\n" +
+	"\x04cost\x18\x02 \x01(\x03R\x04cost\x12\x16\n" +
+	"\x06policy\x18\x03 \x01(\tR\x06policy\x12(\n" +
+	"\x10limit_per_window\x18\x04 \x01(\x03R\x0elimitPerWindow\"\xd7\x02\n" +
+	"\rCheckResponse\x12\x16\n" +
+	"\x06bucket\x18\x01 \x01(\tR\x06bucket\x125\n" +
+	"\breset_at\x18\x02 \x01(\v2\x1a.google.protobuf.TimestampR\aresetAt\x12\x1f\n" +
+	"\vbucket_size\x18\x03 \x01(\x03R\n" +
+	"bucketSize\x12\x1c\n" +
+	"\tremaining\x18\x04 \x01(\x03R\tremaining\x12\x18\n" +
+	"\aallowed\x18\x05 \x01(\bR\aallowed\x12\x16\n" +
+	"\x06policy\x18\x06 \x01(\tR\x06policy\x12\x1c\n" +
+	"\toversized\x18\b \x01(\bR\toversized\x12\x1a\n" +
+	"\bschedule\x18\t \x01(\tR\bschedule\x126\n" +
+	"\x05quota\x18\n" +
+	" \x01(\v2 .strongdm.decision.v1.QuotaUsageR\x05quota\x12\x14\n" +
+	"\x05error\x18\a \x01(\tR\x05error\"\xbd\x01\n" +
+	"\n" +
+	"QuotaUsage\x12\x16\n" +
+	"\x06period\x18\x01 \x01(\tR\x06period\x12\x14\n" +
+	"\x05limit\x18\x02 \x01(\x03R\x05limit\x12\x12\n" +
+	"\x04used\x18\x03 \x01(\x03R\x04used\x12\x1c\n" +
+	"\tremaining\x18\x04 \x01(\x03R\tremaining\x125\n" +
+	"\breset_at\x18\x05 \x01(\v2\x1a.google.protobuf.TimestampR\aresetAt\x12\x18\n" +
+	"\aallowed\x18\x06 \x01(\bR\aallowed\"O\n" +
+	"\x11CheckBatchRequest\x12:\n" +
+	"\x06checks\x18\x01 \x03(\v2\".strongdm.decision.v1.CheckRequestR\x06checks\"S\n" +
+	"\x12CheckBatchResponse\x12=\n" +
+	"\aresults\x18\x01 \x03(\v2#.strongdm.decision.v1.CheckResponseR\aresults2\xc4\x01\n" +
+	"\x0fDecisionService\x12P\n" +
+	"\x05Check\x12\".strongdm.decision.v1.CheckRequest\x1a#.strongdm.decision.v1.CheckResponse\x12_\n" +
+	"\n" +
+	"CheckBatch\x12'.strongdm.decision.v1.CheckBatchRequest\x1a(.strongdm.decision.v1.CheckBatchResponseB\x1eZ\x1cstrongdm/decision/decisionpbb\x06proto3"
+
+var (
+	file_decision_decisionpb_decision_proto_rawDescOnce sync.Once
+	file_decision_decisionpb_decision_proto_rawDescData []byte
+)
+
+func file_decision_decisionpb_decision_proto_rawDescGZIP() []byte {
+	file_decision_decisionpb_decision_proto_rawDescOnce.Do(func() {
+		file_decision_decisionpb_decision_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_decision_decisionpb_decision_proto_rawDesc), len(file_decision_decisionpb_decision_proto_rawDesc)))
+	})
+	return file_decision_decisionpb_decision_proto_rawDescData
+}
+
+var file_decision_decisionpb_decision_proto_msgTypes = make([]protoimpl.MessageInfo, 5)
+var file_decision_decisionpb_decision_proto_goTypes = []any{
+	(*CheckRequest)(nil),          // 0: strongdm.decision.v1.CheckRequest
+	(*CheckResponse)(nil),         // 1: strongdm.decision.v1.CheckResponse
+	(*QuotaUsage)(nil),            // 2: strongdm.decision.v1.QuotaUsage
+	(*CheckBatchRequest)(nil),     // 3: strongdm.decision.v1.CheckBatchRequest
+	(*CheckBatchResponse)(nil),    // 4: strongdm.decision.v1.CheckBatchResponse
+	(*timestamppb.Timestamp)(nil), // 5: google.protobuf.Timestamp
+}
+var file_decision_decisionpb_decision_proto_depIdxs = []int32{
+	5, // 0: strongdm.decision.v1.CheckResponse.reset_at:type_name -> google.protobuf.Timestamp
+	2, // 1: strongdm.decision.v1.CheckResponse.quota:type_name -> strongdm.decision.v1.QuotaUsage
+	5, // 2: strongdm.decision.v1.QuotaUsage.reset_at:type_name -> google.protobuf.Timestamp
+	0, // 3: strongdm.decision.v1.CheckBatchRequest.checks:type_name -> strongdm.decision.v1.CheckRequest
+	1, // 4: strongdm.decision.v1.CheckBatchResponse.results:type_name -> strongdm.decision.v1.CheckResponse
+	0, // 5: strongdm.decision.v1.DecisionService.Check:input_type -> strongdm.decision.v1.CheckRequest
+	3, // 6: strongdm.decision.v1.DecisionService.CheckBatch:input_type -> strongdm.decision.v1.CheckBatchRequest
+	1, // 7: strongdm.decision.v1.DecisionService.Check:output_type -> strongdm.decision.v1.CheckResponse
+	4, // 8: strongdm.decision.v1.DecisionService.CheckBatch:output_type -> strongdm.decision.v1.CheckBatchResponse
+	7, // [7:9] is the sub-list for method output_type
+	5, // [5:7] is the sub-list for method input_type
+	5, // [5:5] is the sub-list for extension type_name
+	5, // [5:5] is the sub-list for extension extendee
+	0, // [0:5] is the sub-list for field type_name
+}
+
+func init() { file_decision_decisionpb_decision_proto_init() }
+func file_decision_decisionpb_decision_proto_init() {
+	if File_decision_decisionpb_decision_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_decision_decisionpb_decision_proto_rawDesc), len(file_decision_decisionpb_decision_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   5,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_decision_decisionpb_decision_proto_goTypes,
+		DependencyIndexes: file_decision_decisionpb_decision_proto_depIdxs,
+		MessageInfos:      file_decision_decisionpb_decision_proto_msgTypes,
+	}.Build()
+	File_decision_decisionpb_decision_proto = out.File
+	file_decision_decisionpb_decision_proto_goTypes = nil
+	file_decision_decisionpb_decision_proto_depIdxs = nil
+}
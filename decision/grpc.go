@@ -0,0 +1,95 @@
+package decision
+
+import (
+	"context"
+	"errors"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"strongdm/decision/decisionpb"
+)
+
+// Register registers the gRPC DecisionService defined in
+// decisionpb/decision.proto with r.
+func (s *Service) Register(r grpc.ServiceRegistrar) {
+	decisionpb.RegisterDecisionServiceServer(r, grpcServer{s: s})
+}
+
+// grpcServer adapts Service to decisionpb.DecisionServiceServer.
+type grpcServer struct {
+	decisionpb.UnimplementedDecisionServiceServer
+
+	s *Service
+}
+
+func (g grpcServer) Check(ctx context.Context, req *decisionpb.CheckRequest) (*decisionpb.CheckResponse, error) {
+	result, err := g.s.Check(ctx, checkFromProto(req))
+	if err != nil {
+		return nil, status.Error(grpcCode(err), err.Error())
+	}
+	return resultToProto(result), nil
+}
+
+func (g grpcServer) CheckBatch(ctx context.Context, req *decisionpb.CheckBatchRequest) (*decisionpb.CheckBatchResponse, error) {
+	checks := make([]Check, len(req.GetChecks()))
+	for i, c := range req.GetChecks() {
+		checks[i] = checkFromProto(c)
+	}
+	results, err := g.s.CheckBatch(ctx, checks)
+	if err != nil {
+		return nil, status.Error(grpcCode(err), err.Error())
+	}
+	resp := &decisionpb.CheckBatchResponse{
+		Results: make([]*decisionpb.CheckResponse, len(results)),
+	}
+	for i, r := range results {
+		resp.Results[i] = resultToProto(r)
+	}
+	return resp, nil
+}
+
+func checkFromProto(req *decisionpb.CheckRequest) Check {
+	return Check{
+		Key:            req.GetKey(),
+		Cost:           req.GetCost(),
+		Policy:         req.GetPolicy(),
+		LimitPerWindow: req.GetLimitPerWindow(),
+	}
+}
+
+func resultToProto(r Result) *decisionpb.CheckResponse {
+	resp := &decisionpb.CheckResponse{
+		Bucket:     r.Bucket,
+		BucketSize: r.BucketSize,
+		Remaining:  r.Remaining,
+		Allowed:    r.Allowed,
+		Oversized:  r.Oversized,
+		Schedule:   r.Schedule,
+		Policy:     r.Policy,
+		Error:      r.Error,
+	}
+	if !r.ResetAt.IsZero() {
+		resp.ResetAt = timestamppb.New(r.ResetAt)
+	}
+	if r.Quota != nil {
+		resp.Quota = &decisionpb.QuotaUsage{
+			Period:    r.Quota.Period,
+			Limit:     r.Quota.Limit,
+			Used:      r.Quota.Used,
+			Remaining: r.Quota.Remaining,
+			ResetAt:   timestamppb.New(r.Quota.ResetAt),
+			Allowed:   r.Quota.Allowed,
+		}
+	}
+	return resp
+}
+
+func grpcCode(err error) codes.Code {
+	if errors.Is(err, ErrUnknownPolicy) {
+		return codes.NotFound
+	}
+	return codes.InvalidArgument
+}
@@ -0,0 +1,81 @@
+package decision
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// maxBodyBytes bounds the size of a request body, which is enough for a
+// full batch of checks with long keys.
+const maxBodyBytes = 1 << 20
+
+// batchRequest is the body of a batch check.
+type batchRequest struct {
+	Checks []Check `json:"checks"`
+}
+
+// batchResponse is the response to a batch check.
+type batchResponse struct {
+	Results []Result `json:"results"`
+}
+
+// Handler serves the JSON API:
+//
+//	POST /v1/check        charges the Check in the body and returns a Result
+//	POST /v1/check/batch  charges {"checks": [...]} and returns {"results": [...]}
+//
+// A check that was made is answered with 200 whether or not it was allowed;
+// callers read Allowed from the body.
+func (s *Service) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/check", func(w http.ResponseWriter, r *http.Request) {
+		var check Check
+		if !decodeBody(w, r, &check) {
+			return
+		}
+		result, err := s.Check(r.Context(), check)
+		if err != nil {
+			http.Error(w, err.Error(), httpStatus(err))
+			return
+		}
+		writeJSON(w, result)
+	})
+	mux.HandleFunc("POST /v1/check/batch", func(w http.ResponseWriter, r *http.Request) {
+		var batch batchRequest
+		if !decodeBody(w, r, &batch) {
+			return
+		}
+		results, err := s.CheckBatch(r.Context(), batch.Checks)
+		if err != nil {
+			http.Error(w, err.Error(), httpStatus(err))
+			return
+		}
+		writeJSON(w, batchResponse{Results: results})
+	})
+	return mux
+}
+
+// decodeBody decodes the JSON request body into v, writing a 400 response
+// and returning false if it is malformed.
+func decodeBody(w http.ResponseWriter, r *http.Request, v any) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func httpStatus(err error) int {
+	if errors.Is(err, ErrUnknownPolicy) {
+		return http.StatusNotFound
+	}
+	return http.StatusBadRequest
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -2,22 +2,258 @@ package handler
 
 import (
 	"encoding/json"
-	"net"
+	"log/slog"
+	"math"
+	"math/rand/v2"
 	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
+	"strongdm/access"
+	"strongdm/adaptive"
+	"strongdm/ban"
+	"strongdm/cost"
 	"strongdm/counter"
+	"strongdm/internal/status"
+	"strongdm/keys"
+	"strongdm/metering"
+	"strongdm/metrics"
+	"strongdm/notify"
+	"strongdm/policy"
+	"strongdm/quota"
+	"strongdm/routes"
+	"strongdm/shed"
 )
 
 // Handler holds the rate limiting counter and provides HTTP request handling
 type Handler struct {
 	counter *counter.Counter
+	policy  policy.Policy
+	key     keys.Func
+	access  *access.List
+	// addr returns the client address that CIDR access rules are matched
+	// against.
+	addr     keys.Func
+	bans     *ban.Box
+	denyBody string
+
+	// routes selects a policy other than policy for matching requests.
+	routes *routes.Table
+
+	// cost is the number of tokens a request spends, unless its route sets
+	// its own.
+	cost cost.Func
+	// postChargeEvery, if positive, charges an extra token for each
+	// postChargeEvery the wrapped handler took to respond.
+	postChargeEvery time.Duration
+
+	// shadow is evaluated alongside policy using its own counter, and never
+	// affects the response.
+	shadow        *policy.Policy
+	shadowCounter *counter.Counter
+
+	// adaptive, if set, limits the number of requests in flight to the
+	// handler wrapped by Middleware.
+	adaptive *adaptive.Limiter
+
+	// shedder, if set, sheds low priority requests to the handler wrapped
+	// by Middleware when the service is overloaded.
+	shedder *shed.Shedder
+
+	// quotas, if set, tracks usage of policies' calendar quotas.
+	quotas *quota.Store
+
+	// meter, if set, records usage per key for billing.
+	meter *metering.Meter
+
+	// notifier, if set, is told about rejections, bans and quota usage so it
+	// can send events when keys change state.
+	notifier *notify.Notifier
+
+	// queued counts the requests per bucket currently held by a policy's
+	// MaxDelay.
+	queueMu sync.Mutex
+	queued  map[string]int
+
+	logger *slog.Logger
+	// sampleAllowed is the fraction of allowed decisions that are logged.
+	sampleAllowed float64
+}
+
+// Option configures optional Handler behavior.
+type Option func(*Handler)
+
+// WithCounter sets the counter that enforced decisions are made with. The
+// default is a new, empty counter.
+func WithCounter(c *counter.Counter) Option {
+	return func(h *Handler) {
+		h.counter = c
+	}
+}
+
+// WithKeyFunc sets how the bucket key is derived from a request. The default
+// is keys.RemoteIP.
+func WithKeyFunc(fn keys.Func) Option {
+	return func(h *Handler) {
+		h.key = fn
+	}
+}
+
+// WithAddrFunc sets how the client address that CIDR access rules match is
+// derived from a request. It must not trust anything the client controls
+// unless a proxy in front overwrites it. The default is keys.RemoteIP.
+func WithAddrFunc(fn keys.Func) Option {
+	return func(h *Handler) {
+		h.addr = fn
+	}
+}
+
+// WithPolicy sets the policy applied to requests. The default is
+// policy.Default().
+func WithPolicy(p policy.Policy) Option {
+	return func(h *Handler) {
+		h.policy = p
+	}
+}
+
+// WithShadowPolicy evaluates an additional policy for every request, logging
+// and counting its decisions without enforcing them. It is used to compare a
+// candidate policy against the enforced one.
+func WithShadowPolicy(p policy.Policy) Option {
+	return func(h *Handler) {
+		h.shadow = &p
+		h.shadowCounter = counter.New()
+	}
+}
+
+// WithRoutes applies the policy of the matching route, if any, to each
+// request instead of the default policy. Each route has its own buckets.
+func WithRoutes(t *routes.Table) Option {
+	return func(h *Handler) {
+		h.routes = t
+	}
+}
+
+// WithCostFunc sets the number of tokens each request spends. The default is
+// one per request.
+func WithCostFunc(fn cost.Func) Option {
+	return func(h *Handler) {
+		h.cost = fn
+	}
+}
+
+// WithPostCharge charges an extra token to the request's bucket for every
+// full interval the wrapped handler took to respond, so that slow, expensive
+// requests are paid for by delaying the key's later ones. It only applies to
+// Middleware.
+func WithPostCharge(every time.Duration) Option {
+	return func(h *Handler) {
+		h.postChargeEvery = every
+	}
+}
+
+// WithAdaptiveLimit limits the number of requests in flight to the handler
+// wrapped by Middleware, turning away requests beyond the limit with 503. The
+// limit adapts to the latency and 5xx responses of the wrapped handler.
+func WithAdaptiveLimit(l *adaptive.Limiter) Option {
+	return func(h *Handler) {
+		h.adaptive = l
+	}
+}
+
+// WithLoadShedding turns away requests to the handler wrapped by Middleware
+// with 503 when s sheds their priority tier. Shedding happens after the rate
+// limit and before the adaptive concurrency limit.
+func WithLoadShedding(s *shed.Shedder) Option {
+	return func(h *Handler) {
+		h.shedder = s
+	}
+}
+
+// WithQuotas enforces policies' calendar quotas, tracking usage in s.
+// Policies with a quota are not limited by it without a store.
+func WithQuotas(s *quota.Store) Option {
+	return func(h *Handler) {
+		h.quotas = s
+	}
+}
+
+// WithMeter counts each request's decision per key and policy in m. Requests
+// that go through count as allowed, and all others as rejected.
+func WithMeter(m *metering.Meter) Option {
+	return func(h *Handler) {
+		h.meter = m
+	}
+}
+
+// WithNotifier reports rate limit rejections, bans and quota usage to n,
+// which sends events when keys change state.
+func WithNotifier(n *notify.Notifier) Option {
+	return func(h *Handler) {
+		h.notifier = n
+	}
+}
+
+// WithAccessList consults the given allow and deny lists before rate limiting.
+func WithAccessList(l *access.List) Option {
+	return func(h *Handler) {
+		h.access = l
+	}
+}
+
+// WithBans rejects keys banned by the given penalty box before they reach the
+// counter, and reports rate limit rejections to it.
+func WithBans(b *ban.Box) Option {
+	return func(h *Handler) {
+		h.bans = b
+	}
+}
+
+// WithDenyBody sets the response body sent to denylisted clients. The default
+// is access.DefaultDenyBody.
+func WithDenyBody(body string) Option {
+	return func(h *Handler) {
+		h.denyBody = body
+	}
+}
+
+// WithLogger sets the logger that decisions are written to. The default is
+// slog.Default().
+func WithLogger(logger *slog.Logger) Option {
+	return func(h *Handler) {
+		h.logger = logger
+	}
+}
+
+// WithAllowedSampleRate logs only the given fraction, between 0 and 1, of
+// allowed decisions. Rejections are always logged.
+func WithAllowedSampleRate(rate float64) Option {
+	return func(h *Handler) {
+		h.sampleAllowed = rate
+	}
 }
 
 // New creates a new HTTP handler with rate limiting
-func New() *Handler {
-	return &Handler{
-		counter: counter.New(),
+func New(opts ...Option) *Handler {
+	h := &Handler{
+		counter:       counter.New(),
+		policy:        policy.Default(),
+		key:           keys.RemoteIP,
+		addr:          keys.RemoteIP,
+		cost:          cost.One,
+		queued:        map[string]int{},
+		denyBody:      access.DefaultDenyBody,
+		logger:        slog.Default(),
+		sampleAllowed: 1,
+	}
+	for _, opt := range opts {
+		opt(h)
 	}
+	return h
 }
 
 // HandleRequest processes HTTP requests with rate limiting
@@ -27,22 +263,507 @@ func (h *Handler) HandleRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	remoteHost, _, err := net.SplitHostPort(r.RemoteAddr)
-	if err != nil {
-		remoteHost = r.RemoteAddr
+	h.serve(w, r, nil)
+}
+
+// Middleware rate limits requests to next. Allowed requests of any method are
+// passed to next with the rate limit headers set, and rejected requests get
+// the same response as from HandleRequest.
+func (h *Handler) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.serve(w, r, next)
+	})
+}
+
+// HandleAuth is a forward-auth decision endpoint for proxies such as nginx
+// (auth_request), Traefik and Caddy (forward_auth). The original request is
+// reconstructed from the forwarded headers, rate limited, and answered with an
+// empty 200, 403 or 429 carrying the rate limit headers. The forwarded headers
+// are trusted, so the endpoint must only be reachable by the proxy.
+func (h *Handler) HandleAuth(w http.ResponseWriter, r *http.Request) {
+	d := h.decide(originalRequest(r), false)
+
+	setLimitHeaders(w.Header(), d.info)
+	switch d.outcome {
+	case metrics.OutcomeDenylisted:
+		w.WriteHeader(http.StatusForbidden)
+	case metrics.OutcomeAllowed, metrics.OutcomeDelayed, metrics.OutcomeAllowlisted, metrics.OutcomeWouldReject:
+		w.WriteHeader(http.StatusOK)
+	case metrics.OutcomeOversized:
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+	default:
+		w.WriteHeader(http.StatusTooManyRequests)
+	}
+}
+
+// originalRequest reconstructs the request a proxy is asking about from the
+// headers it forwards with the auth subrequest.
+func originalRequest(r *http.Request) *http.Request {
+	orig := r.Clone(r.Context())
+
+	if method := firstHeader(r.Header, "X-Forwarded-Method", "X-Original-Method"); method != "" {
+		orig.Method = method
+	}
+	if uri := firstHeader(r.Header, "X-Forwarded-Uri", "X-Original-URI"); uri != "" {
+		if u, err := url.ParseRequestURI(uri); err == nil {
+			orig.URL = u
+			orig.RequestURI = uri
+		}
+	}
+	if host := r.Header.Get("X-Forwarded-Host"); host != "" {
+		orig.Host = host
 	}
 
-	const limitPerMinute = 120
+	// Proxies append the address they received the request from to
+	// X-Forwarded-For, so only the last entry is theirs; the ones before it
+	// are whatever the client sent. X-Real-IP is set by the proxy alone.
+	client := r.Header.Get("X-Real-Ip")
+	if xffs := r.Header.Values("X-Forwarded-For"); client == "" && len(xffs) > 0 {
+		xff := xffs[len(xffs)-1]
+		client = strings.TrimSpace(xff[strings.LastIndex(xff, ",")+1:])
+	}
+	if client != "" {
+		orig.RemoteAddr = client
+	}
+	return orig
+}
 
-	info := h.counter.Add(remoteHost, limitPerMinute, 1)
+// firstHeader returns the value of the first of names present in header.
+func firstHeader(header http.Header, names ...string) string {
+	for _, name := range names {
+		if v := header.Get(name); v != "" {
+			return v
+		}
+	}
+	return ""
+}
 
-	w.Header().Set("Content-Type", "application/json")
+// serve decides whether r is allowed and responds accordingly. Allowed
+// requests are passed to next, or answered with the rate limit info if next is
+// nil.
+func (h *Handler) serve(w http.ResponseWriter, r *http.Request, next http.Handler) {
+	d := h.decide(r, next != nil)
 
-	if info.Allowed {
-		w.WriteHeader(http.StatusOK)
+	switch d.outcome {
+	case metrics.OutcomeDenylisted:
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(h.denyBody))
+	case metrics.OutcomeAllowed, metrics.OutcomeDelayed, metrics.OutcomeAllowlisted, metrics.OutcomeWouldReject:
+		// In observe mode the headers report the real limit state, but the
+		// request goes through.
+		setLimitHeaders(w.Header(), d.info)
+		if next != nil {
+			start := time.Now()
+			if d.shedToken != nil {
+				defer d.shedToken.Done()
+			}
+			if d.token != nil {
+				rec := &status.Recorder{ResponseWriter: w}
+				defer func() { d.token.Done(rec.Code >= http.StatusInternalServerError) }()
+				w = rec
+			}
+			next.ServeHTTP(w, r)
+			h.postCharge(d, time.Since(start))
+			return
+		}
+		info := d.info
+		info.Allowed = true
+		writeBody(w, info)
+	case metrics.OutcomeOverloaded, metrics.OutcomeShed:
+		setLimitHeaders(w.Header(), d.info)
+		w.Header().Set("Retry-After", "1")
+		writeJSON(w, http.StatusServiceUnavailable, d.info)
+	case metrics.OutcomeOversized:
+		// Waiting will not help, so this is not reported as a 429.
+		setLimitHeaders(w.Header(), d.info)
+		writeJSON(w, http.StatusRequestEntityTooLarge, d.info)
+	default:
+		writeInfo(w, d.info)
+	}
+}
+
+// postCharge charges the bucket of an allowed request for the time taken to
+// serve it.
+func (h *Handler) postCharge(d decision, elapsed time.Duration) {
+	if h.postChargeEvery <= 0 || d.info.BucketSize == 0 || d.outcome == metrics.OutcomeAllowlisted {
+		return
+	}
+	if extra := int64(elapsed / h.postChargeEvery); extra > 0 {
+		h.counter.Charge(d.info.Bucket, d.policy.LimitPerWindow, extra)
+	}
+}
+
+// decision is the result of applying the handler's checks to a request.
+type decision struct {
+	// outcome is one of the metrics.Outcome constants.
+	outcome string
+	// info is the rate limit state of the request's bucket.
+	info counter.Info
+	// policy is the policy the request was checked against.
+	policy policy.Policy
+	// token is the adaptive concurrency slot held by an admitted request,
+	// if any.
+	token *adaptive.Token
+	// priority is the load shedding priority of the request, if a load
+	// shedder is configured.
+	priority int
+	// shedToken is held by a request admitted by the load shedder, if any.
+	shedToken *shed.Token
+}
+
+// admitted reports whether the request may go ahead.
+func (d decision) admitted() bool {
+	switch d.outcome {
+	case metrics.OutcomeAllowed, metrics.OutcomeDelayed, metrics.OutcomeAllowlisted, metrics.OutcomeWouldReject:
+		return true
+	}
+	return false
+}
+
+// decide applies the access lists, penalty box and policies to r, and logs
+// the result. If gated, requests that pass are also subject to load shedding
+// and the adaptive concurrency limit.
+func (h *Handler) decide(r *http.Request, gated bool) decision {
+	start := time.Now()
+	d := h.evaluate(r, start)
+	if gated && h.shedder != nil {
+		d = h.shed(r, d)
+	}
+	if gated && h.adaptive != nil {
+		d = h.admit(d)
+	}
+	if h.meter != nil {
+		h.meter.Count(h.key(r), d.policy.Name, d.admitted())
+	}
+	if h.notifier != nil {
+		h.notify(h.key(r), d)
+	}
+	h.logDecision(r, start, d)
+	return d
+}
+
+// notify tells the notifier about the decision for key.
+func (h *Handler) notify(key string, d decision) {
+	if d.outcome == metrics.OutcomeRejected {
+		h.notifier.Rejected(key, d.policy.Name)
+	}
+	if d.info.Quota != nil {
+		h.notifier.QuotaUsed(key, d.policy.Name, *d.info.Quota)
+	}
+}
+
+// admit takes an adaptive concurrency slot for a request that passed the
+// rate limit, or turns it away if none is free.
+func (h *Handler) admit(d decision) decision {
+	d.info.ConcurrencyLimit = h.adaptive.Limit()
+	if !d.admitted() {
+		return d
+	}
+	token, ok := h.adaptive.Acquire()
+	if !ok {
+		if d.shedToken != nil {
+			d.shedToken.Cancel()
+			d.shedToken = nil
+		}
+		d.outcome = metrics.OutcomeOverloaded
+		d.info.Allowed = false
+		return d
+	}
+	d.token = token
+	return d
+}
+
+// shed classifies a request that passed the rate limit and turns it away if
+// its priority tier is being shed.
+func (h *Handler) shed(r *http.Request, d decision) decision {
+	d.priority = h.shedder.Classify(r, h.key(r), d.policy.Name)
+	if !d.admitted() {
+		return d
+	}
+	token, ok := h.shedder.Admit(d.priority)
+	if !ok {
+		d.outcome = metrics.OutcomeShed
+		d.info.Allowed = false
+		return d
+	}
+	d.shedToken = token
+	return d
+}
+
+func (h *Handler) evaluate(r *http.Request, start time.Time) decision {
+	key := h.key(r)
+
+	p, bucketKey, costOf := h.policy, keys.Escape(key), h.cost
+	if h.routes != nil {
+		if route, ok := h.routes.Match(r); ok {
+			p, bucketKey = route.Policy, route.BucketKey(key)
+			if fn := route.CostFunc(); fn != nil {
+				costOf = fn
+			}
+		}
+	}
+
+	if h.access != nil {
+		ip, _ := netip.ParseAddr(h.addr(r))
+		switch h.access.Check(ip, key, r.Header) {
+		case access.Deny:
+			return decision{outcome: metrics.OutcomeDenylisted, info: counter.Info{Bucket: key}, policy: p}
+		case access.Allow:
+			return decision{outcome: metrics.OutcomeAllowlisted, policy: p, info: counter.Info{
+				Bucket:  key,
+				ResetAt: time.Now(),
+				Allowed: true,
+			}}
+		}
+	}
+
+	if h.bans != nil {
+		if b, banned := h.bans.Banned(key); banned {
+			return decision{outcome: metrics.OutcomeBanned, policy: p, info: counter.Info{
+				Bucket:  key,
+				ResetAt: b.Until,
+				Allowed: false,
+			}}
+		}
+	}
+
+	// A schedule change applies from the next Add, which leaks the bucket at
+	// the old limit up to now and at the new one from then on.
+	p, schedule := p.At(start)
+	n := costOf(r)
+	var info counter.Info
+	outcome := metrics.OutcomeAllowed
+	quotaOn := h.quotas != nil && p.Quota.Enabled()
+	var usage quota.Usage
+	if quotaOn {
+		usage = h.quotas.WouldAllow(bucketKey, p.Quota, n)
+	}
+	if quotaOn && !usage.Allowed {
+		// A request the quota refuses does not spend per-minute tokens.
+		info = h.counter.WouldAllow(bucketKey, p.LimitPerWindow, n)
+		info.Allowed = false
+		info.ResetAt = usage.ResetAt
+		info.Quota = &usage
+		outcome = metrics.OutcomeQuotaExceeded
 	} else {
-		w.WriteHeader(http.StatusTooManyRequests)
+		info = h.counter.Add(bucketKey, p.LimitPerWindow, n)
+		if !info.Allowed && !info.Oversized && p.Enforced() {
+			if delayed, ok := h.wait(r, p, bucketKey, n, info, start); ok {
+				info, outcome = delayed, metrics.OutcomeDelayed
+			}
+		}
+		if quotaOn {
+			outcome = h.chargeQuota(&info, bucketKey, p.Quota, n, outcome)
+		}
+	}
+	info.Schedule = schedule
+
+	if h.shadow != nil {
+		h.evaluateShadow(r, start, bucketKey, n, info)
+	}
+
+	switch {
+	case info.Allowed:
+		return decision{outcome: outcome, info: info, policy: p}
+	case !p.Enforced():
+		return decision{outcome: metrics.OutcomeWouldReject, info: info, policy: p}
+	case info.Oversized:
+		// The request can never fit, which says nothing about the client's
+		// behavior, so it does not count towards a ban.
+		return decision{outcome: metrics.OutcomeOversized, info: info, policy: p}
+	case outcome == metrics.OutcomeQuotaExceeded:
+		// Nor does running out of quota, which cannot be caused by sending
+		// too fast.
+		return decision{outcome: outcome, info: info, policy: p}
+	}
+
+	if h.bans != nil {
+		if b, started := h.bans.RecordRejection(key); started {
+			metrics.BansStarted.Add(1)
+			h.logger.Warn("Key banned",
+				slog.String("key", b.Key),
+				slog.Time("until", b.Until),
+				slog.Int("offense", b.Offense),
+				slog.Int("rejections", b.Rejections),
+			)
+			if h.notifier != nil {
+				h.notifier.BanStarted(b)
+			}
+		}
 	}
+	return decision{outcome: metrics.OutcomeRejected, info: info, policy: p}
+}
+
+// chargeQuota charges a request that passed the rate limit to its policy's
+// calendar quota, rejecting it if the quota is used up, and reports the quota
+// usage in info. Requests that did not pass are reported without charge.
+func (h *Handler) chargeQuota(info *counter.Info, key string, q quota.Quota, n int64, outcome string) string {
+	if !info.Allowed {
+		usage := h.quotas.Peek(key, q)
+		info.Quota = &usage
+		return outcome
+	}
+	usage := h.quotas.Add(key, q, n)
+	info.Quota = &usage
+	if usage.Allowed {
+		return outcome
+	}
+	info.Allowed = false
+	info.ResetAt = usage.ResetAt
+	return metrics.OutcomeQuotaExceeded
+}
+
+// wait holds a rejected request under a policy with a MaxDelay until its
+// bucket has room, and charges it then. It gives up, returning false, if the
+// key already has too many requests held, if there will be no room within the
+// delay budget, or if the client goes away.
+func (h *Handler) wait(r *http.Request, p policy.Policy, key string, n int64, info counter.Info, start time.Time) (counter.Info, bool) {
+	maxDelay, maxQueued, ok := p.Queued()
+	if !ok {
+		return info, false
+	}
+	deadline := start.Add(maxDelay)
+	if info.ResetAt.After(deadline) {
+		return info, false
+	}
+
+	h.queueMu.Lock()
+	if h.queued[key] >= maxQueued {
+		h.queueMu.Unlock()
+		return info, false
+	}
+	h.queued[key]++
+	h.queueMu.Unlock()
+	defer func() {
+		h.queueMu.Lock()
+		if h.queued[key]--; h.queued[key] == 0 {
+			delete(h.queued, key)
+		}
+		h.queueMu.Unlock()
+	}()
+
+	// Other held requests may take the room first, so check again after
+	// each wait for as long as the budget allows.
+	for !info.ResetAt.After(deadline) {
+		t := time.NewTimer(time.Until(info.ResetAt))
+		select {
+		case <-t.C:
+		case <-r.Context().Done():
+			t.Stop()
+			return info, false
+		}
+		if info = h.counter.Add(key, p.LimitPerWindow, n); info.Allowed {
+			return info, true
+		}
+	}
+	return info, false
+}
+
+// evaluateShadow applies the shadow policy to the request, charging the same
+// bucket and cost as the enforced policy, and records how its decision
+// compares with the enforced one.
+func (h *Handler) evaluateShadow(r *http.Request, start time.Time, bucketKey string, n int64, enforced counter.Info) {
+	shadow, _ := h.shadow.At(start)
+	info := h.shadowCounter.Add(bucketKey, shadow.LimitPerWindow, n)
+
+	outcome := metrics.OutcomeAllowed
+	if !info.Allowed {
+		outcome = metrics.OutcomeRejected
+	}
+	metrics.ShadowDecisions.Add(outcome, 1)
+	if info.Allowed != enforced.Allowed {
+		metrics.ShadowDecisions.Add(metrics.OutcomeDisagreed, 1)
+	}
+
+	if info.Allowed && h.sampleAllowed < 1 && rand.Float64() >= h.sampleAllowed {
+		return
+	}
+
+	h.logger.LogAttrs(r.Context(), slog.LevelInfo, "Shadow rate limit decision",
+		slog.String("key", info.Bucket),
+		slog.String("policy", h.shadow.Name),
+		slog.String("outcome", outcome),
+		slog.Bool("allowed", info.Allowed),
+		slog.Bool("enforcedAllowed", enforced.Allowed),
+		slog.Int64("remaining", info.Remaining),
+		slog.Time("reset", info.ResetAt),
+		slog.Duration("latency", time.Since(start)),
+		slog.String("method", r.Method),
+		slog.String("path", r.URL.Path),
+	)
+}
+
+// logDecision counts the outcome of a rate limit decision and writes it to the
+// decision log, sampling allowed decisions.
+func (h *Handler) logDecision(r *http.Request, start time.Time, d decision) {
+	metrics.Decisions.Add(d.outcome, 1)
+
+	if d.info.Allowed && h.sampleAllowed < 1 && rand.Float64() >= h.sampleAllowed {
+		return
+	}
+
+	attrs := []slog.Attr{
+		slog.String("key", d.info.Bucket),
+		slog.String("policy", d.policy.Name),
+		slog.String("outcome", d.outcome),
+		slog.Bool("allowed", d.info.Allowed),
+		slog.Int64("remaining", d.info.Remaining),
+		slog.Time("reset", d.info.ResetAt),
+		slog.Duration("latency", time.Since(start)),
+		slog.String("method", r.Method),
+		slog.String("path", r.URL.Path),
+	}
+	if h.shedder != nil {
+		attrs = append(attrs, slog.Int("priority", d.priority))
+	}
+	h.logger.LogAttrs(r.Context(), slog.LevelInfo, "Rate limit decision", attrs...)
+}
+
+// writeInfo writes the rate limit headers and JSON body for info.
+func writeInfo(w http.ResponseWriter, info counter.Info) {
+	setLimitHeaders(w.Header(), info)
+	writeBody(w, info)
+}
+
+// setLimitHeaders sets the standard rate limit and quota response headers
+// from info. Nothing is set for unlimited requests.
+func setLimitHeaders(header http.Header, info counter.Info) {
+	if info.Quota != nil {
+		header.Set("X-Quota-Limit", strconv.FormatInt(info.Quota.Limit, 10))
+		header.Set("X-Quota-Remaining", strconv.FormatInt(info.Quota.Remaining, 10))
+		header.Set("X-Quota-Reset", strconv.FormatInt(info.Quota.ResetAt.Unix(), 10))
+	}
+	if info.BucketSize != 0 {
+		header.Set("X-RateLimit-Limit", strconv.FormatInt(info.BucketSize, 10))
+		header.Set("X-RateLimit-Remaining", strconv.FormatInt(info.Remaining, 10))
+		header.Set("X-RateLimit-Reset", strconv.FormatInt(info.ResetAt.Unix(), 10))
+	}
+	if info.Schedule != "" {
+		header.Set("X-RateLimit-Schedule", info.Schedule)
+	}
+	// Bans have no bucket but do end at ResetAt. Denied keys have neither,
+	// since retrying cannot succeed.
+	if !info.Allowed && !info.Oversized && !info.ResetAt.IsZero() {
+		retryAfter := int64(math.Ceil(time.Until(info.ResetAt).Seconds()))
+		header.Set("Retry-After", strconv.FormatInt(max(0, retryAfter), 10))
+	}
+}
+
+// writeBody writes info as the JSON response body, with a status code
+// reflecting whether the request was allowed.
+func writeBody(w http.ResponseWriter, info counter.Info) {
+	status := http.StatusOK
+	if !info.Allowed {
+		status = http.StatusTooManyRequests
+	}
+	writeJSON(w, status, info)
+}
+
+// writeJSON writes info as the JSON response body with the given status.
+func writeJSON(w http.ResponseWriter, status int, info counter.Info) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
 
 	jsonData, _ := json.MarshalIndent(info, "", "  ")
 	_, _ = w.Write(jsonData)
@@ -1,13 +1,29 @@
 package handler
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
+	"strongdm/access"
+	"strongdm/adaptive"
+	"strongdm/ban"
+	"strongdm/cost"
 	"strongdm/counter"
+	"strongdm/keys"
+	"strongdm/metering"
+	"strongdm/metrics"
+	"strongdm/notify"
+	"strongdm/policy"
+	"strongdm/quota"
+	"strongdm/routes"
+	"strongdm/shed"
 )
 
 func TestHandleRequest_MethodNotAllowed(t *testing.T) {
@@ -241,3 +257,916 @@ func TestHandleRequest_ResetAtField(t *testing.T) {
 		t.Error("ResetAt should be in the future for rate limited requests")
 	}
 }
+
+func TestHandleRequest_Allowlisted(t *testing.T) {
+	l, _ := access.NewList(access.Rule{Action: access.ActionAllow, CIDR: "10.0.0.0/8"})
+	h := New(WithAccessList(l))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "10.0.0.1:12345"
+
+	// Allowlisted clients are never rate limited
+	for i := 0; i < 5; i++ {
+		w := httptest.NewRecorder()
+		h.HandleRequest(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("Request %d should succeed, got status %d", i+1, w.Code)
+		}
+	}
+
+	if info := h.counter.Peek("10.0.0.1", 120); info.Remaining != info.BucketSize {
+		t.Error("Allowlisted requests should not consume tokens")
+	}
+}
+
+func TestHandleRequest_AllowlistHeaderKey(t *testing.T) {
+	l, _ := access.NewList(access.Rule{Action: access.ActionAllow, CIDR: "10.0.0.0/8"})
+	h := New(WithAccessList(l), WithKeyFunc(keys.Header("X-Api-Key")), WithPolicy(policy.Policy{Name: "p", LimitPerWindow: 60}))
+
+	// A key that looks like an allowlisted address must not bypass the
+	// limiter, since the client chooses it.
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "192.168.1.27:12345"
+	req.Header.Set("X-Api-Key", "10.0.0.5")
+	h.HandleRequest(httptest.NewRecorder(), req)
+	w := httptest.NewRecorder()
+	h.HandleRequest(w, req)
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected status %d, got %d", http.StatusTooManyRequests, w.Code)
+	}
+}
+
+func TestHandleRequest_Denylisted(t *testing.T) {
+	l, _ := access.NewList(access.Rule{Action: access.ActionDeny, Key: "192.168.1.7"})
+	h := New(WithAccessList(l), WithDenyBody("go away"))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "192.168.1.7:12345"
+	w := httptest.NewRecorder()
+
+	h.HandleRequest(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d, got %d", http.StatusForbidden, w.Code)
+	}
+	if w.Body.String() != "go away" {
+		t.Errorf("Expected body 'go away', got '%s'", w.Body.String())
+	}
+	if w.Header().Get("Retry-After") != "" {
+		t.Error("Retry-After should not be set for denylisted clients")
+	}
+}
+
+func TestHandleRequest_Banned(t *testing.T) {
+	bans := ban.New(ban.Policy{Threshold: 1, Window: time.Minute, Duration: time.Minute})
+	h := New(WithBans(bans))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "192.168.1.8:12345"
+
+	// Fill the bucket, then get rejected once to trigger the ban
+	for i := 0; i < 3; i++ {
+		h.HandleRequest(httptest.NewRecorder(), req)
+	}
+	if _, banned := bans.Banned("192.168.1.8"); !banned {
+		t.Fatal("Expected key to be banned after a rejection")
+	}
+
+	// Even once the bucket has leaked, the banned key is rejected
+	h.counter = counter.New()
+	w := httptest.NewRecorder()
+	h.HandleRequest(w, req)
+
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected status %d, got %d", http.StatusTooManyRequests, w.Code)
+	}
+
+	var info counter.Info
+	if err := json.Unmarshal(w.Body.Bytes(), &info); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if !info.ResetAt.After(time.Now().Add(30 * time.Second)) {
+		t.Error("ResetAt should be the end of the ban")
+	}
+	if got := w.Header().Get("Retry-After"); got != "60" {
+		t.Errorf("Expected Retry-After '60' for the rest of the ban, got '%s'", got)
+	}
+}
+
+func TestHandleRequest_DecisionLog(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+	h := New(WithLogger(logger))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "192.168.1.9:12345"
+	h.HandleRequest(httptest.NewRecorder(), req)
+
+	var entry map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("Decision log should be valid JSON: %v", err)
+	}
+	if entry["key"] != "192.168.1.9" {
+		t.Errorf("Expected key '192.168.1.9', got %v", entry["key"])
+	}
+	if entry["policy"] != "default" {
+		t.Errorf("Expected policy 'default', got %v", entry["policy"])
+	}
+	if entry["allowed"] != true {
+		t.Errorf("Expected allowed=true, got %v", entry["allowed"])
+	}
+	if entry["remaining"] != float64(1) {
+		t.Errorf("Expected remaining=1, got %v", entry["remaining"])
+	}
+	for _, field := range []string{"reset", "latency"} {
+		if _, ok := entry[field]; !ok {
+			t.Errorf("Expected %s field in decision log", field)
+		}
+	}
+}
+
+func TestHandleRequest_DecisionLogSampling(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+	h := New(WithLogger(logger), WithAllowedSampleRate(0))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "192.168.1.10:12345"
+	for i := 0; i < 2; i++ {
+		h.HandleRequest(httptest.NewRecorder(), req)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("Allowed decisions should not be logged with sample rate 0, got %q", buf.String())
+	}
+
+	h.HandleRequest(httptest.NewRecorder(), req)
+	if !strings.Contains(buf.String(), `"allowed":false`) {
+		t.Errorf("Rejections should always be logged, got %q", buf.String())
+	}
+}
+
+func TestHandleRequest_LimitHeaders(t *testing.T) {
+	h := New()
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "192.168.1.11:12345"
+
+	w := httptest.NewRecorder()
+	h.HandleRequest(w, req)
+
+	if got := w.Header().Get("X-RateLimit-Limit"); got != "2" {
+		t.Errorf("Expected X-RateLimit-Limit '2', got '%s'", got)
+	}
+	if got := w.Header().Get("X-RateLimit-Remaining"); got != "1" {
+		t.Errorf("Expected X-RateLimit-Remaining '1', got '%s'", got)
+	}
+	if w.Header().Get("X-RateLimit-Reset") == "" {
+		t.Error("Expected X-RateLimit-Reset header")
+	}
+	if w.Header().Get("Retry-After") != "" {
+		t.Error("Retry-After should not be set on allowed requests")
+	}
+
+	h.HandleRequest(httptest.NewRecorder(), req)
+	w = httptest.NewRecorder()
+	h.HandleRequest(w, req)
+
+	if got := w.Header().Get("Retry-After"); got != "1" {
+		t.Errorf("Expected Retry-After '1', got '%s'", got)
+	}
+}
+
+func TestHandleRequest_Schedule(t *testing.T) {
+	p := policy.Policy{Name: "p", LimitPerWindow: 60}
+	h := New(WithPolicy(p))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "192.168.1.23:12345"
+	h.HandleRequest(httptest.NewRecorder(), req)
+
+	// An all-day schedule raises the limit for the same bucket, which keeps
+	// the token already spent.
+	p.Schedules = []policy.Schedule{{Name: "all-day", Start: "00:00", End: "00:00", LimitPerWindow: 180}}
+	WithPolicy(p)(h)
+	w := httptest.NewRecorder()
+	h.HandleRequest(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected the raised limit to allow the request, got status %d", w.Code)
+	}
+	if got := w.Header().Get("X-RateLimit-Schedule"); got != "all-day" {
+		t.Errorf("Expected X-RateLimit-Schedule 'all-day', got '%s'", got)
+	}
+	var info counter.Info
+	if err := json.Unmarshal(w.Body.Bytes(), &info); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if info.Schedule != "all-day" || info.BucketSize != 3 || info.Remaining != 1 {
+		t.Errorf("Expected the schedule's limit with the earlier token still spent, got %+v", info)
+	}
+}
+
+func TestHandleRequest_Quota(t *testing.T) {
+	store, err := quota.Open("")
+	if err != nil {
+		t.Fatalf("quota.Open() unexpected error: %v", err)
+	}
+	bans := ban.New(ban.Policy{Threshold: 1, Window: time.Minute, Duration: time.Minute})
+	h := New(
+		WithPolicy(policy.Policy{Name: "p", LimitPerWindow: 600, Quota: quota.Quota{Period: quota.PeriodDay, Limit: 2}}),
+		WithQuotas(store),
+		WithBans(bans),
+	)
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "192.168.1.24:12345"
+
+	for i, remaining := range []string{"1", "0"} {
+		w := httptest.NewRecorder()
+		h.HandleRequest(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Request %d: expected status %d, got %d", i, http.StatusOK, w.Code)
+		}
+		if got := w.Header().Get("X-Quota-Remaining"); got != remaining {
+			t.Errorf("Request %d: expected X-Quota-Remaining %s, got %q", i, remaining, got)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	h.HandleRequest(w, req)
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected status %d over the quota, got %d", http.StatusTooManyRequests, w.Code)
+	}
+	var info counter.Info
+	if err := json.Unmarshal(w.Body.Bytes(), &info); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if info.Quota == nil || info.Quota.Used != 2 || !info.ResetAt.Equal(info.Quota.ResetAt) {
+		t.Errorf("Expected the used up quota and its reset, got %+v", info)
+	}
+	if w.Header().Get("Retry-After") == "" || w.Header().Get("X-Quota-Reset") == "" {
+		t.Error("Expected Retry-After and X-Quota-Reset headers")
+	}
+	if _, banned := bans.Banned("192.168.1.24"); banned {
+		t.Error("Expected running out of quota not to count towards a ban")
+	}
+	if got := h.counter.Peek("192.168.1.24", 600).Remaining; got != 8 {
+		t.Errorf("Expected the rejected request not to spend per-minute tokens, got %d remaining", got)
+	}
+}
+
+// meteringSink keeps the usage records sent to it.
+type meteringSink struct {
+	records []metering.Record
+}
+
+func (s *meteringSink) Send(ctx context.Context, b metering.Batch) error {
+	s.records = append(s.records, b.Records...)
+	return nil
+}
+
+func TestHandleRequest_Metering(t *testing.T) {
+	sink := &meteringSink{}
+	m, err := metering.New(sink, t.TempDir())
+	if err != nil {
+		t.Fatalf("metering.New() unexpected error: %v", err)
+	}
+	h := New(WithPolicy(policy.Policy{Name: "p", LimitPerWindow: 60}), WithMeter(m))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "192.168.1.25:12345"
+	for range 3 {
+		h.HandleRequest(httptest.NewRecorder(), req)
+	}
+
+	if err := m.Close(context.Background()); err != nil {
+		t.Fatalf("Close() unexpected error: %v", err)
+	}
+	if len(sink.records) != 1 {
+		t.Fatalf("Expected 1 usage record, got %+v", sink.records)
+	}
+	if r := sink.records[0]; r.Key != "192.168.1.25" || r.Policy != "p" || r.Allowed != 1 || r.Rejected != 2 {
+		t.Errorf("Expected 1 allowed and 2 rejected requests, got %+v", r)
+	}
+}
+
+// eventSink passes the events sent to it to a channel.
+type eventSink chan notify.Event
+
+func (s eventSink) Send(ctx context.Context, e notify.Event) error {
+	s <- e
+	return nil
+}
+
+func TestHandleRequest_Notify(t *testing.T) {
+	sink := make(eventSink, 10)
+	n, err := notify.New(sink, notify.Config{RecoverAfter: time.Minute, MaxPerMinute: 60})
+	if err != nil {
+		t.Fatalf("notify.New() unexpected error: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go n.Run(ctx, func(err error) { t.Error(err) })
+
+	bans := ban.New(ban.Policy{Threshold: 2, Window: time.Minute, Duration: time.Minute})
+	h := New(WithPolicy(policy.Policy{Name: "p", LimitPerWindow: 60}), WithBans(bans), WithNotifier(n))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "192.168.1.26:12345"
+	for range 3 {
+		h.HandleRequest(httptest.NewRecorder(), req)
+	}
+
+	for _, want := range []notify.Type{notify.TypeThrottled, notify.TypeBanStarted} {
+		select {
+		case e := <-sink:
+			if e.Type != want || e.Key != "192.168.1.26" {
+				t.Errorf("Expected a %s event for the client, got %+v", want, e)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Expected a %s event", want)
+		}
+	}
+}
+
+func TestHandleRequest_ObserveMode(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+	h := New(
+		WithLogger(logger),
+		WithPolicy(policy.Policy{Name: "candidate", LimitPerWindow: 60, Mode: policy.ModeObserve}),
+	)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "192.168.1.12:12345"
+
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		h.HandleRequest(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("Request %d should be allowed in observe mode, got status %d", i+1, w.Code)
+		}
+		if i > 0 && w.Header().Get("X-RateLimit-Remaining") != "0" {
+			t.Errorf("Request %d should carry the real remaining count", i+1)
+		}
+	}
+
+	if !strings.Contains(buf.String(), `"outcome":"would_reject"`) {
+		t.Errorf("Expected would_reject decisions to be logged, got %q", buf.String())
+	}
+	if !strings.Contains(buf.String(), `"policy":"candidate"`) {
+		t.Errorf("Expected policy name to be logged, got %q", buf.String())
+	}
+}
+
+func TestHandleRequest_ShadowPolicy(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+	h := New(
+		WithLogger(logger),
+		WithShadowPolicy(policy.Policy{Name: "tight", LimitPerWindow: 60, Mode: policy.ModeObserve}),
+	)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "192.168.1.13:12345"
+
+	// The enforced policy allows 2, the shadow policy only 1
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		h.HandleRequest(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("Request %d should be allowed by the enforced policy, got status %d", i+1, w.Code)
+		}
+	}
+
+	if !strings.Contains(buf.String(), `"policy":"tight","outcome":"rejected","allowed":false,"enforcedAllowed":true`) {
+		t.Errorf("Expected shadow rejection to be logged, got %q", buf.String())
+	}
+}
+
+func TestMiddleware_ShadowPolicyCost(t *testing.T) {
+	var buf bytes.Buffer
+	h := New(
+		WithLogger(slog.New(slog.NewJSONHandler(&buf, nil))),
+		WithPolicy(policy.Policy{Name: "p", LimitPerWindow: 180}),
+		WithCostFunc(cost.ContentLength(1024)),
+		WithShadowPolicy(policy.Policy{Name: "tight", LimitPerWindow: 60, Mode: policy.ModeObserve}),
+	)
+	mw := h.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	req := httptest.NewRequest(http.MethodPost, "/upload", strings.NewReader(strings.Repeat("x", 2048)))
+	req.RemoteAddr = "192.168.1.17:12345"
+	w := httptest.NewRecorder()
+	mw.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected the enforced policy to allow a 2 token upload, got status %d", w.Code)
+	}
+
+	// The shadow bucket only holds 1 token, so charging the request's cost
+	// rejects it.
+	if !strings.Contains(buf.String(), `"policy":"tight","outcome":"rejected"`) {
+		t.Errorf("Expected the shadow policy to be charged the request's cost, got %q", buf.String())
+	}
+}
+
+func TestMiddleware_Routes(t *testing.T) {
+	table, err := routes.New([]routes.Route{
+		{Pattern: "POST /login", Policy: policy.Policy{Name: "login", LimitPerWindow: 60}},
+	})
+	if err != nil {
+		t.Fatalf("routes.New() unexpected error: %v", err)
+	}
+	var buf bytes.Buffer
+	h := New(WithRoutes(table), WithLogger(slog.New(slog.NewJSONHandler(&buf, nil))))
+	mw := h.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	send := func(method, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.RemoteAddr = "192.168.1.15:12345"
+		w := httptest.NewRecorder()
+		mw.ServeHTTP(w, req)
+		return w
+	}
+
+	// The login route allows 1 request, independently of the default
+	// policy's 2 for everything else.
+	if w := send(http.MethodPost, "/login"); w.Code != http.StatusOK || w.Header().Get("X-RateLimit-Limit") != "1" {
+		t.Errorf("Expected the login limit to apply, got status %d and headers %v", w.Code, w.Header())
+	}
+	if w := send(http.MethodPost, "/login"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected the second login to be rejected, got status %d", w.Code)
+	}
+	for i := 0; i < 2; i++ {
+		if w := send(http.MethodGet, "/login"); w.Code != http.StatusOK {
+			t.Errorf("Request %d to an unrouted method should use the default policy, got status %d", i+1, w.Code)
+		}
+	}
+
+	if !strings.Contains(buf.String(), `"key":"login/192.168.1.15","policy":"login"`) {
+		t.Errorf("Expected the route's bucket and policy to be logged, got %q", buf.String())
+	}
+}
+
+func TestMiddleware_RouteBucketForgedKey(t *testing.T) {
+	table, err := routes.New([]routes.Route{
+		{Pattern: "POST /login", Policy: policy.Policy{Name: "login", LimitPerWindow: 60}},
+	})
+	if err != nil {
+		t.Fatalf("routes.New() unexpected error: %v", err)
+	}
+	h := New(WithRoutes(table), WithKeyFunc(keys.Header("X-Api-Key")))
+	mw := h.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	send := func(method, path, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("X-Api-Key", key)
+		w := httptest.NewRecorder()
+		mw.ServeHTTP(w, req)
+		return w
+	}
+
+	// A key naming the victim's route bucket spends from its own bucket
+	// under the default policy instead.
+	for range 2 {
+		send(http.MethodGet, "/", "login/victim")
+	}
+	if w := send(http.MethodPost, "/login", "victim"); w.Code != http.StatusOK {
+		t.Errorf("Expected the victim's login bucket to be untouched, got status %d", w.Code)
+	}
+}
+
+func TestMiddleware_Cost(t *testing.T) {
+	h := New(
+		WithPolicy(policy.Policy{Name: "p", LimitPerWindow: 180}),
+		WithCostFunc(cost.ContentLength(1024)),
+		WithBans(ban.New(ban.Policy{Threshold: 1, Window: time.Minute, Duration: time.Minute})),
+	)
+	mw := h.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	send := func(size int) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/upload", strings.NewReader(strings.Repeat("x", size)))
+		req.RemoteAddr = "192.168.1.16:12345"
+		w := httptest.NewRecorder()
+		mw.ServeHTTP(w, req)
+		return w
+	}
+
+	// The bucket holds 3 tokens, so a 4 KiB upload can never fit.
+	w := send(4096)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected status %d for an oversized request, got %d", http.StatusRequestEntityTooLarge, w.Code)
+	}
+	if !strings.Contains(w.Body.String(), `"oversized": true`) {
+		t.Errorf("Expected the body to explain the rejection, got %q", w.Body.String())
+	}
+	if w.Header().Get("Retry-After") != "" {
+		t.Error("Retry-After should not be set when retrying cannot succeed")
+	}
+
+	// The oversized rejection does not start a ban, even with a threshold
+	// of 1.
+	if w := send(2048); w.Code != http.StatusOK || w.Header().Get("X-RateLimit-Remaining") != "1" {
+		t.Errorf("Expected a 2 token upload to be allowed, got status %d and headers %v", w.Code, w.Header())
+	}
+	if w := send(2048); w.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected status %d once the bucket is spent, got %d", http.StatusTooManyRequests, w.Code)
+	}
+}
+
+func TestMiddleware_RouteCost(t *testing.T) {
+	table, err := routes.New([]routes.Route{
+		{Pattern: "/search", Cost: "query:page_size:10", Policy: policy.Policy{Name: "search", LimitPerWindow: 600}},
+	})
+	if err != nil {
+		t.Fatalf("routes.New() unexpected error: %v", err)
+	}
+	h := New(WithRoutes(table))
+	mw := h.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	req := httptest.NewRequest(http.MethodGet, "/search?page_size=70", nil)
+	req.RemoteAddr = "192.168.1.17:12345"
+	w := httptest.NewRecorder()
+	mw.ServeHTTP(w, req)
+
+	if got := w.Header().Get("X-RateLimit-Remaining"); got != "3" {
+		t.Errorf("Expected a page of 70 to cost 7 of 10 tokens, got %s remaining", got)
+	}
+}
+
+func TestMiddleware_PostCharge(t *testing.T) {
+	c := counter.New()
+	h := New(
+		WithCounter(c),
+		WithPolicy(policy.Policy{Name: "p", LimitPerWindow: 600}),
+		WithPostCharge(10*time.Millisecond),
+	)
+	mw := h.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/report", nil)
+	req.RemoteAddr = "192.168.1.18:12345"
+	mw.ServeHTTP(httptest.NewRecorder(), req)
+
+	// One token up front plus at least 5 for the slow response.
+	if info := c.Peek("192.168.1.18", 600); info.Remaining > 4 {
+		t.Errorf("Expected the slow response to be charged, got %d remaining", info.Remaining)
+	}
+}
+
+func TestMiddleware_Delay(t *testing.T) {
+	// The bucket holds 10 and leaks one every 100ms.
+	h := New(WithPolicy(policy.Policy{
+		Name:           "queued",
+		LimitPerWindow: 600,
+		MaxDelay:       policy.Duration(500 * time.Millisecond),
+		MaxQueued:      1,
+	}))
+	mw := h.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	send := func(ctx context.Context) *httptest.ResponseRecorder {
+		req := httptest.NewRequestWithContext(ctx, http.MethodGet, "/", nil)
+		req.RemoteAddr = "192.168.1.19:12345"
+		w := httptest.NewRecorder()
+		mw.ServeHTTP(w, req)
+		return w
+	}
+	for i := 0; i < 10; i++ {
+		send(context.Background())
+	}
+
+	start := time.Now()
+	if w := send(context.Background()); w.Code != http.StatusOK {
+		t.Errorf("Expected the request to be held and then allowed, got status %d", w.Code)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("Expected the request to be held, took %v", elapsed)
+	}
+
+	// While one request is held, the queue is full and the next is
+	// rejected immediately.
+	held := make(chan *httptest.ResponseRecorder)
+	go func() { held <- send(context.Background()) }()
+	for {
+		h.queueMu.Lock()
+		n := h.queued["192.168.1.19"]
+		h.queueMu.Unlock()
+		if n == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if w := send(context.Background()); w.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected status %d with a full queue, got %d", http.StatusTooManyRequests, w.Code)
+	}
+	if w := <-held; w.Code != http.StatusOK {
+		t.Errorf("Expected the held request to be allowed, got status %d", w.Code)
+	}
+
+	// A client that goes away stops waiting.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	start = time.Now()
+	if w := send(ctx); w.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected status %d for a cancelled request, got %d", http.StatusTooManyRequests, w.Code)
+	}
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("Expected a cancelled request to return promptly, took %v", elapsed)
+	}
+}
+
+func TestMiddleware_DelayBudget(t *testing.T) {
+	// A rejected request would wait about a second, beyond the budget.
+	h := New(WithPolicy(policy.Policy{
+		Name:           "queued",
+		LimitPerWindow: 60,
+		MaxDelay:       policy.Duration(100 * time.Millisecond),
+	}))
+	mw := h.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "192.168.1.20:12345"
+	mw.ServeHTTP(httptest.NewRecorder(), req)
+
+	start := time.Now()
+	w := httptest.NewRecorder()
+	mw.ServeHTTP(w, req)
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected status %d, got %d", http.StatusTooManyRequests, w.Code)
+	}
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("Expected an immediate rejection, took %v", elapsed)
+	}
+}
+
+func TestMiddleware_LoadShedding(t *testing.T) {
+	shedder, err := shed.New(shed.Config{
+		Signal:   shed.SignalInFlight,
+		Capacity: 2,
+		Start:    0.5,
+		Rules:    []shed.Rule{{Priority: 1, Header: "X-Priority", Value: "high"}},
+	})
+	if err != nil {
+		t.Fatalf("shed.New() unexpected error: %v", err)
+	}
+	release := make(chan struct{})
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			<-release
+		}
+	})
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+	h := New(WithPolicy(policy.Policy{Name: "p"}), WithLoadShedding(shedder), WithLogger(logger))
+	mw := h.Middleware(next)
+
+	send := func(path, priority string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = "192.168.1.22:12345"
+		req.Header.Set("X-Priority", priority)
+		w := httptest.NewRecorder()
+		mw.ServeHTTP(w, req)
+		return w
+	}
+
+	done := make(chan struct{})
+	go func() {
+		send("/slow", "high")
+		close(done)
+	}()
+	for shedder.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	if w := send("/", "low"); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected the low tier to be shed with %d, got %d", http.StatusServiceUnavailable, w.Code)
+	}
+	if w := send("/", "high"); w.Code != http.StatusOK {
+		t.Errorf("Expected the high tier to be admitted, got status %d", w.Code)
+	}
+
+	close(release)
+	<-done
+	if w := send("/", "low"); w.Code != http.StatusOK {
+		t.Errorf("Expected the low tier to be admitted once load fell, got status %d", w.Code)
+	}
+
+	shedLogged := false
+	for line := range bytes.Lines(buf.Bytes()) {
+		var entry map[string]any
+		if err := json.Unmarshal(line, &entry); err != nil {
+			t.Fatalf("Decision log should be valid JSON: %v", err)
+		}
+		if entry["outcome"] == metrics.OutcomeShed {
+			shedLogged = true
+			if entry["priority"] != float64(0) {
+				t.Errorf("Expected the shed request to be logged at priority 0, got %v", entry["priority"])
+			}
+		}
+	}
+	if !shedLogged {
+		t.Error("Expected the shed request in the decision log")
+	}
+}
+
+func TestMiddleware_AdaptiveLimit(t *testing.T) {
+	limiter, err := adaptive.New(adaptive.Config{
+		Algorithm:    adaptive.AlgorithmAIMD,
+		InitialLimit: 2,
+		MinLimit:     1,
+		MaxLimit:     10,
+		Timeout:      time.Second,
+		Backoff:      0.5,
+	})
+	if err != nil {
+		t.Fatalf("adaptive.New() unexpected error: %v", err)
+	}
+	release := make(chan struct{})
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			<-release
+		}
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	})
+	h := New(WithPolicy(policy.Policy{Name: "p"}), WithAdaptiveLimit(limiter))
+	mw := h.Middleware(next)
+
+	send := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = "192.168.1.21:12345"
+		w := httptest.NewRecorder()
+		mw.ServeHTTP(w, req)
+		return w
+	}
+
+	// A failure halves the limit to 1.
+	if w := send("/fail"); w.Code != http.StatusBadGateway {
+		t.Errorf("Expected the wrapped handler's status, got %d", w.Code)
+	}
+	if limiter.Limit() != 1 {
+		t.Errorf("Expected a 5xx response to reduce the limit to 1, got %d", limiter.Limit())
+	}
+
+	done := make(chan struct{})
+	go func() {
+		send("/slow")
+		close(done)
+	}()
+	for metrics.InFlight.Value() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	w := send("/")
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status %d over the concurrency limit, got %d", http.StatusServiceUnavailable, w.Code)
+	}
+	if !strings.Contains(w.Body.String(), `"concurrencyLimit": 1`) {
+		t.Errorf("Expected the limit in the body, got %q", w.Body.String())
+	}
+
+	close(release)
+	<-done
+	if w := send("/"); w.Code != http.StatusOK {
+		t.Errorf("Expected a freed slot to admit the request, got status %d", w.Code)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	var calls int
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusCreated)
+	})
+	h := New(WithPolicy(policy.Policy{Name: "p", LimitPerWindow: 60}))
+	mw := h.Middleware(next)
+
+	req := httptest.NewRequest(http.MethodPost, "/upload", nil)
+	req.RemoteAddr = "192.168.1.14:12345"
+
+	w := httptest.NewRecorder()
+	mw.ServeHTTP(w, req)
+
+	if w.Code != http.StatusCreated {
+		t.Errorf("Expected the wrapped handler's status %d, got %d", http.StatusCreated, w.Code)
+	}
+	if w.Header().Get("X-RateLimit-Remaining") != "0" {
+		t.Errorf("Expected limit headers on the passed through response, got %v", w.Header())
+	}
+
+	w = httptest.NewRecorder()
+	mw.ServeHTTP(w, req)
+
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected status %d, got %d", http.StatusTooManyRequests, w.Code)
+	}
+	if calls != 1 {
+		t.Errorf("Expected rejected requests not to reach the wrapped handler, got %d calls", calls)
+	}
+}
+
+func TestHandleAuth(t *testing.T) {
+	h := New(WithPolicy(policy.Policy{Name: "p", LimitPerWindow: 60}))
+
+	newAuthRequest := func() *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "/auth", nil)
+		req.RemoteAddr = "10.0.0.1:12345" // the proxy
+		req.Header.Set("X-Forwarded-Method", http.MethodPost)
+		req.Header.Set("X-Forwarded-Uri", "/login?next=/")
+		req.Header.Set("X-Forwarded-For", "203.0.113.9")
+		return req
+	}
+
+	var buf bytes.Buffer
+	h.logger = slog.New(slog.NewJSONHandler(&buf, nil))
+
+	w := httptest.NewRecorder()
+	h.HandleAuth(w, newAuthRequest())
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	if w.Body.Len() != 0 {
+		t.Errorf("Expected no body, got '%s'", w.Body.String())
+	}
+	if w.Header().Get("X-RateLimit-Remaining") != "0" {
+		t.Errorf("Expected limit headers, got %v", w.Header())
+	}
+	if !strings.Contains(buf.String(), `"key":"203.0.113.9"`) ||
+		!strings.Contains(buf.String(), `"method":"POST","path":"/login"`) {
+		t.Errorf("Expected the original request to be evaluated, got %q", buf.String())
+	}
+
+	w = httptest.NewRecorder()
+	h.HandleAuth(w, newAuthRequest())
+
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected status %d, got %d", http.StatusTooManyRequests, w.Code)
+	}
+	if w.Body.Len() != 0 {
+		t.Errorf("Expected no body, got '%s'", w.Body.String())
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("Expected Retry-After header on rejection")
+	}
+}
+
+func TestHandleAuth_ForgedForwardedFor(t *testing.T) {
+	l, _ := access.NewList(access.Rule{Action: access.ActionAllow, CIDR: "10.0.0.0/8"})
+	var buf bytes.Buffer
+	h := New(WithAccessList(l), WithLogger(slog.New(slog.NewJSONHandler(&buf, nil))))
+
+	send := func(forged string, realIP string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/auth", nil)
+		req.RemoteAddr = "10.0.0.1:12345" // the proxy
+		// The proxy appends the client's address to whatever the client
+		// sent.
+		req.Header.Set("X-Forwarded-For", forged+", 203.0.113.9")
+		if realIP != "" {
+			req.Header.Set("X-Real-IP", realIP)
+		}
+		w := httptest.NewRecorder()
+		h.HandleAuth(w, req)
+		return w
+	}
+
+	// Rotating the forged entry neither bypasses the access list nor
+	// earns a fresh bucket.
+	for i, forged := range []string{"10.1.1.1", "10.2.2.2", "10.3.3.3"} {
+		w := send(forged, "")
+		want := http.StatusOK
+		if i == 2 {
+			want = http.StatusTooManyRequests
+		}
+		if w.Code != want {
+			t.Errorf("Request %d: expected status %d, got %d", i+1, want, w.Code)
+		}
+	}
+	if strings.Contains(buf.String(), `"key":"10.`) {
+		t.Errorf("Expected the forged address not to be used as the key, got %q", buf.String())
+	}
+
+	buf.Reset()
+	send("10.4.4.4", "198.51.100.4")
+	if !strings.Contains(buf.String(), `"key":"198.51.100.4"`) {
+		t.Errorf("Expected X-Real-IP to be preferred, got %q", buf.String())
+	}
+}
+
+func TestHandleAuth_NginxHeaders(t *testing.T) {
+	l, _ := access.NewList(access.Rule{Action: access.ActionDeny, CIDR: "198.51.100.0/24"})
+	h := New(WithAccessList(l))
+
+	req := httptest.NewRequest(http.MethodGet, "/auth", nil)
+	req.Header.Set("X-Original-URI", "/admin")
+	req.Header.Set("X-Real-IP", "198.51.100.4")
+	w := httptest.NewRecorder()
+	h.HandleAuth(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d, got %d", http.StatusForbidden, w.Code)
+	}
+	if w.Body.Len() != 0 {
+		t.Errorf("Expected no body, got '%s'", w.Body.String())
+	}
+}
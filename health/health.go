@@ -0,0 +1,125 @@
+// Package health serves liveness and readiness probes. Probes are answered
+// without touching the rate limiter, so orchestrators can poll them freely.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"strongdm/version"
+)
+
+// checkTimeout bounds how long a single readiness check may take.
+const checkTimeout = 2 * time.Second
+
+// Check reports an error if a dependency is not ready to serve traffic.
+type Check func(ctx context.Context) error
+
+// Checker tracks the readiness of the service. It is not ready until
+// SetReady(true) is called once startup is complete.
+type Checker struct {
+	ready atomic.Bool
+
+	mu     sync.RWMutex
+	checks map[string]Check
+}
+
+// New creates a Checker that is not yet ready.
+func New() *Checker {
+	return &Checker{
+		checks: map[string]Check{},
+	}
+}
+
+// Add registers a named readiness check.
+func (c *Checker) Add(name string, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checks[name] = check
+}
+
+// SetReady marks whether startup has completed and the service has not begun
+// shutting down.
+func (c *Checker) SetReady(ready bool) {
+	c.ready.Store(ready)
+}
+
+// Status is the readiness report served by Readyz.
+type Status struct {
+	Ready  bool              `json:"ready"`
+	Checks map[string]string `json:"checks"`
+}
+
+// Status runs all checks and reports the result.
+func (c *Checker) Status(ctx context.Context) Status {
+	status := Status{
+		Ready:  true,
+		Checks: map[string]string{},
+	}
+	if c.ready.Load() {
+		status.Checks["config"] = "ok"
+	} else {
+		status.Ready = false
+		status.Checks["config"] = "not ready"
+	}
+
+	c.mu.RLock()
+	names := make([]string, 0, len(c.checks))
+	for name := range c.checks {
+		names = append(names, name)
+	}
+	c.mu.RUnlock()
+	sort.Strings(names)
+
+	for _, name := range names {
+		c.mu.RLock()
+		check := c.checks[name]
+		c.mu.RUnlock()
+
+		checkCtx, cancel := context.WithTimeout(ctx, checkTimeout)
+		err := check(checkCtx)
+		cancel()
+		if err != nil {
+			status.Ready = false
+			status.Checks[name] = err.Error()
+		} else {
+			status.Checks[name] = "ok"
+		}
+	}
+	return status
+}
+
+// Healthz answers liveness probes. It succeeds whenever the process is able
+// to serve HTTP.
+func Healthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = w.Write([]byte("ok\n"))
+}
+
+// Readyz answers readiness probes with the result of Status, using status 503
+// when the service is not ready.
+func (c *Checker) Readyz(w http.ResponseWriter, r *http.Request) {
+	status := c.Status(r.Context())
+
+	w.Header().Set("Content-Type", "application/json")
+	if status.Ready {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	jsonData, _ := json.MarshalIndent(status, "", "  ")
+	_, _ = w.Write(jsonData)
+}
+
+// Register mounts the liveness, readiness and build metadata endpoints on mux
+// at /healthz, /readyz and /version.
+func (c *Checker) Register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /healthz", Healthz)
+	mux.HandleFunc("GET /readyz", c.Readyz)
+	mux.HandleFunc("GET /version", version.Handler)
+}
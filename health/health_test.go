@@ -0,0 +1,82 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"strongdm/version"
+)
+
+func TestReadyz(t *testing.T) {
+	c := New()
+	backendErr := errors.New("state directory is not writable")
+	var failing bool
+	c.Add("backend", func(ctx context.Context) error {
+		if failing {
+			return backendErr
+		}
+		return nil
+	})
+
+	mux := http.NewServeMux()
+	c.Register(mux)
+
+	readyz := func() (int, Status) {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		var status Status
+		if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		return w.Code, status
+	}
+
+	if code, status := readyz(); code != http.StatusServiceUnavailable || status.Checks["config"] != "not ready" {
+		t.Errorf("Expected not ready before startup completes, got %d %+v", code, status)
+	}
+
+	c.SetReady(true)
+	if code, status := readyz(); code != http.StatusOK || !status.Ready {
+		t.Errorf("Expected ready, got %d %+v", code, status)
+	}
+
+	failing = true
+	code, status := readyz()
+	if code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status %d with a failing check, got %d", http.StatusServiceUnavailable, code)
+	}
+	if status.Checks["backend"] != backendErr.Error() {
+		t.Errorf("Expected backend error to be reported, got %+v", status.Checks)
+	}
+}
+
+func TestHealthz(t *testing.T) {
+	mux := http.NewServeMux()
+	New().Register(mux)
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+}
+
+func TestVersion(t *testing.T) {
+	mux := http.NewServeMux()
+	New().Register(mux)
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/version", nil))
+
+	var info version.Info
+	if err := json.Unmarshal(w.Body.Bytes(), &info); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if info.Version != version.Version || info.GoVersion == "" {
+		t.Errorf("Unexpected build info: %+v", info)
+	}
+}
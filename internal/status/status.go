@@ -0,0 +1,33 @@
+// Package status records the status code of HTTP responses for middleware
+// that acts on how a request ended.
+package status
+
+import "net/http"
+
+// Recorder remembers the status code written through it.
+type Recorder struct {
+	http.ResponseWriter
+
+	// Code is the status code of the response, or 0 if nothing has been
+	// written yet.
+	Code int
+}
+
+func (r *Recorder) WriteHeader(code int) {
+	if r.Code == 0 {
+		r.Code = code
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *Recorder) Write(b []byte) (int, error) {
+	if r.Code == 0 {
+		r.Code = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// Unwrap returns the underlying writer for http.ResponseController.
+func (r *Recorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -0,0 +1,23 @@
+package status
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRecorder(t *testing.T) {
+	rec := &Recorder{ResponseWriter: httptest.NewRecorder()}
+	_, _ = rec.Write([]byte("ok"))
+	rec.WriteHeader(http.StatusInternalServerError)
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected an implicit %d to be recorded, got %d", http.StatusOK, rec.Code)
+	}
+
+	rec = &Recorder{ResponseWriter: httptest.NewRecorder()}
+	rec.WriteHeader(http.StatusBadGateway)
+	_, _ = rec.Write([]byte("bad gateway"))
+	if rec.Code != http.StatusBadGateway {
+		t.Errorf("Expected %d, got %d", http.StatusBadGateway, rec.Code)
+	}
+}
@@ -0,0 +1,111 @@
+// Package keys implements the strategies used to derive a rate limit bucket
+// key from a request.
+package keys
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Func extracts the rate limit bucket key from a request.
+type Func func(r *http.Request) string
+
+// Strategy names accepted by Parse.
+const (
+	StrategyIP           = "ip"
+	StrategyForwardedFor = "forwarded-for"
+	StrategyClientCert   = "client-cert"
+	StrategyHeaderPrefix = "header:"
+)
+
+// Parse returns the key function for the named strategy: "ip",
+// "forwarded-for", "client-cert" or "header:<Name>".
+func Parse(strategy string) (Func, error) {
+	switch {
+	case strategy == StrategyIP:
+		return RemoteIP, nil
+	case strategy == StrategyForwardedFor:
+		return ForwardedFor, nil
+	case strategy == StrategyClientCert:
+		return ClientCert, nil
+	case strings.HasPrefix(strategy, StrategyHeaderPrefix):
+		name := strings.TrimPrefix(strategy, StrategyHeaderPrefix)
+		if name == "" {
+			return nil, fmt.Errorf("key strategy %q is missing a header name", strategy)
+		}
+		return Header(name), nil
+	default:
+		return nil, fmt.Errorf("unknown key strategy %q, must be %q, %q, %q or %q", strategy,
+			StrategyIP, StrategyForwardedFor, StrategyClientCert, StrategyHeaderPrefix+"<Name>")
+	}
+}
+
+// escaper replaces the namespace separator in keys, and the escape character
+// itself so that escaping cannot be forged.
+var escaper = strings.NewReplacer("%", "%25", "/", "%2F")
+
+// Escape returns key with "/" escaped, so that it can be used as a bucket on
+// its own without colliding with a Bucket in a namespace.
+func Escape(key string) string {
+	return escaper.Replace(key)
+}
+
+// Bucket returns the bucket for key in namespace, such as a route or policy
+// name. Since the key is escaped, whatever a client chooses as its key cannot
+// name a bucket in another namespace.
+func Bucket(namespace, key string) string {
+	return namespace + "/" + Escape(key)
+}
+
+// RemoteIP keys requests by the address of the connecting client.
+func RemoteIP(r *http.Request) string {
+	remoteHost, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remoteHost = r.RemoteAddr
+	}
+	return remoteHost
+}
+
+// ForwardedFor keys requests by the original client address reported in the
+// first X-Forwarded-For entry, falling back to RemoteIP. It should only be
+// used behind a proxy that overwrites the header.
+func ForwardedFor(r *http.Request) string {
+	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+		first, _, _ := strings.Cut(xff, ",")
+		if first = strings.TrimSpace(first); first != "" {
+			return first
+		}
+	}
+	return RemoteIP(r)
+}
+
+// Header keys requests by the value of the named header, such as an API key,
+// falling back to RemoteIP when the header is absent.
+func Header(name string) Func {
+	return func(r *http.Request) string {
+		if v := r.Header.Get(name); v != "" {
+			return v
+		}
+		return RemoteIP(r)
+	}
+}
+
+// ClientCert keys requests by the identity in the verified TLS client
+// certificate: its first URI SAN, such as a SPIFFE ID, or else its subject
+// common name. It falls back to RemoteIP for requests without a verified
+// certificate.
+func ClientCert(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return RemoteIP(r)
+	}
+	leaf := r.TLS.VerifiedChains[0][0]
+	if len(leaf.URIs) > 0 {
+		return leaf.URIs[0].String()
+	}
+	if leaf.Subject.CommonName != "" {
+		return leaf.Subject.CommonName
+	}
+	return RemoteIP(r)
+}
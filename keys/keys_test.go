@@ -0,0 +1,139 @@
+package keys
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "192.168.1.1:12345"
+	req.Header.Set("X-Forwarded-For", "203.0.113.7, 10.0.0.1")
+	req.Header.Set("X-Api-Key", "secret")
+
+	tests := []struct {
+		strategy string
+		expected string
+		wantErr  bool
+	}{
+		{strategy: "ip", expected: "192.168.1.1"},
+		{strategy: "forwarded-for", expected: "203.0.113.7"},
+		{strategy: "header:X-Api-Key", expected: "secret"},
+		{strategy: "header:X-Missing", expected: "192.168.1.1"},
+		{strategy: "header:", wantErr: true},
+		{strategy: "cookie", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.strategy, func(t *testing.T) {
+			fn, err := Parse(tt.strategy)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Parse(%q) expected error", tt.strategy)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse(%q) unexpected error: %v", tt.strategy, err)
+			}
+			if result := fn(req); result != tt.expected {
+				t.Errorf("key = %q, expected %q", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestRemoteIP(t *testing.T) {
+	tests := []struct {
+		remoteAddr string
+		expected   string
+	}{
+		{remoteAddr: "192.168.1.1:12345", expected: "192.168.1.1"},
+		{remoteAddr: "192.168.1.1", expected: "192.168.1.1"},
+		{remoteAddr: "[::1]:12345", expected: "::1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.remoteAddr, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if result := RemoteIP(req); result != tt.expected {
+				t.Errorf("RemoteIP() = %q, expected %q", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestForwardedFor_Fallback(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "192.168.1.1:12345"
+	if result := ForwardedFor(req); result != "192.168.1.1" {
+		t.Errorf("ForwardedFor() = %q, expected %q", result, "192.168.1.1")
+	}
+}
+
+func TestBucket(t *testing.T) {
+	// A key cannot name a bucket in another namespace, nor one of its own
+	// escapes.
+	buckets := map[string]bool{}
+	for _, b := range []string{
+		Bucket("login", "1.2.3.4"),
+		Escape("login/1.2.3.4"),
+		Bucket("login", "%2F"),
+		Bucket("login", "/"),
+		Bucket("a/b", "c"),
+		Bucket("a", "b/c"),
+	} {
+		if buckets[b] {
+			t.Errorf("Expected bucket %q to be distinct", b)
+		}
+		buckets[b] = true
+	}
+	if got := Bucket("login", "192.168.1.1"); got != "login/192.168.1.1" {
+		t.Errorf("Expected plain keys to be left alone, got %q", got)
+	}
+}
+
+func TestClientCert(t *testing.T) {
+	spiffe, _ := url.Parse("spiffe://example.org/batch")
+
+	tests := []struct {
+		name     string
+		state    *tls.ConnectionState
+		expected string
+	}{
+		{name: "plain http", expected: "192.168.1.1"},
+		{name: "no client cert", state: &tls.ConnectionState{}, expected: "192.168.1.1"},
+		{
+			name: "uri san",
+			state: &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{
+				URIs:    []*url.URL{spiffe},
+				Subject: pkix.Name{CommonName: "batch"},
+			}}}},
+			expected: "spiffe://example.org/batch",
+		},
+		{
+			name: "common name",
+			state: &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{
+				Subject: pkix.Name{CommonName: "batch"},
+			}}}},
+			expected: "batch",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = "192.168.1.1:12345"
+			req.TLS = tt.state
+			if result := ClientCert(req); result != tt.expected {
+				t.Errorf("ClientCert() = %q, expected %q", result, tt.expected)
+			}
+		})
+	}
+}
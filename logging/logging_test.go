@@ -0,0 +1,53 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestNew_JSON(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(&buf, "json", "info")
+	if err != nil {
+		t.Fatalf("New() unexpected error: %v", err)
+	}
+
+	logger.Debug("hidden")
+	logger.Info("decision", "key", "192.168.1.1")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("Expected 1 log line, got %d: %q", len(lines), buf.String())
+	}
+	var entry map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatalf("Log line should be valid JSON: %v", err)
+	}
+	if entry["key"] != "192.168.1.1" {
+		t.Errorf("Expected key attribute, got %v", entry["key"])
+	}
+}
+
+func TestNew_Text(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(&buf, "TEXT", "debug")
+	if err != nil {
+		t.Fatalf("New() unexpected error: %v", err)
+	}
+
+	logger.Debug("decision", "key", "k")
+	if !strings.Contains(buf.String(), "key=k") {
+		t.Errorf("Expected text output, got %q", buf.String())
+	}
+}
+
+func TestNew_Invalid(t *testing.T) {
+	if _, err := New(&bytes.Buffer{}, "xml", "info"); err == nil {
+		t.Error("Expected error for invalid format")
+	}
+	if _, err := New(&bytes.Buffer{}, "json", "loud"); err == nil {
+		t.Error("Expected error for invalid level")
+	}
+}
@@ -20,17 +20,528 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+	// The image has no zone database, and policy schedules name time zones.
+	_ "time/tzdata"
 
+	rlsv3 "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v3"
+	"google.golang.org/grpc"
+
+	"strongdm/access"
+	"strongdm/adaptive"
+	"strongdm/admin"
+	"strongdm/ban"
+	"strongdm/bandwidth"
+	"strongdm/breaker"
+	"strongdm/certs"
+	"strongdm/config"
+	"strongdm/cost"
+	"strongdm/counter"
+	"strongdm/decision"
 	"strongdm/handler"
+	"strongdm/health"
+	"strongdm/keys"
+	"strongdm/logging"
+	"strongdm/metering"
+	"strongdm/notify"
+	"strongdm/proxy"
+	"strongdm/quota"
+	"strongdm/rls"
+	"strongdm/routes"
+	"strongdm/shed"
 )
 
 func main() {
-	bindAddr := os.Getenv("BIND_ADDR")
-	log.Println("Listening on " + bindAddr)
+	cfg, err := config.Load(os.Args[1:], os.LookupEnv)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	if cfg.PrintConfig {
+		if err := cfg.Print(os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if err := run(cfg); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run starts the servers and blocks until they fail or a termination signal
+// is received, then shuts them down gracefully.
+func run(cfg config.Config) error {
+	logger, err := logging.New(os.Stderr, cfg.Log.Format, cfg.Log.Level)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(logger)
+
+	rules, err := cfg.AccessRules()
+	if err != nil {
+		return err
+	}
+	accessList, err := access.NewList(rules...)
+	if err != nil {
+		return err
+	}
+
+	keyFunc, err := keys.Parse(cfg.KeyStrategy)
+	if err != nil {
+		return err
+	}
+
+	// CIDR access rules match the connecting address, or the forwarded one
+	// when the proxy in front is trusted to set it. Keys taken from other
+	// headers are chosen by the client, so they are never used.
+	addrFunc := keys.RemoteIP
+	if cfg.KeyStrategy == keys.StrategyForwardedFor {
+		addrFunc = keys.ForwardedFor
+	}
+
+	checker := health.New()
+
+	c := counter.New()
+	if cfg.Backend.Type == config.BackendFile {
+		if err := loadState(c, cfg.Backend.Path); err != nil {
+			return err
+		}
+		checker.Add("backend", stateDirWritable(cfg.Backend.Path))
+	}
+
+	costFunc, err := cost.Parse(cfg.Limit.Cost)
+	if err != nil {
+		return err
+	}
+
+	policies := cfg.Policies()
+	opts := []handler.Option{
+		handler.WithCounter(c),
+		handler.WithPolicy(policies[0]),
+		handler.WithKeyFunc(keyFunc),
+		handler.WithAddrFunc(addrFunc),
+		handler.WithCostFunc(costFunc),
+		handler.WithPostCharge(time.Duration(cfg.Limit.PostChargeEvery)),
+		handler.WithLogger(logger),
+		handler.WithAllowedSampleRate(cfg.Log.SampleAllowed),
+		handler.WithAccessList(accessList),
+		handler.WithDenyBody(cfg.Access.DenyBody),
+	}
+	if len(policies) > 1 {
+		opts = append(opts, handler.WithShadowPolicy(policies[1]))
+	}
+	if len(cfg.Limit.Routes) > 0 {
+		table, err := routes.New(cfg.Limit.Routes)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, handler.WithRoutes(table))
+	}
+
+	if cfg.Adaptive.Enabled() {
+		limiter, err := adaptive.New(cfg.Adaptive.Config())
+		if err != nil {
+			return err
+		}
+		opts = append(opts, handler.WithAdaptiveLimit(limiter))
+	}
+
+	if cfg.Shed.Enabled() {
+		shedder, err := shed.New(cfg.Shed.Config())
+		if err != nil {
+			return err
+		}
+		opts = append(opts, handler.WithLoadShedding(shedder))
+	}
+
+	var quotas *quota.Store
+	if cfg.QuotasEnabled() {
+		quotas, err = quota.Open(cfg.Quota.StateFile)
+		if err != nil {
+			return err
+		}
+		checker.Add("quota", stateDirWritable(cfg.Quota.StateFile))
+		opts = append(opts, handler.WithQuotas(quotas))
+	}
+
+	var meter *metering.Meter
+	if cfg.Metering.Enabled() {
+		meter, err = newMeter(cfg.Metering)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, handler.WithMeter(meter))
+	}
+
+	var notifier *notify.Notifier
+	if cfg.Notify.Enabled() {
+		var sink notify.Sink
+		switch cfg.Notify.Sink {
+		case config.NotifyWebhook:
+			sink = notify.NewWebhook(cfg.Notify.WebhookURL, cfg.Notify.WebhookSecret, time.Duration(cfg.Notify.WebhookTimeout))
+		case config.NotifyFile:
+			f, err := notify.OpenFile(cfg.Notify.File)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			sink = f
+		}
+		notifier, err = notify.New(sink, cfg.Notify.Config())
+		if err != nil {
+			return err
+		}
+		opts = append(opts, handler.WithNotifier(notifier))
+	}
+
+	adminOpts := []admin.Option{
+		admin.WithHealth(checker),
+		admin.WithAccessList(accessList),
+	}
+
+	if cfg.Ban.Threshold > 0 {
+		bans := ban.New(ban.Policy{
+			Threshold:   cfg.Ban.Threshold,
+			Window:      time.Duration(cfg.Ban.Window),
+			Duration:    time.Duration(cfg.Ban.Duration),
+			MaxDuration: time.Duration(cfg.Ban.MaxDuration),
+			ForgetAfter: time.Duration(cfg.Ban.ForgetAfter),
+		})
+		opts = append(opts, handler.WithBans(bans))
+		adminOpts = append(adminOpts, admin.WithBans(bans))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if quotas != nil {
+		go quotas.Run(ctx, time.Duration(cfg.Quota.SyncInterval), func(err error) {
+			slog.Error("Quota sync failed", slog.Any("error", err))
+		})
+	}
+	if meter != nil {
+		go meter.Run(ctx, func(err error) {
+			slog.Error("Usage metering failed", slog.Any("error", err))
+		})
+	}
+	if notifier != nil {
+		go notifier.Run(ctx, func(err error) {
+			slog.Warn("Event notification failed", slog.Any("error", err))
+		})
+	}
+
+	h := handler.New(opts...)
+	mux := http.NewServeMux()
+	if !cfg.Admin.PrivateHealth {
+		checker.Register(mux)
+	}
+	if cfg.ForwardAuthPath != "" {
+		mux.HandleFunc(cfg.ForwardAuthPath, h.HandleAuth)
+	}
+	if cfg.Proxy.Enabled() {
+		var proxyOpts []proxy.Option
+		if cfg.Breaker.Enabled() {
+			breakers := breaker.New(cfg.Breaker.Policy())
+			proxyOpts = append(proxyOpts, proxy.WithBreakers(breakers))
+			adminOpts = append(adminOpts, admin.WithBreakers(breakers))
+		}
+		upstream, err := proxy.New(cfg.Proxy.AllRoutes(), proxyOpts...)
+		if err != nil {
+			return err
+		}
+		mux.Handle("/", h.Middleware(paced(cfg.Bandwidth, cfg.Server, keyFunc, upstream)))
+	} else {
+		mux.Handle("/", paced(cfg.Bandwidth, cfg.Server, keyFunc, http.HandlerFunc(h.HandleRequest)))
+	}
+
+	mainServer := newServer(cfg.Server, cfg.BindAddr, mux)
+	if cfg.TLS.Enabled() {
+		reloader, err := certs.New(cfg.TLS.CertFile, cfg.TLS.KeyFile, cfg.TLS.ClientCAFile)
+		if err != nil {
+			return err
+		}
+		go reloader.Watch(ctx, time.Duration(cfg.TLS.ReloadInterval))
+
+		clientAuth := tls.RequireAndVerifyClientCert
+		if cfg.TLS.ClientAuth == config.ClientAuthOptional {
+			clientAuth = tls.VerifyClientCertIfGiven
+		}
+		mainServer.TLSConfig = reloader.TLSConfig(clientAuth)
+	}
+
+	servers := []*http.Server{mainServer}
+	if cfg.Admin.Addr != "" {
+		servers = append(servers, newServer(cfg.Server, cfg.Admin.Addr, admin.New(adminOpts...)))
+	}
+
+	var grpcServers []grpcServer
+	if cfg.RLS.Addr != "" {
+		rateLimitService, err := rls.New(c, cfg.RLS.Rules, logger)
+		if err != nil {
+			return err
+		}
+		srv := grpc.NewServer()
+		rlsv3.RegisterRateLimitServiceServer(srv, rateLimitService)
+		grpcServers = append(grpcServers, grpcServer{"rls", cfg.RLS.Addr, srv})
+	}
+
+	if cfg.Decision.Enabled() {
+		var decisionOpts []decision.Option
+		if quotas != nil {
+			decisionOpts = append(decisionOpts, decision.WithQuotas(quotas))
+		}
+		if meter != nil {
+			decisionOpts = append(decisionOpts, decision.WithMeter(meter))
+		}
+		if notifier != nil {
+			decisionOpts = append(decisionOpts, decision.WithNotifier(notifier))
+		}
+		decisionService, err := decision.New(c, cfg.DecisionPolicies(), logger, decisionOpts...)
+		if err != nil {
+			return err
+		}
+		if cfg.Decision.Addr != "" {
+			servers = append(servers, newServer(cfg.Server, cfg.Decision.Addr, decisionService.Handler()))
+		}
+		if cfg.Decision.GRPCAddr != "" {
+			srv := grpc.NewServer()
+			decisionService.Register(srv)
+			grpcServers = append(grpcServers, grpcServer{"decision", cfg.Decision.GRPCAddr, srv})
+		}
+	}
+
+	// Bind every address before serving on any of them, so that one that is
+	// unavailable fails startup without leaving the others running.
+	addrs := make([]string, 0, len(servers)+len(grpcServers))
+	for _, srv := range servers {
+		addrs = append(addrs, srv.Addr)
+	}
+	for _, gs := range grpcServers {
+		addrs = append(addrs, gs.addr)
+	}
+	listeners, err := listen(addrs)
+	if err != nil {
+		return err
+	}
+
+	serveErr := make(chan error, len(servers)+len(grpcServers))
+	for i, srv := range servers {
+		lis := listeners[i]
+		go func() {
+			slog.Info("Listening", slog.String("addr", srv.Addr), slog.Bool("tls", srv.TLSConfig != nil))
+			var err error
+			if srv.TLSConfig != nil {
+				// The certificates come from TLSConfig, so no files are
+				// passed here.
+				err = srv.ServeTLS(lis, "", "")
+			} else {
+				err = srv.Serve(lis)
+			}
+			if !errors.Is(err, http.ErrServerClosed) {
+				serveErr <- err
+			}
+		}()
+	}
+
+	for i, gs := range grpcServers {
+		lis := listeners[len(servers)+i]
+		go func() {
+			slog.Info("Listening", slog.String("addr", gs.addr), slog.String("grpc", gs.name))
+			if err := gs.srv.Serve(lis); err != nil {
+				serveErr <- err
+			}
+		}()
+	}
+
+	checker.SetReady(true)
+
+	select {
+	case err = <-serveErr:
+	case <-ctx.Done():
+		slog.Info("Shutting down")
+	}
+	checker.SetReady(false)
+
+	// Drain in-flight requests on all servers within a shared deadline.
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.Server.ShutdownTimeout))
+	defer cancel()
+	for _, srv := range servers {
+		if shutdownErr := srv.Shutdown(shutdownCtx); shutdownErr != nil {
+			slog.Error("Shutdown incomplete", slog.String("addr", srv.Addr), slog.Any("error", shutdownErr))
+		}
+	}
+
+	for _, gs := range grpcServers {
+		stopGRPC(shutdownCtx, gs)
+	}
+
+	if cfg.Backend.Type == config.BackendFile {
+		if saveErr := saveState(c, cfg.Backend.Path); saveErr != nil {
+			err = errors.Join(err, saveErr)
+		}
+	}
+	if quotas != nil {
+		if syncErr := quotas.Sync(); syncErr != nil {
+			err = errors.Join(err, syncErr)
+		}
+	}
+	if meter != nil {
+		if closeErr := meter.Close(shutdownCtx); closeErr != nil {
+			err = errors.Join(err, closeErr)
+		}
+	}
+	// This must happen before the deferred close of a file sink.
+	if notifier != nil {
+		if closeErr := notifier.Close(shutdownCtx); closeErr != nil {
+			slog.Warn("Event notification failed", slog.Any("error", closeErr))
+		}
+	}
+	return err
+}
+
+// newMeter creates a usage meter exporting to the configured sink.
+func newMeter(cfg config.Metering) (*metering.Meter, error) {
+	var sink metering.Sink
+	switch cfg.Sink {
+	case config.MeteringFile:
+		f, err := metering.NewFile(cfg.File, cfg.Format, cfg.MaxBytes, cfg.MaxFiles)
+		if err != nil {
+			return nil, err
+		}
+		sink = f
+	case config.MeteringWebhook:
+		sink = metering.NewWebhook(cfg.WebhookURL, time.Duration(cfg.WebhookTimeout))
+	}
+	return metering.New(sink, cfg.SpoolDir)
+}
+
+// newServer creates an http.Server with timeouts that protect against slow
+// clients holding connections open.
+func newServer(cfg config.Server, addr string, h http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           h,
+		ReadHeaderTimeout: time.Duration(cfg.ReadHeaderTimeout),
+		ReadTimeout:       time.Duration(cfg.ReadTimeout),
+		WriteTimeout:      time.Duration(cfg.WriteTimeout),
+		IdleTimeout:       time.Duration(cfg.IdleTimeout),
+		MaxHeaderBytes:    cfg.MaxHeaderBytes,
+	}
+}
+
+// paced wraps next with bandwidth limiting, if configured. The server's read
+// and write timeouts then apply to each paced chunk rather than to the whole
+// body.
+func paced(cfg config.Bandwidth, server config.Server, key keys.Func, next http.Handler) http.Handler {
+	if !cfg.Enabled() {
+		return next
+	}
+	readTimeout, writeTimeout := time.Duration(server.ReadTimeout), time.Duration(server.WriteTimeout)
+	var in, out *bandwidth.Limiter
+	if cfg.UploadBytesPerSecond > 0 {
+		in = bandwidth.New(cfg.UploadBytesPerSecond, cfg.Burst, readTimeout)
+	}
+	if cfg.DownloadBytesPerSecond > 0 {
+		out = bandwidth.New(cfg.DownloadBytesPerSecond, cfg.Burst, writeTimeout)
+	}
+	return bandwidth.Middleware(in, out, key, next)
+}
+
+// listen binds a TCP listener to each of addrs. If any cannot be bound, the
+// ones already bound are closed.
+func listen(addrs []string) ([]net.Listener, error) {
+	listeners := make([]net.Listener, 0, len(addrs))
+	for _, addr := range addrs {
+		lis, err := net.Listen("tcp", addr)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, err
+		}
+		listeners = append(listeners, lis)
+	}
+	return listeners, nil
+}
+
+// grpcServer is a gRPC server and the address it listens on.
+type grpcServer struct {
+	name string
+	addr string
+	srv  *grpc.Server
+}
+
+// stopGRPC drains in-flight RPCs, forcing connections closed if ctx expires
+// first.
+func stopGRPC(ctx context.Context, gs grpcServer) {
+	stopped := make(chan struct{})
+	go func() {
+		gs.srv.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		slog.Error("Shutdown incomplete", slog.String("server", gs.name), slog.Any("error", ctx.Err()))
+		gs.srv.Stop()
+	}
+}
+
+// stateDirWritable checks that a state file, such as the one for the file
+// backend, can be written when the service shuts down.
+func stateDirWritable(path string) health.Check {
+	return func(ctx context.Context) error {
+		f, err := os.CreateTemp(filepath.Dir(path), ".readyz-*")
+		if err != nil {
+			return err
+		}
+		f.Close()
+		return os.Remove(f.Name())
+	}
+}
+
+// loadState restores counter state from path. A missing file is not an error,
+// since there is no state on first start.
+func loadState(c *counter.Counter, path string) error {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return c.Load(f)
+}
 
-	h := handler.New()
-	log.Fatal(http.ListenAndServe(bindAddr, http.HandlerFunc(h.HandleRequest)))
+// saveState writes counter state to path, replacing it atomically so a crash
+// mid-write cannot corrupt the previous state.
+func saveState(c *counter.Counter, path string) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := c.Save(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
 }
@@ -0,0 +1,335 @@
+// Package metering exports usage records for billing. A Meter counts the
+// requests allowed and rejected per key and policy, aggregates the counts per
+// minute, and delivers the records to a Sink.
+//
+// Delivery is at least once. Each minute's records are written to a spool
+// directory before they are sent, and are only removed from it once the sink
+// accepts them, so records that could not be delivered are retried, including
+// after a restart. A record may be delivered more than once if the service
+// stops between the sink accepting it and its removal from the spool.
+package metering
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"strongdm/metrics"
+)
+
+// Period is the length of time records are aggregated over.
+const Period = time.Minute
+
+// Record is the usage of a key under a policy over one period.
+type Record struct {
+	Key    string `json:"key"`
+	Policy string `json:"policy"`
+
+	// Start and End bound the period. Records for the same key, policy and
+	// period should be summed, since a period cut short by a restart is
+	// recorded in parts.
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+
+	// Allowed and Rejected count the requests let through and turned away.
+	Allowed  int64 `json:"allowed"`
+	Rejected int64 `json:"rejected"`
+}
+
+// Batch is a set of records delivered together.
+type Batch struct {
+	// ID identifies the batch across delivery attempts, so that a receiver
+	// can discard duplicates.
+	ID      string
+	Records []Record
+}
+
+// Sink receives usage records.
+type Sink interface {
+	// Send delivers a batch, returning an error if it was not stored.
+	Send(ctx context.Context, b Batch) error
+}
+
+// counts are the requests seen for a key under a policy in a period.
+type counts struct {
+	allowed, rejected int64
+}
+
+// usageKey identifies an aggregate.
+type usageKey struct {
+	key, policy string
+	start       time.Time
+}
+
+// Meter aggregates usage and delivers it to a sink. It is safe for concurrent
+// use.
+type Meter struct {
+	sink  Sink
+	spool string
+
+	// now is replaceable in tests.
+	now func() time.Time
+
+	mu    sync.Mutex
+	usage map[usageKey]*counts
+	// lastID is the ID of the newest spooled batch.
+	lastID int64
+
+	// deliverMu serializes delivery, so that batches are sent in order and
+	// only once at a time.
+	deliverMu sync.Mutex
+}
+
+// New creates a meter delivering to sink, spooling records in the directory
+// spool, which is created if needed. Records spooled by an earlier run are
+// delivered by the first call to Run.
+func New(sink Sink, spool string) (*Meter, error) {
+	if err := os.MkdirAll(spool, 0o755); err != nil {
+		return nil, fmt.Errorf("metering spool: %w", err)
+	}
+	m := &Meter{
+		sink:  sink,
+		spool: spool,
+		now:   time.Now,
+		usage: map[usageKey]*counts{},
+	}
+	batches, err := m.spooled()
+	if err != nil {
+		return nil, fmt.Errorf("metering spool: %w", err)
+	}
+	if len(batches) > 0 {
+		m.lastID, _ = strconv.ParseInt(strings.TrimSuffix(batches[len(batches)-1], ".jsonl"), 10, 64)
+	}
+	metrics.MeteringSpooled.Set(int64(len(batches)))
+	return m, nil
+}
+
+// Count records a request by key under policy.
+func (m *Meter) Count(key, policy string, allowed bool) {
+	k := usageKey{key: key, policy: policy, start: m.now().Truncate(Period)}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, ok := m.usage[k]
+	if !ok {
+		c = &counts{}
+		m.usage[k] = c
+	}
+	if allowed {
+		c.allowed++
+	} else {
+		c.rejected++
+	}
+}
+
+// Run spools and delivers the records of each period once it ends, until ctx
+// is done. Batches that fail to deliver are retried every period, and errors
+// are passed to onError. Callers should Close the meter after the last Count.
+func (m *Meter) Run(ctx context.Context, onError func(error)) {
+	if err := m.deliver(ctx); err != nil {
+		onError(err)
+	}
+	timer := time.NewTimer(m.untilNextPeriod())
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			if err := m.flush(false); err != nil {
+				onError(err)
+			}
+			if err := m.deliver(ctx); err != nil {
+				onError(err)
+			}
+			timer.Reset(m.untilNextPeriod())
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Close spools the records of every period, including the current one, and
+// makes a last attempt to deliver them within ctx. Records that are not
+// delivered stay in the spool for the next run, so only an error spooling
+// them is returned.
+func (m *Meter) Close(ctx context.Context) error {
+	if err := m.flush(true); err != nil {
+		return err
+	}
+	_ = m.deliver(ctx)
+	return nil
+}
+
+// untilNextPeriod returns the time until the current period ends.
+func (m *Meter) untilNextPeriod() time.Duration {
+	now := m.now()
+	return now.Truncate(Period).Add(Period).Sub(now)
+}
+
+// flush moves the records of ended periods, or of every period if all is
+// set, to a new batch in the spool.
+func (m *Meter) flush(all bool) error {
+	current := m.now().Truncate(Period)
+
+	m.mu.Lock()
+	var records []Record
+	for k, c := range m.usage {
+		if !all && !k.start.Before(current) {
+			continue
+		}
+		records = append(records, Record{
+			Key:      k.key,
+			Policy:   k.policy,
+			Start:    k.start,
+			End:      k.start.Add(Period),
+			Allowed:  c.allowed,
+			Rejected: c.rejected,
+		})
+		delete(m.usage, k)
+	}
+	// IDs are nanosecond timestamps, made unique and increasing so that
+	// they sort in the order batches were spooled.
+	id := max(m.now().UnixNano(), m.lastID+1)
+	if len(records) > 0 {
+		m.lastID = id
+	}
+	m.mu.Unlock()
+
+	if len(records) == 0 {
+		return nil
+	}
+	sort.Slice(records, func(i, j int) bool {
+		a, b := records[i], records[j]
+		if !a.Start.Equal(b.Start) {
+			return a.Start.Before(b.Start)
+		}
+		if a.Key != b.Key {
+			return a.Key < b.Key
+		}
+		return a.Policy < b.Policy
+	})
+	if err := m.write(strconv.FormatInt(id, 10), records); err != nil {
+		return fmt.Errorf("metering spool: %w", err)
+	}
+	metrics.MeteringSpooled.Add(1)
+	return nil
+}
+
+// write saves records to a new spool file, replacing it into place
+// atomically so that a crash mid-write cannot leave a partial batch.
+func (m *Meter) write(id string, records []Record) error {
+	tmp := filepath.Join(m.spool, id+".tmp")
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	for _, r := range records {
+		if err := enc.Encode(r); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(m.spool, id+".jsonl"))
+}
+
+// errCorrupt is returned by readBatch for a batch that cannot be parsed.
+var errCorrupt = errors.New("corrupt batch")
+
+// deliver sends the spooled batches to the sink, oldest first, removing each
+// once it is accepted. It stops at the first failure so that batches are
+// delivered in order. Batches that cannot be parsed would never be accepted,
+// so they are renamed with a ".bad" suffix, out of the way, and reported
+// once delivery is done.
+func (m *Meter) deliver(ctx context.Context) error {
+	m.deliverMu.Lock()
+	defer m.deliverMu.Unlock()
+
+	batches, err := m.spooled()
+	if err != nil {
+		return fmt.Errorf("metering spool: %w", err)
+	}
+	var quarantined []error
+	for _, name := range batches {
+		path := filepath.Join(m.spool, name)
+		records, err := readBatch(path)
+		if errors.Is(err, errCorrupt) {
+			if err := os.Rename(path, path+".bad"); err != nil {
+				return fmt.Errorf("metering spool: %w", err)
+			}
+			metrics.MeteringSpooled.Add(-1)
+			quarantined = append(quarantined, fmt.Errorf("metering spool: moved %s to %s.bad: %w", name, name, err))
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("metering spool: %w", err)
+		}
+		id := strings.TrimSuffix(name, ".jsonl")
+		if err := m.sink.Send(ctx, Batch{ID: id, Records: records}); err != nil {
+			return fmt.Errorf("metering batch %s: %w", id, err)
+		}
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("metering spool: %w", err)
+		}
+		metrics.MeteringSpooled.Add(-1)
+		metrics.MeteringRecords.Add(int64(len(records)))
+	}
+	return errors.Join(quarantined...)
+}
+
+// spooled returns the names of the spooled batches, oldest first.
+func (m *Meter) spooled() ([]string, error) {
+	entries, err := os.ReadDir(m.spool)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name(), ".jsonl") {
+			names = append(names, e.Name())
+		}
+	}
+	// IDs have the same number of digits until 2262, so they sort as
+	// strings.
+	sort.Strings(names)
+	return names, nil
+}
+
+func readBatch(path string) ([]Record, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []Record
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var r Record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			return nil, fmt.Errorf("%w: %w", errCorrupt, err)
+		}
+		records = append(records, r)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%w: %w", errCorrupt, err)
+	}
+	return records, nil
+}
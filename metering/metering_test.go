@@ -0,0 +1,150 @@
+package metering
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fakeSink records the batches sent to it, failing while err is set.
+type fakeSink struct {
+	batches []Batch
+	err     error
+}
+
+func (s *fakeSink) Send(ctx context.Context, b Batch) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.batches = append(s.batches, b)
+	return nil
+}
+
+func newMeter(t *testing.T, sink Sink, spool string) (*Meter, *time.Time) {
+	t.Helper()
+	m, err := New(sink, spool)
+	if err != nil {
+		t.Fatalf("New() unexpected error: %v", err)
+	}
+	now := time.Date(2026, 3, 1, 12, 0, 30, 0, time.UTC)
+	m.now = func() time.Time { return now }
+	return m, &now
+}
+
+func TestMeter(t *testing.T) {
+	sink := &fakeSink{}
+	m, now := newMeter(t, sink, t.TempDir())
+
+	m.Count("alice", "default", true)
+	m.Count("alice", "default", false)
+	m.Count("alice", "login", true)
+	*now = now.Add(time.Minute)
+	m.Count("alice", "default", true)
+
+	if err := m.flush(false); err != nil {
+		t.Fatalf("flush() unexpected error: %v", err)
+	}
+	if err := m.deliver(context.Background()); err != nil {
+		t.Fatalf("deliver() unexpected error: %v", err)
+	}
+	if len(sink.batches) != 1 {
+		t.Fatalf("Expected 1 batch for the ended minute, got %d", len(sink.batches))
+	}
+	records := sink.batches[0].Records
+	if len(records) != 2 {
+		t.Fatalf("Expected a record per policy, got %+v", records)
+	}
+	want := Record{
+		Key:      "alice",
+		Policy:   "default",
+		Start:    time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC),
+		End:      time.Date(2026, 3, 1, 12, 1, 0, 0, time.UTC),
+		Allowed:  1,
+		Rejected: 1,
+	}
+	if got := records[0]; got.Key != want.Key || got.Policy != want.Policy || !got.Start.Equal(want.Start) ||
+		!got.End.Equal(want.End) || got.Allowed != want.Allowed || got.Rejected != want.Rejected {
+		t.Errorf("Expected %+v, got %+v", want, got)
+	}
+
+	// Closing spools the current minute too.
+	if err := m.Close(context.Background()); err != nil {
+		t.Fatalf("Close() unexpected error: %v", err)
+	}
+	if len(sink.batches) != 2 || sink.batches[1].Records[0].Allowed != 1 {
+		t.Errorf("Expected the current minute to be delivered on close, got %+v", sink.batches)
+	}
+}
+
+func TestMeter_Redelivery(t *testing.T) {
+	spool := t.TempDir()
+	failing := &fakeSink{err: errors.New("unavailable")}
+	m, now := newMeter(t, failing, spool)
+
+	m.Count("alice", "default", true)
+	*now = now.Add(time.Minute)
+	if err := m.flush(false); err != nil {
+		t.Fatalf("flush() unexpected error: %v", err)
+	}
+	if err := m.deliver(context.Background()); err == nil {
+		t.Fatal("Expected an error from the failing sink")
+	}
+	m.Count("bob", "default", false)
+	if err := m.Close(context.Background()); err != nil {
+		t.Fatalf("Close() unexpected error: %v", err)
+	}
+
+	// A new meter over the same spool delivers what the first one could not,
+	// in order.
+	sink := &fakeSink{}
+	m, _ = newMeter(t, sink, spool)
+	if err := m.deliver(context.Background()); err != nil {
+		t.Fatalf("deliver() unexpected error: %v", err)
+	}
+	if len(sink.batches) != 2 {
+		t.Fatalf("Expected 2 spooled batches, got %d", len(sink.batches))
+	}
+	if sink.batches[0].Records[0].Key != "alice" || sink.batches[1].Records[0].Key != "bob" {
+		t.Errorf("Expected batches in the order they were spooled, got %+v", sink.batches)
+	}
+	if sink.batches[0].ID == sink.batches[1].ID {
+		t.Error("Expected each batch to have its own ID")
+	}
+
+	batches, _ := m.spooled()
+	if len(batches) != 0 {
+		t.Errorf("Expected delivered batches to leave the spool, got %v", batches)
+	}
+}
+
+func TestMeter_CorruptBatch(t *testing.T) {
+	spool := t.TempDir()
+	if err := os.WriteFile(filepath.Join(spool, "1000000000000000000.jsonl"), []byte("{not json\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	sink := &fakeSink{}
+	m, now := newMeter(t, sink, spool)
+
+	m.Count("alice", "default", true)
+	*now = now.Add(time.Minute)
+	if err := m.flush(false); err != nil {
+		t.Fatalf("flush() unexpected error: %v", err)
+	}
+	if err := m.deliver(context.Background()); err == nil {
+		t.Error("Expected the corrupt batch to be reported")
+	}
+
+	// The batch after the corrupt one is still delivered.
+	if len(sink.batches) != 1 || sink.batches[0].Records[0].Key != "alice" {
+		t.Errorf("Expected delivery to continue past the corrupt batch, got %+v", sink.batches)
+	}
+	if _, err := os.Stat(filepath.Join(spool, "1000000000000000000.jsonl.bad")); err != nil {
+		t.Errorf("Expected the corrupt batch to be set aside, got %v", err)
+	}
+	if batches, _ := m.spooled(); len(batches) != 0 {
+		t.Errorf("Expected the spool to be empty, got %v", batches)
+	}
+}
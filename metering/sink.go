@@ -0,0 +1,198 @@
+package metering
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Formats accepted by NewFile.
+const (
+	FormatJSONLines = "jsonl"
+	FormatCSV       = "csv"
+)
+
+// csvHeader is the first line of every CSV file.
+var csvHeader = []string{"key", "policy", "start", "end", "allowed", "rejected"}
+
+// File is a Sink that appends records to a local file, rotating it when it
+// grows too large.
+type File struct {
+	path     string
+	format   string
+	maxBytes int64
+	maxFiles int
+
+	// now is replaceable in tests.
+	now func() time.Time
+
+	mu sync.Mutex
+}
+
+// NewFile creates a sink appending to path in the given format. Once the file
+// reaches maxBytes, it is renamed with a timestamp suffix and a new one is
+// started; zero disables rotation. Only the newest maxFiles rotated files are
+// kept, or all of them if maxFiles is zero.
+func NewFile(path, format string, maxBytes int64, maxFiles int) (*File, error) {
+	if format != FormatJSONLines && format != FormatCSV {
+		return nil, fmt.Errorf("unknown metering format %q, must be %q or %q", format, FormatJSONLines, FormatCSV)
+	}
+	if maxBytes < 0 || maxFiles < 0 {
+		return nil, fmt.Errorf("metering file limits must not be negative")
+	}
+	return &File{
+		path:     path,
+		format:   format,
+		maxBytes: maxBytes,
+		maxFiles: maxFiles,
+		now:      time.Now,
+	}, nil
+}
+
+// Send appends the batch to the file and syncs it to disk.
+func (s *File) Send(ctx context.Context, b Batch) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	size := int64(0)
+	if fi, err := os.Stat(s.path); err == nil {
+		size = fi.Size()
+	}
+	if s.maxBytes > 0 && size >= s.maxBytes {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+		size = 0
+	}
+
+	var buf bytes.Buffer
+	if err := s.encode(&buf, b.Records, size == 0); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// encode writes records in the sink's format, preceded by the CSV header if
+// the file is new.
+func (s *File) encode(w io.Writer, records []Record, header bool) error {
+	if s.format == FormatJSONLines {
+		enc := json.NewEncoder(w)
+		for _, r := range records {
+			if err := enc.Encode(r); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	cw := csv.NewWriter(w)
+	if header {
+		if err := cw.Write(csvHeader); err != nil {
+			return err
+		}
+	}
+	for _, r := range records {
+		if err := cw.Write([]string{
+			r.Key,
+			r.Policy,
+			r.Start.UTC().Format(time.RFC3339),
+			r.End.UTC().Format(time.RFC3339),
+			strconv.FormatInt(r.Allowed, 10),
+			strconv.FormatInt(r.Rejected, 10),
+		}); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// rotate renames the current file with a timestamp suffix and removes the
+// oldest rotated files beyond maxFiles.
+func (s *File) rotate() error {
+	rotated := s.path + "." + s.now().UTC().Format("20060102T150405.000000000Z")
+	if err := os.Rename(s.path, rotated); err != nil {
+		return err
+	}
+	if s.maxFiles == 0 {
+		return nil
+	}
+	old, err := filepath.Glob(s.path + ".*Z")
+	if err != nil {
+		return err
+	}
+	// The timestamps sort in the order the files were rotated.
+	sort.Strings(old)
+	for len(old) > s.maxFiles {
+		if err := os.Remove(old[0]); err != nil {
+			return err
+		}
+		old = old[1:]
+	}
+	return nil
+}
+
+// Webhook is a Sink that POSTs records to a URL as JSON. The batch ID is sent
+// in the Idempotency-Key header so the receiver can discard redeliveries.
+type Webhook struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhook creates a sink posting to url, giving up on a request after
+// timeout.
+func NewWebhook(url string, timeout time.Duration) *Webhook {
+	return &Webhook{
+		url:    url,
+		client: &http.Client{Timeout: timeout},
+	}
+}
+
+// Send posts the batch as {"records": [...]}. Any status other than 2xx is an
+// error.
+func (s *Webhook) Send(ctx context.Context, b Batch) error {
+	body, err := json.Marshal(struct {
+		Records []Record `json:"records"`
+	}{b.Records})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", b.ID)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package metering
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testRecord = Record{
+	Key:     "alice",
+	Policy:  "default",
+	Start:   time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC),
+	End:     time.Date(2026, 3, 1, 12, 1, 0, 0, time.UTC),
+	Allowed: 5,
+}
+
+func TestFile_CSV(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usage.csv")
+	s, err := NewFile(path, FormatCSV, 0, 0)
+	if err != nil {
+		t.Fatalf("NewFile() unexpected error: %v", err)
+	}
+	for range 2 {
+		if err := s.Send(context.Background(), Batch{Records: []Record{testRecord}}); err != nil {
+			t.Fatalf("Send() unexpected error: %v", err)
+		}
+	}
+
+	data, _ := os.ReadFile(path)
+	want := "key,policy,start,end,allowed,rejected\n" +
+		"alice,default,2026-03-01T12:00:00Z,2026-03-01T12:01:00Z,5,0\n" +
+		"alice,default,2026-03-01T12:00:00Z,2026-03-01T12:01:00Z,5,0\n"
+	if string(data) != want {
+		t.Errorf("Expected a single header followed by the records, got:\n%s", data)
+	}
+}
+
+func TestFile_Rotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usage.jsonl")
+	s, err := NewFile(path, FormatJSONLines, 1, 2)
+	if err != nil {
+		t.Fatalf("NewFile() unexpected error: %v", err)
+	}
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+
+	for range 4 {
+		if err := s.Send(context.Background(), Batch{Records: []Record{testRecord}}); err != nil {
+			t.Fatalf("Send() unexpected error: %v", err)
+		}
+		now = now.Add(time.Second)
+	}
+
+	rotated, _ := filepath.Glob(path + ".*")
+	if len(rotated) != 2 {
+		t.Fatalf("Expected 2 rotated files to be kept, got %v", rotated)
+	}
+	if !strings.HasSuffix(rotated[0], ".20260301T120002.000000000Z") {
+		t.Errorf("Expected the oldest rotated files to be removed, got %v", rotated)
+	}
+	var r Record
+	data, _ := os.ReadFile(path)
+	if err := json.Unmarshal(data, &r); err != nil || r.Key != "alice" {
+		t.Errorf("Expected the current file to hold the last record, got %q", data)
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	var got struct {
+		Records []Record `json:"records"`
+	}
+	var idempotencyKey string
+	status := http.StatusNoContent
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		idempotencyKey = r.Header.Get("Idempotency-Key")
+		_ = json.NewDecoder(r.Body).Decode(&got)
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	s := NewWebhook(srv.URL, time.Second)
+	if err := s.Send(context.Background(), Batch{ID: "42", Records: []Record{testRecord}}); err != nil {
+		t.Fatalf("Send() unexpected error: %v", err)
+	}
+	if idempotencyKey != "42" {
+		t.Errorf("Expected the batch ID as the idempotency key, got %q", idempotencyKey)
+	}
+	if len(got.Records) != 1 || got.Records[0].Allowed != 5 {
+		t.Errorf("Expected the records in the body, got %+v", got.Records)
+	}
+
+	status = http.StatusInternalServerError
+	if err := s.Send(context.Background(), Batch{ID: "43", Records: []Record{testRecord}}); err == nil {
+		t.Error("Expected an error when the webhook fails")
+	}
+}
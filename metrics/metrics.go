@@ -0,0 +1,114 @@
+// Package metrics exposes service counters through the standard library's
+// expvar package, which serves them as JSON at /debug/vars.
+package metrics
+
+import "expvar"
+
+// Outcomes recorded in Decisions.
+const (
+	// OutcomeAllowed is a request that was admitted by the rate limiter.
+	OutcomeAllowed = "allowed"
+
+	// OutcomeRejected is a request that was rejected by the rate limiter.
+	OutcomeRejected = "rejected"
+
+	// OutcomeDelayed is a request that was held until its bucket had room,
+	// and then admitted.
+	OutcomeDelayed = "delayed"
+
+	// OutcomeOverloaded is a request that was turned away because the
+	// adaptive concurrency limit was reached.
+	OutcomeOverloaded = "overloaded"
+
+	// OutcomeShed is a request that was turned away because its priority
+	// tier was being shed under load.
+	OutcomeShed = "shed"
+
+	// OutcomeOversized is a request that cost more tokens than its bucket
+	// holds, so it could never be allowed.
+	OutcomeOversized = "oversized"
+
+	// OutcomeQuotaExceeded is a request that was within its rate limit but
+	// over its policy's calendar quota.
+	OutcomeQuotaExceeded = "quota_exceeded"
+
+	// OutcomeAllowlisted is a request that bypassed the rate limiter because
+	// it matched the allowlist.
+	OutcomeAllowlisted = "allowlisted"
+
+	// OutcomeDenylisted is a request that was refused because it matched the
+	// denylist.
+	OutcomeDenylisted = "denylisted"
+
+	// OutcomeBanned is a request that was refused because its key is in the
+	// penalty box.
+	OutcomeBanned = "banned"
+
+	// OutcomeWouldReject is a request that exceeded an observe-only policy
+	// and was allowed anyway.
+	OutcomeWouldReject = "would_reject"
+
+	// OutcomeDisagreed is a shadow policy decision that differed from the
+	// enforced decision for the same request.
+	OutcomeDisagreed = "disagreed"
+)
+
+var (
+	// Decisions counts rate limit decisions by outcome.
+	Decisions = expvar.NewMap("decisions")
+
+	// ShadowDecisions counts shadow policy decisions by outcome.
+	ShadowDecisions = expvar.NewMap("shadow_decisions")
+
+	// RLSDecisions counts Envoy rate limit service descriptor decisions by
+	// outcome.
+	RLSDecisions = expvar.NewMap("rls_decisions")
+
+	// APIDecisions counts decision API checks by outcome.
+	APIDecisions = expvar.NewMap("api_decisions")
+
+	// ConcurrencyLimit is the current adaptive concurrency limit.
+	ConcurrencyLimit = expvar.NewInt("concurrency_limit")
+
+	// InFlight is the number of requests holding an adaptive concurrency
+	// slot.
+	InFlight = expvar.NewInt("in_flight")
+
+	// Load is the load last measured by the load shedder, where 1 is full
+	// load.
+	Load = expvar.NewFloat("load")
+
+	// ShedPriorities counts requests shed by the load shedder by priority.
+	ShedPriorities = expvar.NewMap("shed_priorities")
+
+	// BreakerStates holds the state of each upstream's circuit breaker.
+	BreakerStates = expvar.NewMap("breaker_states")
+
+	// BreakerTrips counts circuit breaker openings by upstream.
+	BreakerTrips = expvar.NewMap("breaker_trips")
+
+	// BreakerRejections counts requests turned away by an open circuit
+	// breaker, by upstream.
+	BreakerRejections = expvar.NewMap("breaker_rejections")
+
+	// BansStarted counts bans issued by the penalty box.
+	BansStarted = expvar.NewInt("bans_started")
+
+	// MeteringSpooled is the number of usage record batches waiting to be
+	// delivered.
+	MeteringSpooled = expvar.NewInt("metering_spooled")
+
+	// MeteringRecords counts usage records delivered.
+	MeteringRecords = expvar.NewInt("metering_records")
+
+	// EventsSent counts notification events delivered, by type.
+	EventsSent = expvar.NewMap("events_sent")
+
+	// EventsSuppressed counts notification events not sent because the
+	// same event was sent recently, by type.
+	EventsSuppressed = expvar.NewMap("events_suppressed")
+
+	// EventsDropped counts notification events lost to the rate limit, a
+	// full queue or a failed delivery, by type.
+	EventsDropped = expvar.NewMap("events_dropped")
+)
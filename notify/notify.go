@@ -0,0 +1,356 @@
+// Package notify sends events to a sink when keys change state: when a key
+// starts being throttled and when it recovers, when a ban starts, and when a
+// key's quota usage crosses a threshold.
+//
+// Events are deduplicated and rate limited, so a single key flapping in and
+// out of its limit cannot flood the sink. Delivery is best effort: events are
+// queued and sent in the background, and dropped if the queue is full or the
+// sink fails.
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"strongdm/ban"
+	"strongdm/bucket"
+	"strongdm/metrics"
+	"strongdm/quota"
+)
+
+// Type is the kind of an event.
+type Type string
+
+// Event types.
+const (
+	// TypeThrottled is sent when a key is first rejected by its rate limit.
+	TypeThrottled Type = "throttled"
+
+	// TypeRecovered is sent when a throttled key has gone RecoverAfter
+	// without being rejected.
+	TypeRecovered Type = "recovered"
+
+	// TypeBanStarted is sent when the penalty box bans a key.
+	TypeBanStarted Type = "ban_started"
+
+	// TypeQuotaThreshold is sent when a key's usage of a quota reaches
+	// QuotaPercent of the limit, once per quota period.
+	TypeQuotaThreshold Type = "quota_threshold"
+)
+
+// Types lists every event type.
+var Types = []Type{TypeThrottled, TypeRecovered, TypeBanStarted, TypeQuotaThreshold}
+
+// Event is a change in a key's state.
+type Event struct {
+	Type   Type      `json:"type"`
+	Key    string    `json:"key"`
+	Policy string    `json:"policy,omitempty"`
+	Time   time.Time `json:"time"`
+
+	// Until and Offense describe a ban.
+	Until   time.Time `json:"until,omitzero"`
+	Offense int       `json:"offense,omitempty"`
+
+	// Quota is the usage that reached the threshold.
+	Quota *quota.Usage `json:"quota,omitempty"`
+}
+
+// Sink receives events.
+type Sink interface {
+	Send(ctx context.Context, e Event) error
+}
+
+// Config describes which events are sent and how often.
+type Config struct {
+	// Types are the event types sent. Empty means all of them.
+	Types []Type
+
+	// QuotaPercent is the percentage of a quota's limit at which
+	// TypeQuotaThreshold is sent. Zero disables the event.
+	QuotaPercent int
+
+	// RecoverAfter is how long a throttled key must go without a rejection
+	// to recover.
+	RecoverAfter time.Duration
+
+	// DedupWindow suppresses an event if one of the same type was sent for
+	// the same key and policy within it.
+	DedupWindow time.Duration
+
+	// MaxPerMinute limits the events sent across all keys. Events beyond it
+	// are dropped.
+	MaxPerMinute int64
+
+	// QueueSize is the number of events waiting to be sent before further
+	// ones are dropped. Zero means DefaultQueueSize.
+	QueueSize int
+}
+
+// DefaultQueueSize is the queue size used if Config.QueueSize is zero.
+const DefaultQueueSize = 100
+
+// Validate checks that the configuration is well formed.
+func (c Config) Validate() error {
+	for _, t := range c.Types {
+		if !slices.Contains(Types, t) {
+			return fmt.Errorf("unknown event type %q", t)
+		}
+	}
+	if c.QuotaPercent < 0 || c.QuotaPercent > 100 {
+		return fmt.Errorf("quota percent must be between 0 and 100")
+	}
+	if c.RecoverAfter <= 0 {
+		return fmt.Errorf("recover after must be positive")
+	}
+	if c.DedupWindow < 0 {
+		return fmt.Errorf("dedup window must not be negative")
+	}
+	if c.MaxPerMinute < 1 {
+		return fmt.Errorf("max events per minute must be at least 1")
+	}
+	if c.QueueSize < 0 {
+		return fmt.Errorf("queue size must not be negative")
+	}
+	return nil
+}
+
+// subject is a key under a policy.
+type subject struct {
+	key, policy string
+}
+
+// sentKey identifies events that are deduplicated together.
+type sentKey struct {
+	subject
+	typ Type
+}
+
+// Notifier tracks key state and sends events on transitions. It is safe for
+// concurrent use.
+type Notifier struct {
+	sink  Sink
+	cfg   Config
+	queue chan Event
+	// done is closed when Run returns.
+	done chan struct{}
+
+	// now is replaceable in tests.
+	now func() time.Time
+
+	mu sync.Mutex
+	// throttled holds the throttled keys.
+	throttled map[subject]throttle
+	// quotaNotified holds the end of the quota period each key has been
+	// notified for.
+	quotaNotified map[subject]time.Time
+	// sent holds when each event was last sent, for deduplication.
+	sent map[sentKey]time.Time
+	// limit rate limits events across all keys.
+	limit bucket.Bucket
+}
+
+// throttle is the state of a throttled key.
+type throttle struct {
+	// last is the time of the key's last rejection.
+	last time.Time
+	// notified reports whether TypeThrottled was queued for the key, and so
+	// whether TypeRecovered should be when it recovers.
+	notified bool
+}
+
+// New creates a notifier sending to sink. Events are only sent while Run is
+// running.
+func New(sink Sink, cfg Config) (*Notifier, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	size := cfg.QueueSize
+	if size == 0 {
+		size = DefaultQueueSize
+	}
+	return &Notifier{
+		sink:          sink,
+		cfg:           cfg,
+		queue:         make(chan Event, size),
+		done:          make(chan struct{}),
+		now:           time.Now,
+		throttled:     map[subject]throttle{},
+		quotaNotified: map[subject]time.Time{},
+		sent:          map[sentKey]time.Time{},
+	}, nil
+}
+
+// Rejected records that key was rejected by policy's rate limit, sending
+// TypeThrottled if it was not already throttled.
+func (n *Notifier) Rejected(key, policy string) {
+	s := subject{key, policy}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	now := n.now()
+	t, ok := n.throttled[s]
+	if !ok {
+		t.notified = n.emit(Event{Type: TypeThrottled, Key: key, Policy: policy, Time: now})
+	}
+	t.last = now
+	n.throttled[s] = t
+}
+
+// BanStarted sends TypeBanStarted for b.
+func (n *Notifier) BanStarted(b ban.Ban) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.emit(Event{Type: TypeBanStarted, Key: b.Key, Time: n.now(), Until: b.Until, Offense: b.Offense})
+}
+
+// QuotaUsed records key's usage of policy's quota, sending
+// TypeQuotaThreshold the first time in a period that it reaches the
+// threshold.
+func (n *Notifier) QuotaUsed(key, policy string, u quota.Usage) {
+	if n.cfg.QuotaPercent == 0 || u.Used*100 < u.Limit*int64(n.cfg.QuotaPercent) {
+		return
+	}
+	s := subject{key, policy}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.quotaNotified[s].Equal(u.ResetAt) {
+		return
+	}
+	n.quotaNotified[s] = u.ResetAt
+	n.emit(Event{Type: TypeQuotaThreshold, Key: key, Policy: policy, Time: n.now(), Quota: &u})
+}
+
+// emit queues e unless its type is not sent, it duplicates a recent event,
+// or the rate limit is reached, and reports whether it was queued. The caller
+// must hold mu.
+func (n *Notifier) emit(e Event) bool {
+	if len(n.cfg.Types) > 0 && !slices.Contains(n.cfg.Types, e.Type) {
+		return false
+	}
+	k := sentKey{subject{e.Key, e.Policy}, e.Type}
+	if last, ok := n.sent[k]; ok && e.Time.Sub(last) < n.cfg.DedupWindow {
+		metrics.EventsSuppressed.Add(string(e.Type), 1)
+		return false
+	}
+	if n.limit.CountAt(e.Time) >= n.cfg.MaxPerMinute {
+		metrics.EventsDropped.Add(string(e.Type), 1)
+		return false
+	}
+	select {
+	case n.queue <- e:
+		n.sent[k] = e.Time
+		n.limit = n.limit.Plus(e.Time, n.cfg.MaxPerMinute, 1)
+		return true
+	default:
+		metrics.EventsDropped.Add(string(e.Type), 1)
+		return false
+	}
+}
+
+// sweep sends TypeRecovered for throttled keys that have not been rejected
+// for RecoverAfter, and forgets state that no longer matters.
+func (n *Notifier) sweep() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	now := n.now()
+	for s, t := range n.throttled {
+		if now.Sub(t.last) < n.cfg.RecoverAfter {
+			continue
+		}
+		delete(n.throttled, s)
+		// A recovery is only news to a sink that heard about the throttling.
+		if t.notified {
+			n.emit(Event{Type: TypeRecovered, Key: s.key, Policy: s.policy, Time: now})
+		}
+	}
+	for s, resetAt := range n.quotaNotified {
+		if !now.Before(resetAt) {
+			delete(n.quotaNotified, s)
+		}
+	}
+	for k, last := range n.sent {
+		if now.Sub(last) >= n.cfg.DedupWindow {
+			delete(n.sent, k)
+		}
+	}
+}
+
+// sweepInterval is how often recovered keys are looked for.
+const sweepInterval = time.Second
+
+// Run sends queued events and looks for recovered keys until ctx is done,
+// passing delivery errors to onError. It must only be called once. Callers
+// should Close the notifier once ctx is done to send the events still queued.
+func (n *Notifier) Run(ctx context.Context, onError func(error)) {
+	defer close(n.done)
+
+	go func() {
+		ticker := time.NewTicker(sweepInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				n.sweep()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	// An event being sent when ctx is done is not abandoned, so that Close
+	// does not find the sink mid-write.
+	sendCtx := context.WithoutCancel(ctx)
+	for {
+		select {
+		case e := <-n.queue:
+			if err := n.send(sendCtx, e); err != nil {
+				onError(err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Close waits for Run to return, then sends the events still queued until
+// ctx is done. It returns the errors of any that could not be sent. The sink
+// may be closed once Close returns.
+func (n *Notifier) Close(ctx context.Context) error {
+	select {
+	case <-n.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	var errs []error
+	for {
+		select {
+		case e := <-n.queue:
+			if err := n.send(ctx, e); err != nil {
+				errs = append(errs, err)
+			}
+		default:
+			return errors.Join(errs...)
+		}
+	}
+}
+
+// send delivers e to the sink and counts the outcome.
+func (n *Notifier) send(ctx context.Context, e Event) error {
+	if err := n.sink.Send(ctx, e); err != nil {
+		metrics.EventsDropped.Add(string(e.Type), 1)
+		return fmt.Errorf("%s event for %q: %w", e.Type, e.Key, err)
+	}
+	metrics.EventsSent.Add(string(e.Type), 1)
+	return nil
+}
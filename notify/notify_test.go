@@ -0,0 +1,197 @@
+package notify
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"strongdm/ban"
+	"strongdm/quota"
+)
+
+func newNotifier(t *testing.T, cfg Config) (*Notifier, *time.Time) {
+	t.Helper()
+	n, err := New(nil, cfg)
+	if err != nil {
+		t.Fatalf("New() unexpected error: %v", err)
+	}
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	n.now = func() time.Time { return now }
+	return n, &now
+}
+
+// queued returns the types of the events waiting to be sent.
+func queued(n *Notifier) []Type {
+	var types []Type
+	for {
+		select {
+		case e := <-n.queue:
+			types = append(types, e.Type)
+		default:
+			return types
+		}
+	}
+}
+
+func TestNotifier_Transitions(t *testing.T) {
+	n, now := newNotifier(t, Config{RecoverAfter: time.Minute, MaxPerMinute: 100})
+
+	n.Rejected("alice", "default")
+	*now = now.Add(30 * time.Second)
+	n.Rejected("alice", "default")
+	n.sweep()
+	if got := queued(n); len(got) != 1 || got[0] != TypeThrottled {
+		t.Fatalf("Expected a single throttled event, got %v", got)
+	}
+
+	*now = now.Add(time.Minute)
+	n.sweep()
+	if got := queued(n); len(got) != 1 || got[0] != TypeRecovered {
+		t.Fatalf("Expected a recovered event after a quiet minute, got %v", got)
+	}
+
+	n.Rejected("alice", "default")
+	if got := queued(n); len(got) != 1 || got[0] != TypeThrottled {
+		t.Errorf("Expected a throttled event after recovering, got %v", got)
+	}
+}
+
+func TestNotifier_Dedup(t *testing.T) {
+	n, now := newNotifier(t, Config{RecoverAfter: time.Second, DedupWindow: 10 * time.Minute, MaxPerMinute: 100})
+
+	// A key flapping in and out of its limit is only reported once per
+	// window.
+	for range 3 {
+		n.Rejected("alice", "default")
+		*now = now.Add(time.Second)
+		n.sweep()
+	}
+	if got := queued(n); len(got) != 2 {
+		t.Fatalf("Expected one throttled and one recovered event, got %v", got)
+	}
+
+	*now = now.Add(10 * time.Minute)
+	n.sweep()
+	n.Rejected("alice", "default")
+	if got := queued(n); len(got) != 1 {
+		t.Errorf("Expected events again after the dedup window, got %v", got)
+	}
+}
+
+func TestNotifier_RateLimit(t *testing.T) {
+	n, now := newNotifier(t, Config{RecoverAfter: time.Minute, MaxPerMinute: 2})
+
+	n.Rejected("alice", "default")
+	n.BanStarted(ban.Ban{Key: "bob"})
+	n.Rejected("carol", "default")
+	if got := queued(n); len(got) != 2 {
+		t.Fatalf("Expected events beyond the rate limit to be dropped, got %v", got)
+	}
+
+	*now = now.Add(30 * time.Second)
+	n.Rejected("dave", "default")
+	if got := queued(n); len(got) != 1 {
+		t.Errorf("Expected the rate limit to refill, got %v", got)
+	}
+}
+
+func TestNotifier_RecoveredOnlyIfThrottledSent(t *testing.T) {
+	n, now := newNotifier(t, Config{RecoverAfter: time.Minute, MaxPerMinute: 1})
+
+	n.BanStarted(ban.Ban{Key: "bob"})
+	n.Rejected("alice", "default")
+	if got := queued(n); len(got) != 1 || got[0] != TypeBanStarted {
+		t.Fatalf("Expected the throttled event to be rate limited, got %v", got)
+	}
+
+	*now = now.Add(time.Minute)
+	n.sweep()
+	if got := queued(n); len(got) != 0 {
+		t.Errorf("Expected no recovered event for a key whose throttled event was not sent, got %v", got)
+	}
+}
+
+// recorder is a Sink that keeps the events sent to it.
+type recorder struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (r *recorder) Send(ctx context.Context, e Event) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, e)
+	return nil
+}
+
+func TestNotifier_Close(t *testing.T) {
+	sink := &recorder{}
+	n, err := New(sink, Config{RecoverAfter: time.Minute, MaxPerMinute: 100})
+	if err != nil {
+		t.Fatalf("New() unexpected error: %v", err)
+	}
+	n.Rejected("alice", "default")
+	n.Rejected("bob", "default")
+	n.BanStarted(ban.Ban{Key: "carol"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	go n.Run(ctx, func(err error) { t.Errorf("Run() unexpected error: %v", err) })
+	if err := n.Close(context.Background()); err != nil {
+		t.Fatalf("Close() unexpected error: %v", err)
+	}
+
+	if len(sink.events) != 3 {
+		t.Errorf("Expected Close to send the queued events, got %v", sink.events)
+	}
+}
+
+func TestNotifier_Quota(t *testing.T) {
+	n, _ := newNotifier(t, Config{
+		Types:        []Type{TypeQuotaThreshold},
+		QuotaPercent: 80,
+		RecoverAfter: time.Minute,
+		MaxPerMinute: 100,
+	})
+	resetAt := time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC)
+
+	n.Rejected("alice", "default")
+	n.QuotaUsed("alice", "default", quota.Usage{Limit: 100, Used: 79, ResetAt: resetAt})
+	n.QuotaUsed("alice", "default", quota.Usage{Limit: 100, Used: 80, ResetAt: resetAt})
+	n.QuotaUsed("alice", "default", quota.Usage{Limit: 100, Used: 90, ResetAt: resetAt})
+	if got := queued(n); len(got) != 1 || got[0] != TypeQuotaThreshold {
+		t.Fatalf("Expected only a single quota event, got %v", got)
+	}
+
+	n.QuotaUsed("alice", "default", quota.Usage{Limit: 100, Used: 80, ResetAt: resetAt.AddDate(0, 1, 0)})
+	if got := queued(n); len(got) != 1 {
+		t.Errorf("Expected a quota event in the next period, got %v", got)
+	}
+}
+
+func TestConfig_Validate(t *testing.T) {
+	valid := Config{RecoverAfter: time.Minute, MaxPerMinute: 60}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("Validate() unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(*Config)
+	}{
+		{"type", func(c *Config) { c.Types = []Type{"slow"} }},
+		{"quota percent", func(c *Config) { c.QuotaPercent = 120 }},
+		{"recover after", func(c *Config) { c.RecoverAfter = 0 }},
+		{"rate", func(c *Config) { c.MaxPerMinute = 0 }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := valid
+			tt.modify(&c)
+			if err := c.Validate(); err == nil {
+				t.Error("Expected an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package notify
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWebhook_Signature(t *testing.T) {
+	var body []byte
+	var signature string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		signature = r.Header.Get(SignatureHeader)
+	}))
+	defer srv.Close()
+
+	s := NewWebhook(srv.URL, "s3cret", time.Second)
+	if err := s.Send(context.Background(), Event{Type: TypeThrottled, Key: "alice"}); err != nil {
+		t.Fatalf("Send() unexpected error: %v", err)
+	}
+	if want := Sign([]byte("s3cret"), body); signature != want {
+		t.Errorf("Expected signature %q, got %q", want, signature)
+	}
+	if Sign([]byte("other"), body) == signature {
+		t.Error("Expected the signature to depend on the secret")
+	}
+}
+
+func TestFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	s, err := OpenFile(path)
+	if err != nil {
+		t.Fatalf("OpenFile() unexpected error: %v", err)
+	}
+	e := Event{Type: TypeRecovered, Key: "alice", Policy: "default", Time: time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)}
+	if err := s.Send(context.Background(), e); err != nil {
+		t.Fatalf("Send() unexpected error: %v", err)
+	}
+	s.Close()
+
+	data, _ := os.ReadFile(path)
+	want := `{"type":"recovered","key":"alice","policy":"default","time":"2026-03-01T12:00:00Z"}` + "\n"
+	if string(data) != want {
+		t.Errorf("Expected %s, got %s", want, data)
+	}
+}
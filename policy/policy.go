@@ -0,0 +1,153 @@
+// Package policy describes the rate limits applied to requests.
+package policy
+
+import (
+	"fmt"
+	"time"
+
+	"strongdm/quota"
+)
+
+// Mode controls whether a policy's decisions are enforced.
+type Mode string
+
+const (
+	// ModeEnforce rejects requests that exceed the limit.
+	ModeEnforce Mode = "enforce"
+
+	// ModeObserve evaluates the limit and records would-be rejections, but
+	// allows every request. It is used to roll out new limits safely.
+	ModeObserve Mode = "observe"
+)
+
+// DefaultMaxQueued is the number of requests per key held by a policy with a
+// MaxDelay if MaxQueued is not set.
+const DefaultMaxQueued = 10
+
+// DefaultName is the name of the policy used when none is configured.
+const DefaultName = "default"
+
+// DefaultLimitPerWindow is the limit of the default policy.
+const DefaultLimitPerWindow = 120
+
+// Policy is a named rate limit.
+type Policy struct {
+	// Name identifies the policy in logs and metrics.
+	Name string `json:"name"`
+
+	// LimitPerWindow is the steady state number of calls allowed per
+	// bucket.WindowDuration. Zero means unlimited.
+	LimitPerWindow int64 `json:"limitPerWindow"`
+
+	// Mode controls whether rejections are enforced. The zero value enforces.
+	Mode Mode `json:"mode,omitempty"`
+
+	// MaxDelay, if positive, holds requests that would be rejected until
+	// their bucket has room, for up to MaxDelay, instead of rejecting them
+	// immediately.
+	MaxDelay Duration `json:"maxDelay,omitempty"`
+
+	// MaxQueued is the most requests per key held at once under MaxDelay.
+	// Requests beyond it are rejected immediately. Zero means
+	// DefaultMaxQueued.
+	MaxQueued int `json:"maxQueued,omitempty"`
+
+	// Schedules replace LimitPerWindow while they are active. The first
+	// active one wins.
+	Schedules []Schedule `json:"schedules,omitempty"`
+
+	// Quota limits the tokens spent per calendar day or month, on top of
+	// LimitPerWindow.
+	Quota quota.Quota `json:"quota,omitzero"`
+}
+
+// At returns the policy in effect at t, with LimitPerWindow set by the first
+// active schedule, and that schedule's name. The name is empty if no
+// schedule is active.
+func (p Policy) At(t time.Time) (Policy, string) {
+	for _, s := range p.Schedules {
+		if s.Active(t) {
+			p.LimitPerWindow = s.LimitPerWindow
+			return p, s.Name
+		}
+	}
+	return p, ""
+}
+
+// Queued reports whether requests that would be rejected are held, and if so
+// for how long and how many per key.
+func (p Policy) Queued() (maxDelay time.Duration, maxQueued int, ok bool) {
+	if p.MaxDelay <= 0 {
+		return 0, 0, false
+	}
+	maxQueued = p.MaxQueued
+	if maxQueued == 0 {
+		maxQueued = DefaultMaxQueued
+	}
+	return time.Duration(p.MaxDelay), maxQueued, true
+}
+
+// Default returns the built-in policy.
+func Default() Policy {
+	return Policy{
+		Name:           DefaultName,
+		LimitPerWindow: DefaultLimitPerWindow,
+		Mode:           ModeEnforce,
+	}
+}
+
+// Enforced reports whether rejections under this policy are enforced.
+func (p Policy) Enforced() bool {
+	return p.Mode != ModeObserve
+}
+
+// Validate checks that the policy is well formed.
+func (p Policy) Validate() error {
+	if p.Name == "" {
+		return fmt.Errorf("policy name must not be empty")
+	}
+	if p.LimitPerWindow < 0 {
+		return fmt.Errorf("policy %q: limit must not be negative", p.Name)
+	}
+	switch p.Mode {
+	case "", ModeEnforce, ModeObserve:
+	default:
+		return fmt.Errorf("policy %q: unknown mode %q", p.Name, p.Mode)
+	}
+	if p.MaxDelay < 0 {
+		return fmt.Errorf("policy %q: max delay must not be negative", p.Name)
+	}
+	if p.MaxQueued < 0 {
+		return fmt.Errorf("policy %q: max queued must not be negative", p.Name)
+	}
+	// Validating through the slice keeps the parsed schedules in p's
+	// Schedules, which copies of p share.
+	for i := range p.Schedules {
+		if err := p.Schedules[i].Validate(); err != nil {
+			return fmt.Errorf("policy %q: %w", p.Name, err)
+		}
+	}
+	if err := p.Quota.Validate(); err != nil {
+		return fmt.Errorf("policy %q: %w", p.Name, err)
+	}
+	return nil
+}
+
+// Duration is a time.Duration that is written to and read from JSON in
+// time.ParseDuration format, such as "1m30s".
+type Duration time.Duration
+
+// MarshalText implements encoding.TextMarshaler.
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (d *Duration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
@@ -0,0 +1,122 @@
+package policy
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPolicy_Enforced(t *testing.T) {
+	tests := []struct {
+		mode     Mode
+		expected bool
+	}{
+		{mode: "", expected: true},
+		{mode: ModeEnforce, expected: true},
+		{mode: ModeObserve, expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.mode), func(t *testing.T) {
+			p := Policy{Name: "p", LimitPerWindow: 60, Mode: tt.mode}
+			if p.Enforced() != tt.expected {
+				t.Errorf("Enforced() = %v, expected %v", p.Enforced(), tt.expected)
+			}
+		})
+	}
+}
+
+func TestPolicy_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  Policy
+		wantErr bool
+	}{
+		{name: "default", policy: Default()},
+		{name: "unlimited", policy: Policy{Name: "p"}},
+		{name: "missing name", policy: Policy{LimitPerWindow: 60}, wantErr: true},
+		{name: "negative limit", policy: Policy{Name: "p", LimitPerWindow: -1}, wantErr: true},
+		{name: "unknown mode", policy: Policy{Name: "p", Mode: "maybe"}, wantErr: true},
+		{name: "queued", policy: Policy{Name: "p", MaxDelay: Duration(time.Second), MaxQueued: 5}},
+		{name: "negative max delay", policy: Policy{Name: "p", MaxDelay: -1}, wantErr: true},
+		{name: "negative max queued", policy: Policy{Name: "p", MaxQueued: -1}, wantErr: true},
+		{name: "schedule", policy: Policy{Name: "p", Schedules: []Schedule{{Name: "s", Start: "09:00", End: "18:00", Location: "America/New_York"}}}},
+		{name: "schedule day", policy: Policy{Name: "p", Schedules: []Schedule{{Name: "s", Days: []string{"someday"}, Start: "09:00", End: "18:00"}}}, wantErr: true},
+		{name: "schedule time", policy: Policy{Name: "p", Schedules: []Schedule{{Name: "s", Start: "9am", End: "18:00"}}}, wantErr: true},
+		{name: "schedule location", policy: Policy{Name: "p", Schedules: []Schedule{{Name: "s", Start: "09:00", End: "18:00", Location: "Mars/Olympus"}}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.policy.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPolicy_At(t *testing.T) {
+	p := Policy{
+		Name:           "p",
+		LimitPerWindow: 600,
+		Schedules: []Schedule{
+			{Name: "business-hours", Days: []string{"weekdays"}, Start: "09:00", End: "18:00", Location: "America/New_York", LimitPerWindow: 120},
+			{Name: "friday-night", Days: []string{"fri"}, Start: "22:00", End: "06:00", LimitPerWindow: 1200},
+		},
+	}
+
+	tests := []struct {
+		at       string
+		limit    int64
+		schedule string
+	}{
+		// Monday 2026-10-19.
+		{"2026-10-19T12:59:00Z", 600, ""},
+		{"2026-10-19T13:00:00-04:00", 120, "business-hours"},
+		{"2026-10-19T17:59:00-04:00", 120, "business-hours"},
+		{"2026-10-19T18:00:00-04:00", 600, ""},
+		// Saturday.
+		{"2026-10-24T13:00:00-04:00", 600, ""},
+		// Thursday night, then Friday night into Saturday morning, UTC.
+		{"2026-10-22T22:00:00Z", 600, ""},
+		{"2026-10-23T22:00:00Z", 1200, "friday-night"},
+		{"2026-10-24T05:59:00Z", 1200, "friday-night"},
+		{"2026-10-24T06:00:00Z", 600, ""},
+		{"2026-10-25T01:00:00Z", 600, ""},
+	}
+	run := func(t *testing.T) {
+		for _, tt := range tests {
+			t.Run(tt.at, func(t *testing.T) {
+				at, err := time.Parse(time.RFC3339, tt.at)
+				if err != nil {
+					t.Fatal(err)
+				}
+				active, schedule := p.At(at)
+				if active.LimitPerWindow != tt.limit || schedule != tt.schedule {
+					t.Errorf("At() = %d, %q, expected %d, %q", active.LimitPerWindow, schedule, tt.limit, tt.schedule)
+				}
+			})
+		}
+	}
+	t.Run("unvalidated", run)
+
+	// Validate parses the schedules once, for every copy of the policy.
+	if err := p.Validate(); err != nil {
+		t.Fatalf("Validate() unexpected error: %v", err)
+	}
+	if p.Schedules[0].loc == nil || p.Schedules[1].end != 6*time.Hour {
+		t.Fatalf("Expected Validate to keep the parsed schedules, got %+v", p.Schedules)
+	}
+	t.Run("validated", run)
+}
+
+func TestPolicy_Queued(t *testing.T) {
+	if _, _, ok := Default().Queued(); ok {
+		t.Error("Expected the default policy not to queue")
+	}
+
+	maxDelay, maxQueued, ok := Policy{Name: "p", MaxDelay: Duration(time.Second)}.Queued()
+	if !ok || maxDelay != time.Second || maxQueued != DefaultMaxQueued {
+		t.Errorf("Queued() = %v, %d, %v", maxDelay, maxQueued, ok)
+	}
+}
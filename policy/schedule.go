@@ -0,0 +1,132 @@
+package policy
+
+import (
+	"fmt"
+	"time"
+
+	"strongdm/timezone"
+)
+
+// Schedule replaces a policy's limit during certain hours of certain days,
+// such as business hours on weekdays.
+type Schedule struct {
+	// Name identifies the schedule in responses and logs.
+	Name string `json:"name"`
+
+	// Days are the days the schedule starts on: "mon" to "sun", "weekdays"
+	// or "weekends". Empty means every day.
+	Days []string `json:"days,omitempty"`
+
+	// Start and End are times of day in "15:04" format. If End is not after
+	// Start, the schedule runs past midnight into the next day. If they are
+	// equal, it runs for 24 hours.
+	Start string `json:"start"`
+	End   string `json:"end"`
+
+	// Location is the IANA time zone Days, Start and End are in, such as
+	// "America/New_York". Empty means UTC.
+	Location string `json:"location,omitempty"`
+
+	// LimitPerWindow is the limit while the schedule is active.
+	LimitPerWindow int64 `json:"limitPerWindow"`
+
+	// start, end and loc are Start, End and Location as parsed by Validate,
+	// so that Active does not parse them on every request. loc is nil until
+	// then.
+	start, end time.Duration
+	loc        *time.Location
+}
+
+// dayNames maps the accepted names in Schedule.Days to the days they cover.
+var dayNames = map[string][]time.Weekday{
+	"sun":      {time.Sunday},
+	"mon":      {time.Monday},
+	"tue":      {time.Tuesday},
+	"wed":      {time.Wednesday},
+	"thu":      {time.Thursday},
+	"fri":      {time.Friday},
+	"sat":      {time.Saturday},
+	"weekdays": {time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday},
+	"weekends": {time.Saturday, time.Sunday},
+}
+
+// Validate checks that the schedule is well formed, and keeps the parsed
+// times and location for Active.
+func (s *Schedule) Validate() error {
+	if s.Name == "" {
+		return fmt.Errorf("schedule name must not be empty")
+	}
+	for _, d := range s.Days {
+		if _, ok := dayNames[d]; !ok {
+			return fmt.Errorf("schedule %q: unknown day %q", s.Name, d)
+		}
+	}
+	start, err := parseTimeOfDay(s.Start)
+	if err != nil {
+		return fmt.Errorf("schedule %q: start: %w", s.Name, err)
+	}
+	end, err := parseTimeOfDay(s.End)
+	if err != nil {
+		return fmt.Errorf("schedule %q: end: %w", s.Name, err)
+	}
+	loc, err := timezone.Load(s.Location)
+	if err != nil {
+		return fmt.Errorf("schedule %q: %w", s.Name, err)
+	}
+	if s.LimitPerWindow < 0 {
+		return fmt.Errorf("schedule %q: limit must not be negative", s.Name)
+	}
+	s.start, s.end, s.loc = start, end, loc
+	return nil
+}
+
+// Active reports whether the schedule is in effect at t. A schedule that
+// fails Validate is never active.
+func (s Schedule) Active(t time.Time) bool {
+	start, end, loc := s.start, s.end, s.loc
+	if loc == nil {
+		// Not validated yet, so parse for this call only.
+		var err1, err2, err3 error
+		start, err1 = parseTimeOfDay(s.Start)
+		end, err2 = parseTimeOfDay(s.End)
+		loc, err3 = timezone.Load(s.Location)
+		if err1 != nil || err2 != nil || err3 != nil {
+			return false
+		}
+	}
+
+	t = t.In(loc)
+	now := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	today := t.Weekday()
+	yesterday := (today + 6) % 7
+	if start < end {
+		return s.on(today) && start <= now && now < end
+	}
+	// The schedule runs past midnight, so the early hours belong to the
+	// previous day's run.
+	return s.on(today) && now >= start || s.on(yesterday) && now < end
+}
+
+// on reports whether the schedule starts on day.
+func (s Schedule) on(day time.Weekday) bool {
+	if len(s.Days) == 0 {
+		return true
+	}
+	for _, name := range s.Days {
+		for _, d := range dayNames[name] {
+			if d == day {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// parseTimeOfDay parses a "15:04" time into the duration since midnight.
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q, must be in 15:04 format", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
@@ -0,0 +1,114 @@
+// Package proxy forwards requests to upstream services, so the rate limiter
+// can run as a gateway in front of services that cannot be modified.
+package proxy
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+
+	"strongdm/breaker"
+)
+
+// Route sends requests matching Pattern to Upstream. Pattern uses the syntax
+// of http.ServeMux, such as "/api/" or "POST /upload".
+type Route struct {
+	Pattern  string `json:"pattern"`
+	Upstream string `json:"upstream"`
+}
+
+// Option configures the proxy.
+type Option func(*options)
+
+type options struct {
+	breakers *breaker.Group
+}
+
+// WithBreakers guards each upstream with its breaker from g, so requests are
+// answered with 503 instead of being forwarded while it is open.
+func WithBreakers(g *breaker.Group) Option {
+	return func(o *options) {
+		o.breakers = g
+	}
+}
+
+// New creates a handler that proxies requests to the upstream of the most
+// specific matching route. Requests that match no route get a 404. The
+// incoming method, headers and body are preserved, and responses are
+// streamed back as they arrive.
+func New(routes []Route, opts ...Option) (http.Handler, error) {
+	if len(routes) == 0 {
+		return nil, fmt.Errorf("at least one route is required")
+	}
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	mux := http.NewServeMux()
+	for _, route := range routes {
+		rp, err := newReverseProxy(route.Upstream)
+		if err != nil {
+			return nil, err
+		}
+		var h http.Handler = rp
+		if o.breakers != nil {
+			h = breaker.Middleware(o.breakers.Breaker(route.Upstream), rp)
+		}
+		if err := register(mux, route.Pattern, h); err != nil {
+			return nil, err
+		}
+	}
+	return mux, nil
+}
+
+// ParseUpstream checks that s is an absolute http or https URL.
+func ParseUpstream(s string) (*url.URL, error) {
+	target, err := url.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upstream %q: %w", s, err)
+	}
+	if (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return nil, fmt.Errorf("invalid upstream %q: must be an absolute http or https URL", s)
+	}
+	return target, nil
+}
+
+func newReverseProxy(upstream string) (*httputil.ReverseProxy, error) {
+	target, err := ParseUpstream(upstream)
+	if err != nil {
+		return nil, err
+	}
+	return &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.SetURL(target)
+			r.SetXForwarded()
+		},
+		// Flush immediately so streamed responses reach the client as they
+		// are produced.
+		FlushInterval: -1,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			slog.Error("Upstream request failed",
+				slog.String("upstream", upstream),
+				slog.String("method", r.Method),
+				slog.String("path", r.URL.Path),
+				slog.Any("error", err),
+			)
+			http.Error(w, "Bad gateway", http.StatusBadGateway)
+		},
+	}, nil
+}
+
+// register adds h to mux, converting the panic ServeMux raises for an invalid
+// or conflicting pattern into an error.
+func register(mux *http.ServeMux, pattern string, h http.Handler) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("invalid route pattern %q: %v", pattern, r)
+		}
+	}()
+	mux.Handle(pattern, h)
+	return nil
+}
@@ -0,0 +1,159 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"strongdm/breaker"
+)
+
+func TestNew_RoutesAndPreservesRequest(t *testing.T) {
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("X-Upstream", "api")
+		_, _ = io.WriteString(w, r.Method+" "+r.URL.Path+" "+string(body))
+	}))
+	defer api.Close()
+
+	static := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Upstream", "static")
+	}))
+	defer static.Close()
+
+	h, err := New([]Route{
+		{Pattern: "/", Upstream: static.URL},
+		{Pattern: "/api/", Upstream: api.URL},
+	})
+	if err != nil {
+		t.Fatalf("New() unexpected error: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/items", strings.NewReader("payload"))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	if w.Header().Get("X-Upstream") != "api" {
+		t.Errorf("Expected request to reach the api upstream, got '%s'", w.Header().Get("X-Upstream"))
+	}
+	if w.Body.String() != "POST /api/items payload" {
+		t.Errorf("Expected method, path and body to be preserved, got '%s'", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/index.html", nil))
+	if w.Header().Get("X-Upstream") != "static" {
+		t.Errorf("Expected request to reach the static upstream, got '%s'", w.Header().Get("X-Upstream"))
+	}
+}
+
+func TestNew_Streaming(t *testing.T) {
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "first\n")
+		w.(http.Flusher).Flush()
+		<-release
+		_, _ = io.WriteString(w, "second\n")
+	}))
+	defer upstream.Close()
+
+	h, err := New([]Route{{Pattern: "/", Upstream: upstream.URL}})
+	if err != nil {
+		t.Fatalf("New() unexpected error: %v", err)
+	}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	// The first chunk must arrive before the upstream finishes
+	buf := make([]byte, len("first\n"))
+	if _, err := io.ReadFull(resp.Body, buf); err != nil || string(buf) != "first\n" {
+		t.Fatalf("Expected first chunk to be streamed, got %q, %v", buf, err)
+	}
+	close(release)
+	rest, _ := io.ReadAll(resp.Body)
+	if string(rest) != "second\n" {
+		t.Errorf("Expected second chunk, got %q", rest)
+	}
+}
+
+func TestNew_BadGateway(t *testing.T) {
+	upstream := httptest.NewServer(http.NotFoundHandler())
+	upstream.Close()
+
+	h, err := New([]Route{{Pattern: "/", Upstream: upstream.URL}})
+	if err != nil {
+		t.Fatalf("New() unexpected error: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("Expected status %d, got %d", http.StatusBadGateway, w.Code)
+	}
+}
+
+func TestNew_Breakers(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+	healthy := httptest.NewServer(http.NotFoundHandler())
+	defer healthy.Close()
+
+	breakers := breaker.New(breaker.Policy{ConsecutiveFailures: 2, Cooldown: time.Minute, Probes: 1})
+	h, err := New([]Route{
+		{Pattern: "/api/", Upstream: failing.URL},
+		{Pattern: "/v2/api/", Upstream: failing.URL},
+		{Pattern: "/", Upstream: healthy.URL},
+	}, WithBreakers(breakers))
+	if err != nil {
+		t.Fatalf("New() unexpected error: %v", err)
+	}
+
+	for _, path := range []string{"/api/a", "/v2/api/b", "/api/c", "/"} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		switch path {
+		case "/api/c":
+			if w.Code != http.StatusServiceUnavailable {
+				t.Errorf("Expected routes to a failing upstream to share an open breaker, got status %d", w.Code)
+			}
+		case "/":
+			if w.Code != http.StatusNotFound {
+				t.Errorf("Expected other upstreams to be unaffected, got status %d", w.Code)
+			}
+		}
+	}
+}
+
+func TestNew_Invalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		routes []Route
+	}{
+		{name: "no routes"},
+		{name: "relative upstream", routes: []Route{{Pattern: "/", Upstream: "localhost:8080"}}},
+		{name: "bad pattern", routes: []Route{{Pattern: "GET", Upstream: "http://localhost"}}},
+		{name: "duplicate pattern", routes: []Route{
+			{Pattern: "/", Upstream: "http://a"},
+			{Pattern: "/", Upstream: "http://b"},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(tt.routes); err == nil {
+				t.Error("Expected error")
+			}
+		})
+	}
+}
@@ -0,0 +1,317 @@
+// Package quota implements long-horizon quotas, such as 100,000 requests per
+// calendar month. Unlike the leaky buckets in package bucket, a quota does not
+// refill gradually: usage accumulates over a fixed, calendar-aligned window and
+// resets all at once when the window ends.
+//
+// Usage is kept in a Store, which can be saved to a file so that it survives
+// restarts.
+package quota
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"strongdm/timezone"
+)
+
+// Periods accepted in Quota.Period.
+const (
+	PeriodDay   = "day"
+	PeriodMonth = "month"
+)
+
+// Quota limits usage over a calendar period. The zero value is disabled.
+type Quota struct {
+	// Period is PeriodDay or PeriodMonth. Days start at midnight and months
+	// on the 1st.
+	Period string `json:"period"`
+
+	// Limit is the number of tokens allowed per period. Zero disables the
+	// quota.
+	Limit int64 `json:"limit"`
+
+	// Location is the IANA time zone periods are aligned to, such as
+	// "America/New_York". Empty means UTC.
+	Location string `json:"location,omitempty"`
+}
+
+// Enabled reports whether the quota has a limit.
+func (q Quota) Enabled() bool {
+	return q.Limit > 0
+}
+
+// Validate checks that the quota is well formed.
+func (q Quota) Validate() error {
+	if q.Limit < 0 {
+		return fmt.Errorf("quota limit must not be negative")
+	}
+	if !q.Enabled() {
+		return nil
+	}
+	if q.Period != PeriodDay && q.Period != PeriodMonth {
+		return fmt.Errorf("unknown quota period %q, must be %q or %q", q.Period, PeriodDay, PeriodMonth)
+	}
+	if _, err := timezone.Load(q.Location); err != nil {
+		return fmt.Errorf("unknown quota location %q", q.Location)
+	}
+	return nil
+}
+
+// Window returns the start and end of the period containing t.
+func (q Quota) Window(t time.Time) (start, end time.Time) {
+	loc, err := timezone.Load(q.Location)
+	if err != nil {
+		loc = time.UTC
+	}
+	t = t.In(loc)
+	switch q.Period {
+	case PeriodMonth:
+		start = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, loc)
+		return start, start.AddDate(0, 1, 0)
+	default:
+		start = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+		return start, start.AddDate(0, 0, 1)
+	}
+}
+
+// Usage describes a key's usage of a quota in the current period.
+type Usage struct {
+	Period    string `json:"period"`
+	Limit     int64  `json:"limit"`
+	Used      int64  `json:"used"`
+	Remaining int64  `json:"remaining"`
+
+	// ResetAt is when the period ends and usage resets.
+	ResetAt time.Time `json:"resetAt"`
+
+	// Allowed reports whether the tokens asked for were within the quota.
+	Allowed bool `json:"allowed"`
+}
+
+// entry is the stored usage of a key.
+type entry struct {
+	Start   time.Time `json:"start"`
+	ResetAt time.Time `json:"resetAt"`
+	Used    int64     `json:"used"`
+}
+
+// Store tracks quota usage per key. It is safe for concurrent use.
+type Store struct {
+	// path is the file usage is saved to, or empty to keep it in memory.
+	path string
+
+	// now is replaceable in tests.
+	now func() time.Time
+
+	mu    sync.Mutex
+	usage map[string]entry
+	dirty bool
+	// pruned is when entries for ended periods were last discarded.
+	pruned time.Time
+
+	// syncMu serializes Sync, which writes to a fixed temporary file.
+	syncMu sync.Mutex
+}
+
+// Open creates a store saved to path, loading any usage saved there before.
+// A missing file is not an error. If path is empty, usage is kept in memory
+// only.
+func Open(path string) (*Store, error) {
+	s := &Store{
+		path:  path,
+		now:   time.Now,
+		usage: map[string]entry{},
+	}
+	if path == "" {
+		return s, nil
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if err := s.load(f); err != nil {
+		return nil, fmt.Errorf("quota state %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// Add charges "add" tokens to key's usage of q, unless that would exceed the
+// limit, in which case nothing is charged and Allowed is false.
+func (s *Store) Add(key string, q Quota, add int64) Usage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.prune()
+	e := s.current(key, q)
+	allowed := add <= q.Limit-e.Used
+	if allowed && add > 0 {
+		e.Used += add
+		s.usage[key] = e
+		s.dirty = true
+	}
+	return usage(q, e, allowed)
+}
+
+// Peek returns key's usage of q without charging anything. Allowed reports
+// whether a single token would fit.
+func (s *Store) Peek(key string, q Quota) Usage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e := s.current(key, q)
+	return usage(q, e, e.Used < q.Limit)
+}
+
+// WouldAllow returns key's usage of q without charging anything. Allowed
+// reports whether "add" tokens would fit.
+func (s *Store) WouldAllow(key string, q Quota, add int64) Usage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e := s.current(key, q)
+	return usage(q, e, add <= q.Limit-e.Used)
+}
+
+// current returns key's entry for the period of q containing now, which is
+// empty if a new period has started. The caller must hold mu.
+func (s *Store) current(key string, q Quota) entry {
+	start, end := q.Window(s.now())
+	e := s.usage[key]
+	if !e.Start.Equal(start) || !e.ResetAt.Equal(end) {
+		e = entry{Start: start, ResetAt: end}
+	}
+	return e
+}
+
+// pruneInterval is how often entries for ended periods are discarded. Periods
+// last at least a day, so there is no need to look more often.
+const pruneInterval = time.Hour
+
+// prune discards the entries of keys whose period has ended, at most once per
+// pruneInterval, so keys that stop being seen do not use memory forever. The
+// caller must hold mu.
+func (s *Store) prune() {
+	now := s.now()
+	if now.Sub(s.pruned) < pruneInterval {
+		return
+	}
+	s.pruned = now
+	for key, e := range s.usage {
+		if !e.ResetAt.After(now) {
+			delete(s.usage, key)
+		}
+	}
+}
+
+func usage(q Quota, e entry, allowed bool) Usage {
+	return Usage{
+		Period:    q.Period,
+		Limit:     q.Limit,
+		Used:      e.Used,
+		Remaining: max(0, q.Limit-e.Used),
+		ResetAt:   e.ResetAt,
+		Allowed:   allowed,
+	}
+}
+
+// Save writes the usage of all periods that have not ended to w.
+func (s *Store) Save(w io.Writer) error {
+	now := s.now()
+
+	s.mu.Lock()
+	live := make(map[string]entry, len(s.usage))
+	for key, e := range s.usage {
+		if e.ResetAt.After(now) {
+			live[key] = e
+		}
+	}
+	s.dirty = false
+	s.mu.Unlock()
+
+	return json.NewEncoder(w).Encode(live)
+}
+
+func (s *Store) load(r io.Reader) error {
+	usage := map[string]entry{}
+	if err := json.NewDecoder(r).Decode(&usage); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.usage = usage
+	return nil
+}
+
+// Sync saves usage to the store's file if it has changed since it was last
+// saved.
+func (s *Store) Sync() error {
+	s.syncMu.Lock()
+	defer s.syncMu.Unlock()
+
+	s.mu.Lock()
+	dirty := s.dirty
+	s.mu.Unlock()
+	if s.path == "" || !dirty {
+		return nil
+	}
+
+	if err := s.write(); err != nil {
+		// Try again on the next Sync.
+		s.mu.Lock()
+		s.dirty = true
+		s.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// write saves usage to the store's file, replacing it atomically so a crash
+// mid-write cannot corrupt it.
+func (s *Store) write() error {
+	tmp := s.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := s.Save(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// Run calls Sync every interval until ctx is done, passing errors to onError.
+// Callers should Sync once more after the last Add, such as on shutdown.
+func (s *Store) Run(ctx context.Context, interval time.Duration, onError func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.Sync(); err != nil {
+				onError(err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
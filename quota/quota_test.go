@@ -0,0 +1,144 @@
+package quota
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestQuota_Window(t *testing.T) {
+	tests := []struct {
+		name  string
+		quota Quota
+		at    string
+		start string
+		end   string
+	}{
+		{"day", Quota{Period: PeriodDay, Limit: 1}, "2026-10-18T23:59:00Z", "2026-10-18T00:00:00Z", "2026-10-19T00:00:00Z"},
+		{"day in location", Quota{Period: PeriodDay, Limit: 1, Location: "America/New_York"}, "2026-10-19T02:00:00Z", "2026-10-18T00:00:00-04:00", "2026-10-19T00:00:00-04:00"},
+		{"month", Quota{Period: PeriodMonth, Limit: 1}, "2026-12-31T12:00:00Z", "2026-12-01T00:00:00Z", "2027-01-01T00:00:00Z"},
+		// The window spans the end of daylight saving time.
+		{"month in location", Quota{Period: PeriodMonth, Limit: 1, Location: "America/New_York"}, "2026-11-15T12:00:00Z", "2026-11-01T00:00:00-04:00", "2026-12-01T00:00:00-05:00"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, end := tt.quota.Window(parse(t, tt.at))
+			if !start.Equal(parse(t, tt.start)) || !end.Equal(parse(t, tt.end)) {
+				t.Errorf("Window() = %v, %v, expected %s, %s", start, end, tt.start, tt.end)
+			}
+		})
+	}
+}
+
+func TestStore_Add(t *testing.T) {
+	s, err := Open("")
+	if err != nil {
+		t.Fatalf("Open() unexpected error: %v", err)
+	}
+	now := parse(t, "2026-10-31T23:00:00Z")
+	s.now = func() time.Time { return now }
+	q := Quota{Period: PeriodMonth, Limit: 10}
+
+	if u := s.Add("alice", q, 6); !u.Allowed || u.Used != 6 || u.Remaining != 4 {
+		t.Errorf("Expected 6 of 10 used, got %+v", u)
+	}
+	if u := s.WouldAllow("alice", q, 5); u.Allowed || u.Used != 6 {
+		t.Errorf("Expected 5 more not to fit, got %+v", u)
+	}
+	if u := s.WouldAllow("alice", q, 4); !u.Allowed || u.Used != 6 {
+		t.Errorf("Expected 4 more to fit without being charged, got %+v", u)
+	}
+	if u := s.Add("alice", q, 5); u.Allowed || u.Used != 6 {
+		t.Errorf("Expected an addition over the limit to be rejected without charge, got %+v", u)
+	}
+	if u := s.Add("alice", q, math.MaxInt64); u.Allowed || u.Used != 6 {
+		t.Errorf("Expected the largest addition to be rejected without overflowing, got %+v", u)
+	}
+	if u := s.Add("alice", q, 4); !u.Allowed || u.Remaining != 0 {
+		t.Errorf("Expected the quota to be used up exactly, got %+v", u)
+	}
+	if u := s.Peek("alice", q); u.Allowed || !u.ResetAt.Equal(parse(t, "2026-11-01T00:00:00Z")) {
+		t.Errorf("Expected the quota to be exhausted until November, got %+v", u)
+	}
+	if u := s.Peek("bob", q); !u.Allowed || u.Used != 0 {
+		t.Errorf("Expected other keys to be unaffected, got %+v", u)
+	}
+
+	now = now.Add(time.Hour)
+	if u := s.Add("alice", q, 1); !u.Allowed || u.Used != 1 {
+		t.Errorf("Expected usage to reset in the new month, got %+v", u)
+	}
+}
+
+func TestStore_Prune(t *testing.T) {
+	s, err := Open("")
+	if err != nil {
+		t.Fatalf("Open() unexpected error: %v", err)
+	}
+	now := parse(t, "2026-10-18T12:00:00Z")
+	s.now = func() time.Time { return now }
+	q := Quota{Period: PeriodDay, Limit: 10}
+
+	s.Add("alice", q, 1)
+	now = now.Add(24 * time.Hour)
+	s.Add("bob", q, 1)
+	if _, ok := s.usage["alice"]; ok {
+		t.Error("Expected usage for an ended period to be discarded")
+	}
+	if _, ok := s.usage["bob"]; !ok {
+		t.Error("Expected usage for the current period to be kept")
+	}
+}
+
+func TestStore_Sync(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "quota.json")
+	s, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open() unexpected error: %v", err)
+	}
+	q := Quota{Period: PeriodDay, Limit: 10}
+	s.Add("alice", q, 3)
+	if err := s.Sync(); err != nil {
+		t.Fatalf("Sync() unexpected error: %v", err)
+	}
+
+	restored, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open() unexpected error: %v", err)
+	}
+	if u := restored.Peek("alice", q); u.Used != 3 {
+		t.Errorf("Expected usage to survive a restart, got %+v", u)
+	}
+}
+
+func TestQuota_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		quota   Quota
+		wantErr bool
+	}{
+		{name: "disabled", quota: Quota{}},
+		{name: "monthly", quota: Quota{Period: PeriodMonth, Limit: 100000, Location: "Europe/Paris"}},
+		{name: "negative limit", quota: Quota{Limit: -1}, wantErr: true},
+		{name: "period", quota: Quota{Period: "week", Limit: 1}, wantErr: true},
+		{name: "location", quota: Quota{Period: PeriodDay, Limit: 1, Location: "Nowhere"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.quota.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func parse(t *testing.T, s string) time.Time {
+	t.Helper()
+	at, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return at
+}
@@ -0,0 +1,203 @@
+// Package rls implements the Envoy external rate limit service
+// (envoy.service.ratelimit.v3.RateLimitService) on top of counter.Counter, so
+// Envoy and Istio meshes can use this service for rate limiting decisions.
+package rls
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"strings"
+	"time"
+
+	ratelimitv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/common/ratelimit/v3"
+	rlsv3 "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v3"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"strongdm/counter"
+	"strongdm/metrics"
+)
+
+// Entry matches a descriptor entry. An empty Value matches any value, giving
+// each distinct value its own bucket.
+type Entry struct {
+	Key   string `json:"key"`
+	Value string `json:"value,omitempty"`
+}
+
+// Rule assigns a limit to descriptors in Domain that contain all of Entries.
+type Rule struct {
+	Domain         string  `json:"domain"`
+	Entries        []Entry `json:"entries"`
+	LimitPerMinute int64   `json:"limitPerMinute"`
+}
+
+// Validate checks that the rule is well formed.
+func (r Rule) Validate() error {
+	if r.Domain == "" {
+		return fmt.Errorf("rule domain must not be empty")
+	}
+	if len(r.Entries) == 0 {
+		return fmt.Errorf("rule for domain %q must have at least one entry", r.Domain)
+	}
+	for _, e := range r.Entries {
+		if e.Key == "" {
+			return fmt.Errorf("rule for domain %q has an entry without a key", r.Domain)
+		}
+	}
+	if r.LimitPerMinute < 0 {
+		return fmt.Errorf("rule for domain %q: limit must not be negative", r.Domain)
+	}
+	// Envoy's RateLimit.RequestsPerUnit is a uint32.
+	if r.LimitPerMinute > math.MaxUint32 {
+		return fmt.Errorf("rule for domain %q: limit must be at most %d", r.Domain, uint32(math.MaxUint32))
+	}
+	return nil
+}
+
+// matches reports whether the descriptor falls under the rule.
+func (r Rule) matches(domain string, entries []*ratelimitv3.RateLimitDescriptor_Entry) bool {
+	if r.Domain != domain {
+		return false
+	}
+	for _, want := range r.Entries {
+		found := false
+		for _, e := range entries {
+			if e.GetKey() == want.Key && (want.Value == "" || e.GetValue() == want.Value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// Server answers Envoy rate limit requests.
+type Server struct {
+	rlsv3.UnimplementedRateLimitServiceServer
+
+	counter *counter.Counter
+	rules   []Rule
+	logger  *slog.Logger
+}
+
+// New creates a rate limit service that charges descriptors to c using the
+// first matching rule. Descriptors that match no rule are not limited.
+func New(c *counter.Counter, rules []Rule, logger *slog.Logger) (*Server, error) {
+	for _, r := range rules {
+		if err := r.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	return &Server{
+		counter: c,
+		rules:   rules,
+		logger:  logger,
+	}, nil
+}
+
+// ShouldRateLimit implements rlsv3.RateLimitServiceServer. Every descriptor is
+// charged independently, and the request is over limit if any descriptor is.
+func (s *Server) ShouldRateLimit(ctx context.Context, req *rlsv3.RateLimitRequest) (*rlsv3.RateLimitResponse, error) {
+	if req.GetDomain() == "" {
+		return nil, status.Error(codes.InvalidArgument, "domain must not be empty")
+	}
+	if len(req.GetDescriptors()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "at least one descriptor is required")
+	}
+
+	hits := int64(req.GetHitsAddend())
+	if hits == 0 {
+		hits = 1
+	}
+
+	resp := &rlsv3.RateLimitResponse{
+		OverallCode: rlsv3.RateLimitResponse_OK,
+	}
+	for _, d := range req.GetDescriptors() {
+		descriptorHits := hits
+		if d.GetHitsAddend() != nil {
+			descriptorHits = int64(d.GetHitsAddend().GetValue())
+		}
+
+		descriptorStatus := s.check(ctx, req.GetDomain(), d.GetEntries(), descriptorHits)
+		if descriptorStatus.Code == rlsv3.RateLimitResponse_OVER_LIMIT {
+			resp.OverallCode = rlsv3.RateLimitResponse_OVER_LIMIT
+		}
+		resp.Statuses = append(resp.Statuses, descriptorStatus)
+	}
+	return resp, nil
+}
+
+// check charges a single descriptor.
+func (s *Server) check(ctx context.Context, domain string, entries []*ratelimitv3.RateLimitDescriptor_Entry, hits int64) *rlsv3.RateLimitResponse_DescriptorStatus {
+	rule, ok := s.match(domain, entries)
+	if !ok {
+		return &rlsv3.RateLimitResponse_DescriptorStatus{Code: rlsv3.RateLimitResponse_OK}
+	}
+
+	key := bucketKey(domain, entries)
+	info := s.counter.Add(key, rule.LimitPerMinute, hits)
+
+	outcome := metrics.OutcomeAllowed
+	code := rlsv3.RateLimitResponse_OK
+	level := slog.LevelDebug
+	if !info.Allowed {
+		outcome = metrics.OutcomeRejected
+		code = rlsv3.RateLimitResponse_OVER_LIMIT
+		level = slog.LevelInfo
+	}
+	metrics.RLSDecisions.Add(outcome, 1)
+	s.logger.LogAttrs(ctx, level, "Rate limit service decision",
+		slog.String("key", key),
+		slog.String("domain", domain),
+		slog.String("outcome", outcome),
+		slog.Bool("allowed", info.Allowed),
+		slog.Int64("remaining", info.Remaining),
+		slog.Time("reset", info.ResetAt),
+	)
+
+	return &rlsv3.RateLimitResponse_DescriptorStatus{
+		Code: code,
+		CurrentLimit: &rlsv3.RateLimitResponse_RateLimit{
+			RequestsPerUnit: uint32(rule.LimitPerMinute),
+			Unit:            rlsv3.RateLimitResponse_RateLimit_MINUTE,
+		},
+		LimitRemaining:     uint32(info.Remaining),
+		DurationUntilReset: durationpb.New(max(0, time.Until(info.ResetAt))),
+	}
+}
+
+func (s *Server) match(domain string, entries []*ratelimitv3.RateLimitDescriptor_Entry) (Rule, bool) {
+	for _, r := range s.rules {
+		if r.matches(domain, entries) {
+			return r, true
+		}
+	}
+	return Rule{}, false
+}
+
+// escaper escapes the separators used in bucket keys, and the escape
+// character itself.
+var escaper = strings.NewReplacer("%", "%25", "|", "%7C", "=", "%3D")
+
+// bucketKey identifies the bucket for a descriptor, for example
+// "edge|remote_address=10.0.0.1|path=/login". The parts are escaped so that
+// different descriptors cannot produce the same key.
+func bucketKey(domain string, entries []*ratelimitv3.RateLimitDescriptor_Entry) string {
+	var b strings.Builder
+	escaper.WriteString(&b, domain)
+	for _, e := range entries {
+		b.WriteByte('|')
+		escaper.WriteString(&b, e.GetKey())
+		b.WriteByte('=')
+		escaper.WriteString(&b, e.GetValue())
+	}
+	return b.String()
+}
@@ -0,0 +1,171 @@
+package rls
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"testing"
+
+	ratelimitv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/common/ratelimit/v3"
+	rlsv3 "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v3"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"strongdm/counter"
+)
+
+// newTestClient serves s over an in-memory connection and returns a client
+// generated from the Envoy protos.
+func newTestClient(t *testing.T, s *Server) rlsv3.RateLimitServiceClient {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer()
+	rlsv3.RegisterRateLimitServiceServer(srv, s)
+	go func() { _ = srv.Serve(lis) }()
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return rlsv3.NewRateLimitServiceClient(conn)
+}
+
+func descriptor(kv ...string) *ratelimitv3.RateLimitDescriptor {
+	d := &ratelimitv3.RateLimitDescriptor{}
+	for i := 0; i < len(kv); i += 2 {
+		d.Entries = append(d.Entries, &ratelimitv3.RateLimitDescriptor_Entry{Key: kv[i], Value: kv[i+1]})
+	}
+	return d
+}
+
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+	s, err := New(counter.New(), []Rule{
+		{Domain: "edge", Entries: []Entry{{Key: "path", Value: "/login"}}, LimitPerMinute: 60},
+		{Domain: "edge", Entries: []Entry{{Key: "remote_address"}}, LimitPerMinute: 120},
+	}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatalf("New() unexpected error: %v", err)
+	}
+	return s
+}
+
+func TestShouldRateLimit(t *testing.T) {
+	client := newTestClient(t, newTestServer(t))
+	ctx := context.Background()
+
+	req := &rlsv3.RateLimitRequest{
+		Domain: "edge",
+		Descriptors: []*ratelimitv3.RateLimitDescriptor{
+			descriptor("remote_address", "10.0.0.1"),
+			descriptor("path", "/login"),
+			descriptor("path", "/static"),
+		},
+	}
+
+	resp, err := client.ShouldRateLimit(ctx, req)
+	if err != nil {
+		t.Fatalf("ShouldRateLimit() unexpected error: %v", err)
+	}
+	if resp.GetOverallCode() != rlsv3.RateLimitResponse_OK {
+		t.Errorf("Expected OK, got %v", resp.GetOverallCode())
+	}
+	if len(resp.GetStatuses()) != 3 {
+		t.Fatalf("Expected one status per descriptor, got %d", len(resp.GetStatuses()))
+	}
+	if got := resp.GetStatuses()[0].GetCurrentLimit().GetRequestsPerUnit(); got != 120 {
+		t.Errorf("Expected remote_address limit 120, got %d", got)
+	}
+	if got := resp.GetStatuses()[0].GetLimitRemaining(); got != 1 {
+		t.Errorf("Expected 1 remaining, got %d", got)
+	}
+	if resp.GetStatuses()[2].GetCurrentLimit() != nil {
+		t.Error("Unmatched descriptors should not report a limit")
+	}
+
+	// The /login bucket holds a single request, so the second call is over
+	// the limit while the other descriptors are still OK.
+	resp, err = client.ShouldRateLimit(ctx, req)
+	if err != nil {
+		t.Fatalf("ShouldRateLimit() unexpected error: %v", err)
+	}
+	if resp.GetOverallCode() != rlsv3.RateLimitResponse_OVER_LIMIT {
+		t.Errorf("Expected OVER_LIMIT, got %v", resp.GetOverallCode())
+	}
+	codes := []rlsv3.RateLimitResponse_Code{
+		rlsv3.RateLimitResponse_OK,
+		rlsv3.RateLimitResponse_OVER_LIMIT,
+		rlsv3.RateLimitResponse_OK,
+	}
+	for i, code := range codes {
+		if got := resp.GetStatuses()[i].GetCode(); got != code {
+			t.Errorf("Descriptor %d: expected %v, got %v", i, code, got)
+		}
+	}
+	if resp.GetStatuses()[1].GetDurationUntilReset().AsDuration() <= 0 {
+		t.Error("Expected a positive duration until reset for the over limit descriptor")
+	}
+}
+
+func TestShouldRateLimit_SeparateBucketsPerValue(t *testing.T) {
+	client := newTestClient(t, newTestServer(t))
+	ctx := context.Background()
+
+	for _, addr := range []string{"10.0.0.1", "10.0.0.2"} {
+		req := &rlsv3.RateLimitRequest{
+			Domain:      "edge",
+			Descriptors: []*ratelimitv3.RateLimitDescriptor{descriptor("remote_address", addr)},
+			HitsAddend:  2,
+		}
+		resp, err := client.ShouldRateLimit(ctx, req)
+		if err != nil {
+			t.Fatalf("ShouldRateLimit() unexpected error: %v", err)
+		}
+		if resp.GetOverallCode() != rlsv3.RateLimitResponse_OK {
+			t.Errorf("Expected %s to have its own bucket, got %v", addr, resp.GetOverallCode())
+		}
+	}
+}
+
+func TestBucketKey(t *testing.T) {
+	a := bucketKey("edge", descriptor("remote_address", "10.0.0.1|path=/login").GetEntries())
+	b := bucketKey("edge", descriptor("remote_address", "10.0.0.1", "path", "/login").GetEntries())
+	if a == b {
+		t.Errorf("Expected different descriptors to have different buckets, both got %q", a)
+	}
+	if got := bucketKey("edge", descriptor("path", "/login").GetEntries()); got != "edge|path=/login" {
+		t.Errorf("Expected plain descriptors to be left readable, got %q", got)
+	}
+}
+
+func TestShouldRateLimit_InvalidRequest(t *testing.T) {
+	client := newTestClient(t, newTestServer(t))
+
+	_, err := client.ShouldRateLimit(context.Background(), &rlsv3.RateLimitRequest{Domain: "edge"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument, got %v", err)
+	}
+}
+
+func TestNew_InvalidRule(t *testing.T) {
+	_, err := New(counter.New(), []Rule{{Domain: "edge"}}, slog.Default())
+	if err == nil {
+		t.Error("Expected error for a rule without entries")
+	}
+
+	_, err = New(counter.New(), []Rule{{Domain: "edge", Entries: []Entry{{Key: "path"}}, LimitPerMinute: 1 << 32}}, slog.Default())
+	if err == nil {
+		t.Error("Expected error for a limit that does not fit in the response")
+	}
+}
@@ -0,0 +1,108 @@
+// Package routes assigns rate limit policies to requests by method and path,
+// so that expensive endpoints such as /login can have a tighter budget than
+// the rest of a service.
+package routes
+
+import (
+	"fmt"
+	"net/http"
+
+	"strongdm/cost"
+	"strongdm/keys"
+	"strongdm/policy"
+)
+
+// Route applies a policy to requests matching Pattern, which uses the syntax
+// of http.ServeMux, such as "POST /login" or "/api/{tenant}/". The policy
+// name identifies the route and namespaces its buckets.
+type Route struct {
+	Pattern string `json:"pattern"`
+	policy.Policy
+
+	// Cost is a cost.Parse strategy for requests on this route, such as
+	// "10" or "query:page_size:100". If empty, the handler's default cost
+	// applies.
+	Cost string `json:"cost,omitempty"`
+
+	// cost is the parsed Cost, set by New.
+	cost cost.Func
+}
+
+// Validate checks that the route is well formed.
+func (r Route) Validate() error {
+	if r.Pattern == "" {
+		return fmt.Errorf("route %q: pattern must not be empty", r.Name)
+	}
+	if r.Cost != "" {
+		if _, err := cost.Parse(r.Cost); err != nil {
+			return fmt.Errorf("route %q: %w", r.Name, err)
+		}
+	}
+	return r.Policy.Validate()
+}
+
+// CostFunc returns the route's cost function, or nil if it has none.
+func (r Route) CostFunc() cost.Func {
+	return r.cost
+}
+
+// BucketKey returns the bucket for key under the route, so that limits on
+// different routes do not interfere with each other.
+func (r Route) BucketKey(key string) string {
+	return keys.Bucket(r.Name, key)
+}
+
+// Table matches requests to routes.
+type Table struct {
+	mux *http.ServeMux
+
+	// routes maps each pattern to its route.
+	routes map[string]Route
+}
+
+// New creates a table of routes. Requests are matched to the most specific
+// pattern, following the precedence rules of http.ServeMux.
+func New(routes []Route) (*Table, error) {
+	t := &Table{
+		mux:    http.NewServeMux(),
+		routes: make(map[string]Route, len(routes)),
+	}
+	names := map[string]bool{}
+	for _, r := range routes {
+		if err := r.Validate(); err != nil {
+			return nil, err
+		}
+		if names[r.Name] {
+			return nil, fmt.Errorf("duplicate route %q", r.Name)
+		}
+		names[r.Name] = true
+		if r.Cost != "" {
+			r.cost, _ = cost.Parse(r.Cost)
+		}
+		if err := register(t.mux, r.Pattern); err != nil {
+			return nil, err
+		}
+		t.routes[r.Pattern] = r
+	}
+	return t, nil
+}
+
+// Match returns the route for r, if any.
+func (t *Table) Match(r *http.Request) (Route, bool) {
+	_, pattern := t.mux.Handler(r)
+	route, ok := t.routes[pattern]
+	return route, ok
+}
+
+// register adds pattern to mux, converting the panic ServeMux raises for an
+// invalid or conflicting pattern into an error. Only the pattern is of
+// interest, so the handler is never called.
+func register(mux *http.ServeMux, pattern string) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("invalid route pattern %q: %v", pattern, r)
+		}
+	}()
+	mux.Handle(pattern, http.NotFoundHandler())
+	return nil
+}
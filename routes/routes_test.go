@@ -0,0 +1,91 @@
+package routes
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"strongdm/policy"
+)
+
+func TestMatch(t *testing.T) {
+	table, err := New([]Route{
+		{Pattern: "POST /login", Policy: policy.Policy{Name: "login", LimitPerWindow: 10}},
+		{Pattern: "/api/", Policy: policy.Policy{Name: "api", LimitPerWindow: 600}},
+		{Pattern: "GET /api/reports/{id}", Policy: policy.Policy{Name: "reports", LimitPerWindow: 30}},
+	})
+	if err != nil {
+		t.Fatalf("New() unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		method string
+		path   string
+		route  string
+	}{
+		{"POST", "/login", "login"},
+		{"GET", "/login", ""},
+		{"GET", "/api/users", "api"},
+		{"GET", "/api/reports/7", "reports"},
+		{"DELETE", "/api/reports/7", "api"},
+		{"GET", "/static/app.js", ""},
+	}
+	for _, tt := range tests {
+		route, ok := table.Match(httptest.NewRequest(tt.method, tt.path, nil))
+		if ok != (tt.route != "") || route.Name != tt.route {
+			t.Errorf("%s %s: expected route %q, got %q (matched %v)", tt.method, tt.path, tt.route, route.Name, ok)
+		}
+	}
+}
+
+func TestNew_Invalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		routes []Route
+	}{
+		{"empty pattern", []Route{{Policy: policy.Policy{Name: "login"}}}},
+		{"invalid pattern", []Route{{Pattern: "POST", Policy: policy.Policy{Name: "login"}}}},
+		{"invalid cost", []Route{{Pattern: "/login", Cost: "query:", Policy: policy.Policy{Name: "login"}}}},
+		{"invalid policy", []Route{{Pattern: "/login", Policy: policy.Policy{Name: "login", LimitPerWindow: -1}}}},
+		{"duplicate name", []Route{
+			{Pattern: "/a", Policy: policy.Policy{Name: "a"}},
+			{Pattern: "/b", Policy: policy.Policy{Name: "a"}},
+		}},
+		{"duplicate pattern", []Route{
+			{Pattern: "/a", Policy: policy.Policy{Name: "a"}},
+			{Pattern: "/a", Policy: policy.Policy{Name: "b"}},
+		}},
+	}
+	for _, tt := range tests {
+		if _, err := New(tt.routes); err == nil {
+			t.Errorf("%s: expected error", tt.name)
+		}
+	}
+}
+
+func TestCostFunc(t *testing.T) {
+	table, err := New([]Route{
+		{Pattern: "/search", Cost: "query:page_size:10", Policy: policy.Policy{Name: "search"}},
+		{Pattern: "/", Policy: policy.Policy{Name: "default"}},
+	})
+	if err != nil {
+		t.Fatalf("New() unexpected error: %v", err)
+	}
+
+	req := httptest.NewRequest("GET", "/search?page_size=100", nil)
+	route, _ := table.Match(req)
+	if fn := route.CostFunc(); fn == nil || fn(req) != 10 {
+		t.Errorf("Expected the route's cost function to charge 10")
+	}
+
+	route, _ = table.Match(httptest.NewRequest("GET", "/other", nil))
+	if route.CostFunc() != nil {
+		t.Errorf("Expected no cost function for a route without a cost")
+	}
+}
+
+func TestBucketKey(t *testing.T) {
+	r := Route{Pattern: "/login", Policy: policy.Policy{Name: "login"}}
+	if got := r.BucketKey("10.0.0.1"); got != "login/10.0.0.1" {
+		t.Errorf("Expected login/10.0.0.1, got %q", got)
+	}
+}
@@ -0,0 +1,284 @@
+// Package shed drops low priority requests when the whole service is
+// overloaded, so that capacity is kept for more important traffic. Requests
+// are classified into priority tiers by header, route or key, and each tier
+// below the highest is shed once a global load signal passes its threshold.
+//
+// Shedding is independent of the per-key limits in package counter. It only
+// considers requests those limits allowed, and a client well within its rate
+// limit can still be shed.
+package shed
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"strongdm/metrics"
+)
+
+// Load signals accepted by New.
+const (
+	// SignalInFlight measures load as the number of requests in flight,
+	// relative to Capacity.
+	SignalInFlight = "in-flight"
+
+	// SignalLatency measures load as the smoothed latency of recent
+	// requests, relative to TargetLatency.
+	SignalLatency = "latency"
+)
+
+// DefaultStart is the load at which the lowest tier is shed if Config.Start
+// is zero.
+const DefaultStart = 0.8
+
+const (
+	// smoothing is the weight of each new sample in the average latency.
+	smoothing = 0.2
+
+	// staleAfter is how long the average latency is trusted without a new
+	// sample. Without it, a service that shed everything but its highest
+	// tier could stay shedding after recovering if that tier is idle.
+	staleAfter = time.Second
+)
+
+// Rule assigns Priority to requests that match all of its non-empty fields.
+// Higher priorities are more important.
+type Rule struct {
+	Priority int `json:"priority"`
+
+	// Header matches requests carrying the named header, with the value
+	// Value if that is set.
+	Header string `json:"header,omitempty"`
+	Value  string `json:"value,omitempty"`
+
+	// Route matches requests whose per-route policy has this name.
+	Route string `json:"route,omitempty"`
+
+	// Key is a path.Match pattern matched against the request's rate limit
+	// key, such as "partner-*".
+	Key string `json:"key,omitempty"`
+}
+
+// Validate checks that the rule is well formed.
+func (r Rule) Validate() error {
+	if r.Header == "" && r.Route == "" && r.Key == "" {
+		return fmt.Errorf("priority rule must set a header, route or key")
+	}
+	if r.Value != "" && r.Header == "" {
+		return fmt.Errorf("priority rule value %q requires a header", r.Value)
+	}
+	if _, err := path.Match(r.Key, ""); err != nil {
+		return fmt.Errorf("priority rule key %q: %w", r.Key, err)
+	}
+	return nil
+}
+
+func (r Rule) matches(req *http.Request, key, route string) bool {
+	if r.Header != "" {
+		values := req.Header.Values(r.Header)
+		if len(values) == 0 || r.Value != "" && !slices.Contains(values, r.Value) {
+			return false
+		}
+	}
+	if r.Route != "" && r.Route != route {
+		return false
+	}
+	if r.Key != "" {
+		if ok, _ := path.Match(r.Key, key); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Config describes how requests are classified and when they are shed.
+type Config struct {
+	Signal string
+
+	// Capacity is the number of requests in flight at full load, used by
+	// SignalInFlight.
+	Capacity int
+
+	// TargetLatency is the average latency at full load, used by
+	// SignalLatency.
+	TargetLatency time.Duration
+
+	// Start is the load, from 0 to 1, at which the lowest tier is shed.
+	// Higher tiers are shed at evenly spaced loads between Start and full
+	// load. The highest tier is never shed. Zero means DefaultStart.
+	Start float64
+
+	// DefaultPriority is the priority of requests that match no rule.
+	DefaultPriority int
+
+	// Rules are tried in order, and the first match decides the priority.
+	Rules []Rule
+}
+
+// Validate checks that the configuration is well formed.
+func (c Config) Validate() error {
+	switch c.Signal {
+	case SignalInFlight:
+		if c.Capacity <= 0 {
+			return fmt.Errorf("capacity must be positive")
+		}
+	case SignalLatency:
+		if c.TargetLatency <= 0 {
+			return fmt.Errorf("target latency must be positive")
+		}
+	default:
+		return fmt.Errorf("unknown signal %q, must be %q or %q", c.Signal, SignalInFlight, SignalLatency)
+	}
+	if c.Start < 0 || c.Start > 1 {
+		return fmt.Errorf("start must be between 0 and 1")
+	}
+	for _, r := range c.Rules {
+		if err := r.Validate(); err != nil {
+			return err
+		}
+	}
+	// With a single priority there is no lower tier to shed first, and the
+	// highest tier is never shed.
+	if !slices.ContainsFunc(c.Rules, func(r Rule) bool { return r.Priority != c.DefaultPriority }) {
+		return fmt.Errorf("a rule must give some requests a priority other than the default, or nothing is shed")
+	}
+	return nil
+}
+
+// Shedder classifies requests and sheds them under load. It is safe for
+// concurrent use.
+type Shedder struct {
+	cfg Config
+
+	// tiers are the distinct priorities in use, lowest first.
+	tiers []int
+
+	// now is replaceable in tests.
+	now func() time.Time
+
+	mu         sync.Mutex
+	inFlight   int
+	latency    time.Duration
+	lastSample time.Time
+}
+
+// New creates a shedder.
+func New(cfg Config) (*Shedder, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if cfg.Start == 0 {
+		cfg.Start = DefaultStart
+	}
+
+	tiers := []int{cfg.DefaultPriority}
+	for _, r := range cfg.Rules {
+		tiers = append(tiers, r.Priority)
+	}
+	slices.Sort(tiers)
+
+	return &Shedder{
+		cfg:   cfg,
+		tiers: slices.Compact(tiers),
+		now:   time.Now,
+	}, nil
+}
+
+// Classify returns the priority of a request with the given rate limit key,
+// matched by the per-route policy named route.
+func (s *Shedder) Classify(r *http.Request, key, route string) int {
+	for _, rule := range s.cfg.Rules {
+		if rule.matches(r, key, route) {
+			return rule.Priority
+		}
+	}
+	return s.cfg.DefaultPriority
+}
+
+// Load returns the current load, where 1 is full load.
+func (s *Shedder) Load() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.load()
+}
+
+func (s *Shedder) load() float64 {
+	switch s.cfg.Signal {
+	case SignalInFlight:
+		return float64(s.inFlight) / float64(s.cfg.Capacity)
+	case SignalLatency:
+		if s.now().Sub(s.lastSample) > staleAfter {
+			return 0
+		}
+		return float64(s.latency) / float64(s.cfg.TargetLatency)
+	}
+	return 0
+}
+
+// Token is held by an admitted request while it is in flight.
+type Token struct {
+	s     *Shedder
+	start time.Time
+}
+
+// Admit admits a request of the given priority, or returns false if its tier
+// is being shed. The caller must call Done or Cancel on the token when the
+// request ends.
+func (s *Shedder) Admit(priority int) (*Token, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	load := s.load()
+	metrics.Load.Set(load)
+	if threshold, ok := s.threshold(priority); ok && load >= threshold {
+		metrics.ShedPriorities.Add(strconv.Itoa(priority), 1)
+		return nil, false
+	}
+	s.inFlight++
+	return &Token{s: s, start: s.now()}, true
+}
+
+// threshold returns the load at which priority is shed, or false if it is
+// never shed. A priority that no rule assigns is treated like the nearest
+// tier below it, or the lowest tier if there is none.
+func (s *Shedder) threshold(priority int) (float64, bool) {
+	i, found := slices.BinarySearch(s.tiers, priority)
+	if !found && i > 0 {
+		i--
+	}
+	if i == len(s.tiers)-1 {
+		return 0, false
+	}
+	return s.cfg.Start + (1-s.cfg.Start)*float64(i)/float64(len(s.tiers)-1), true
+}
+
+// Done ends the request, recording its latency.
+func (t *Token) Done() {
+	t.s.release(t.s.now().Sub(t.start), true)
+}
+
+// Cancel ends a request that was turned away after being admitted, without
+// recording its latency.
+func (t *Token) Cancel() {
+	t.s.release(0, false)
+}
+
+func (s *Shedder) release(latency time.Duration, sample bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.inFlight--
+	if !sample {
+		return
+	}
+	if s.latency == 0 || s.now().Sub(s.lastSample) > staleAfter {
+		s.latency = latency
+	} else {
+		s.latency = time.Duration(float64(s.latency)*(1-smoothing) + float64(latency)*smoothing)
+	}
+	s.lastSample = s.now()
+}
@@ -0,0 +1,161 @@
+package shed
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestClassify(t *testing.T) {
+	s, err := New(Config{
+		Signal:          SignalInFlight,
+		Capacity:        10,
+		DefaultPriority: 1,
+		Rules: []Rule{
+			{Priority: 3, Header: "X-Priority", Value: "critical"},
+			{Priority: 0, Header: "X-Priority"},
+			{Priority: 2, Route: "checkout"},
+			{Priority: 0, Key: "batch-*"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("New() unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		header string
+		key    string
+		route  string
+		want   int
+	}{
+		{"default", "", "alice", "default", 1},
+		{"header value", "critical", "alice", "default", 3},
+		{"header present", "low", "alice", "checkout", 0},
+		{"route", "", "alice", "checkout", 2},
+		{"key", "", "batch-7", "default", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			if tt.header != "" {
+				req.Header.Set("X-Priority", tt.header)
+			}
+			if got := s.Classify(req, tt.key, tt.route); got != tt.want {
+				t.Errorf("Expected priority %d, got %d", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestAdmit_InFlight(t *testing.T) {
+	s, err := New(Config{
+		Signal:          SignalInFlight,
+		Capacity:        10,
+		Start:           0.5,
+		DefaultPriority: 1,
+		Rules: []Rule{
+			{Priority: 0, Key: "batch"},
+			{Priority: 2, Key: "admin"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("New() unexpected error: %v", err)
+	}
+
+	// Priority 0 is shed from a load of 0.5, priority 1 from 0.75, and
+	// priority 2 never.
+	var tokens []*Token
+	admit := func(priority int) bool {
+		token, ok := s.Admit(priority)
+		if ok {
+			tokens = append(tokens, token)
+		}
+		return ok
+	}
+	for range 5 {
+		if !admit(1) {
+			t.Fatal("Expected requests to be admitted below the start load")
+		}
+	}
+	if admit(0) {
+		t.Error("Expected the lowest tier to be shed at a load of 0.5")
+	}
+	if !admit(1) || !admit(1) || !admit(1) {
+		t.Error("Expected the middle tier to be admitted below a load of 0.75")
+	}
+	if admit(1) {
+		t.Error("Expected the middle tier to be shed at a load of 0.8")
+	}
+	for range 10 {
+		if !admit(2) {
+			t.Fatal("Expected the highest tier never to be shed")
+		}
+	}
+	if admit(-1) {
+		t.Error("Expected an unknown priority below every tier to be shed like the lowest")
+	}
+
+	for _, token := range tokens {
+		token.Cancel()
+	}
+	if s.Load() != 0 {
+		t.Errorf("Expected no load once every request ended, got %v", s.Load())
+	}
+	if !admit(0) {
+		t.Error("Expected the lowest tier to be admitted again once load fell")
+	}
+}
+
+func TestAdmit_Latency(t *testing.T) {
+	s, err := New(Config{
+		Signal:        SignalLatency,
+		TargetLatency: 100 * time.Millisecond,
+		Rules:         []Rule{{Priority: 1, Header: "X-Critical"}},
+	})
+	if err != nil {
+		t.Fatalf("New() unexpected error: %v", err)
+	}
+	now := time.Now()
+	s.now = func() time.Time { return now }
+
+	token, _ := s.Admit(1)
+	now = now.Add(90 * time.Millisecond)
+	token.Done()
+
+	if got := s.Load(); got != 0.9 {
+		t.Errorf("Expected a load of 0.9, got %v", got)
+	}
+	if _, ok := s.Admit(0); ok {
+		t.Error("Expected the lowest tier to be shed above the default start load")
+	}
+
+	now = now.Add(staleAfter + time.Millisecond)
+	if _, ok := s.Admit(0); !ok {
+		t.Error("Expected a stale latency not to shed requests")
+	}
+}
+
+func TestConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+	}{
+		{"signal", Config{Signal: "cpu"}},
+		{"capacity", Config{Signal: SignalInFlight}},
+		{"target latency", Config{Signal: SignalLatency}},
+		{"start", Config{Signal: SignalInFlight, Capacity: 1, Start: 2}},
+		{"empty rule", Config{Signal: SignalInFlight, Capacity: 1, Rules: []Rule{{Priority: 1}}}},
+		{"value without header", Config{Signal: SignalInFlight, Capacity: 1, Rules: []Rule{{Key: "a", Value: "b"}}}},
+		{"key pattern", Config{Signal: SignalInFlight, Capacity: 1, Rules: []Rule{{Key: "["}}}},
+		{"no rules", Config{Signal: SignalInFlight, Capacity: 1}},
+		{"single priority", Config{Signal: SignalInFlight, Capacity: 1, DefaultPriority: 1, Rules: []Rule{{Priority: 1, Header: "X-Priority"}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.cfg.Validate(); err == nil {
+				t.Error("Expected an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,29 @@
+// Package timezone loads IANA time zones by name, caching them since
+// time.LoadLocation reads the zone database on every call.
+package timezone
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// locations caches loaded time zones.
+var locations sync.Map
+
+// Load returns the IANA time zone named name, such as "America/New_York", or
+// UTC if name is empty.
+func Load(name string) (*time.Location, error) {
+	if name == "" {
+		return time.UTC, nil
+	}
+	if loc, ok := locations.Load(name); ok {
+		return loc.(*time.Location), nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown location %q", name)
+	}
+	locations.Store(name, loc)
+	return loc, nil
+}
@@ -0,0 +1,43 @@
+// Package version holds build metadata injected at link time, for example:
+//
+//	go build -ldflags "-X strongdm/version.Version=v1.2.3 -X strongdm/version.Commit=abc123"
+package version
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+)
+
+// Build metadata. These are variables rather than constants so the linker can
+// set them.
+var (
+	Version   = "dev"
+	Commit    = "unknown"
+	BuildDate = "unknown"
+)
+
+// Info is the build metadata reported by Handler.
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"buildDate"`
+	GoVersion string `json:"goVersion"`
+}
+
+// Get returns the build metadata of the running binary.
+func Get() Info {
+	return Info{
+		Version:   Version,
+		Commit:    Commit,
+		BuildDate: BuildDate,
+		GoVersion: runtime.Version(),
+	}
+}
+
+// Handler serves the build metadata as JSON.
+func Handler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	jsonData, _ := json.MarshalIndent(Get(), "", "  ")
+	_, _ = w.Write(jsonData)
+}